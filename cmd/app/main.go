@@ -30,6 +30,9 @@ func main() {
 	}
 
 	log.Printf("Configuration loaded: %+v\n", cfg)
+	if cfg.ControllerMode {
+		log.Println("Running in controller mode: deploying the Application resources of the cluster.")
+	}
 
 	// Create a new App instance
 	application, err := app.NewApp(cfg)
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// applicationsPath prefixes the operation endpoints of an application:
+// POST /api/applications/<name>/rollback and POST /api/applications/<name>/resume.
+const applicationsPath = "/api/applications/"
+
+// rollbackRequest is the optional body of a rollback request.
+type rollbackRequest struct {
+	// Commit to roll back to; empty selects the most recent successful sync
+	// before the current commit.
+	Commit string `json:"commit"`
+}
+
+// handleApplicationAction serves the operation endpoints of an application.
+// Requests must carry the API token as a bearer token.
+func (a *App) handleApplicationAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.cfg.APIToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Application names of controller mode contain a slash, so the action is
+	// the last path segment.
+	rest := strings.TrimPrefix(r.URL.Path, applicationsPath)
+	slash := strings.LastIndex(rest, "/")
+	if slash <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	name, action := rest[:slash], rest[slash+1:]
+	app := a.application(name)
+	if app == nil {
+		http.Error(w, "unknown application", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "rollback":
+		if a.cfg.DiffOnly {
+			http.Error(w, "rollback is not available in diff-only mode", http.StatusConflict)
+			return
+		}
+		var request rollbackRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		commitHash, err := app.requestRollback(request.Commit)
+		if err != nil {
+			writeActionError(w, err)
+			return
+		}
+		log.Printf("Rollback of application %s to commit %s requested.", name, commitHash)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(rollbackRequest{Commit: commitHash})
+
+	case "resume":
+		if err := app.requestResume(); err != nil {
+			writeActionError(w, err)
+			return
+		}
+		log.Printf("Resume of auto-sync of application %s requested.", name)
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeActionError responds with err, as a conflict if it concerns the state
+// of the application and as a bad request otherwise.
+func writeActionError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errRollbackPending), errors.Is(err, errNotPaused), errors.Is(err, errNotPolled), errors.Is(err, errNoPrevious):
+		status = http.StatusConflict
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// application returns the running application called name, or nil.
+func (a *App) application(name string) *application {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, app := range a.applications {
+		if app.spec.Name == name {
+			return app
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/user/go-argo-lite/internal/config"
+)
+
+func TestHandleApplicationAction(t *testing.T) {
+	t.Helper()
+	cfg := &config.Config{APIToken: "t0ken", StateDir: t.TempDir(), SyncHistoryLimit: 10}
+	app := newApplication(cfg, config.Application{Name: "team-a/web"}, nil, nil, nil, newFileStateStore(cfg.StateDir, "team-a/web", cfg.SyncHistoryLimit))
+	a := &App{cfg: cfg, applications: []*application{app}}
+
+	serve := func(path, token string) int {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		a.handleApplicationAction(recorder, request)
+		return recorder.Code
+	}
+
+	cases := []struct {
+		path, token string
+		expected    int
+	}{
+		{"/api/applications/team-a/web/resume", "", http.StatusUnauthorized},
+		{"/api/applications/team-a/web/resume", "wrong", http.StatusUnauthorized},
+		{"/api/applications/team-a/api/resume", "t0ken", http.StatusNotFound},
+		{"/api/applications/team-a/web/restart", "t0ken", http.StatusNotFound},
+		{"/api/applications/team-a/web/resume", "t0ken", http.StatusConflict},   // Not paused
+		{"/api/applications/team-a/web/rollback", "t0ken", http.StatusConflict}, // Not polled yet
+	}
+	for _, c := range cases {
+		if code := serve(c.path, c.token); code != c.expected {
+			t.Errorf("POST %s with token %q: expected status %d, got %d", c.path, c.token, c.expected, code)
+		}
+	}
+
+	app.state.Rollback = &RollbackState{Commit: "a", BranchCommit: "b"}
+	if code := serve("/api/applications/team-a/web/resume", "t0ken"); code != http.StatusAccepted {
+		t.Errorf("expected status 202 when resuming a paused application, got %d", code)
+	}
+	if !app.resume || len(app.pollNow) != 1 {
+		t.Error("expected resume to request a poll")
+	}
+}
@@ -1,24 +1,76 @@
 package app
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
-	"time"
 
 	"github.com/user/go-argo-lite/internal/config"
 	"github.com/user/go-argo-lite/internal/gitpoller"
 	"github.com/user/go-argo-lite/internal/kubehandler"
+	"github.com/user/go-argo-lite/internal/webhook"
 )
 
-// App orchestrates the git polling and Kubernetes manifest application.
+// App orchestrates the git polling and Kubernetes manifest application of every
+// configured application.
 type App struct {
-	cfg         *config.Config
-	poller      *gitpoller.GitPoller
-	kubeHandler *kubehandler.KubeHandler
-	// logger    *log.Logger // Using global log for now
+	cfg          *config.Config
+	clones       []*repoClone
+	mu           sync.Mutex // Guards applications, which change at run time in controller mode
+	applications []*application
+	controller   *controller            // Manages applications from Application resources; nil outside controller mode
+	decryptor    *kubehandler.Decryptor // Decrypts SOPS-encrypted manifests; nil without keys
+	states       *stateStores           // Persist the state of each application
+	cloneDir     *cloneDir              // Holds the clones; locked until shutdown
+}
+
+// repoClone is the local working copy of one repository revision, shared by
+// every application that deploys from it.
+type repoClone struct {
+	mu          sync.Mutex           // Serializes polling and reading of the working copy
+	poller      *gitpoller.GitPoller // Initializes the clone; applications poll through shares of it
+	initialized bool
+	generation  int // Incremented whenever the clone is replaced; see reclone
+}
+
+// initialize clones or opens the repository unless that already succeeded.
+func (c *repoClone) initialize() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.initialized {
+		return nil
+	}
+	log.Printf("Initializing repository at %s (revision: %s)...", c.poller.RepoURL(), c.poller.Revision())
+	err := c.poller.InitializeRepo()
+	if gitpoller.ClassifyError(err) == gitpoller.ErrorCorrupted {
+		log.Printf("The local clone of %s is corrupted: %v", c.poller.RepoURL(), err)
+		err = c.poller.Reclone()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to initialize repository %s: %w", c.poller.RepoURL(), err)
+	}
+	c.initialized = true
+	return nil
+}
+
+// reclone deletes the corrupted clone and clones the repository again. The
+// applications sharing the clone switch to the new one the next time they
+// lock it; see application.lockClone.
+func (c *repoClone) reclone() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.poller.Reclone(); err != nil {
+		// The shares keep failing on the deleted clone, so the next poll
+		// tries again.
+		return fmt.Errorf("failed to clone repository %s again: %w", c.poller.RepoURL(), err)
+	}
+	c.generation++
+	return nil
 }
 
 // NewApp creates a new application instance.
@@ -27,100 +79,208 @@ func NewApp(cfg *config.Config) (*App, error) {
 		return nil, fmt.Errorf("config cannot be nil")
 	}
 
-	// Define a local path for the git clone. This could be made configurable later.
-	// For example, create a temporary directory or use a path from cfg.
-	localRepoPath := "./.gitrepo" // TODO: Consider making this configurable or a temp dir
-
-	poller, err := gitpoller.NewGitPoller(cfg.RepoURL, cfg.RepoBranch, localRepoPath, cfg.ManifestPath)
+	auth, err := gitpoller.NewAuthMethod(gitpoller.AuthConfig{
+		Username:          cfg.GitUsername,
+		Password:          cfg.GitPassword,
+		SSHUser:           cfg.GitSSHUser,
+		SSHPrivateKey:     []byte(cfg.GitSSHPrivateKey),
+		SSHKeyPassphrase:  cfg.GitSSHKeyPassphrase,
+		SSHKnownHostsPath: cfg.GitSSHKnownHostsPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure Git authentication: %w", err)
+	}
+	verifier, err := gitpoller.NewSignatureVerifier(cfg.GitTrustedGPGKeys, cfg.GitAllowedSigners)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure commit signature verification: %w", err)
+	}
+	if verifier != nil {
+		log.Println("Only commits signed by a trusted key will be deployed.")
+	}
+	pollerOpts := []gitpoller.Option{
+		gitpoller.WithAuth(auth),
+		gitpoller.WithSignatureVerifier(verifier),
+		gitpoller.WithDepth(cfg.GitCloneDepth),
+		gitpoller.WithSparseCheckout(cfg.GitSparseCheckout),
+		gitpoller.WithBareClone(cfg.GitBareClone),
+	}
+	decryptor, err := kubehandler.NewDecryptor(cfg.SopsAgeKey, cfg.SopsPGPKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GitPoller: %w", err)
+		return nil, fmt.Errorf("failed to configure SOPS decryption: %w", err)
 	}
 
-	kubeHandler, err := kubehandler.NewKubeHandler(cfg.KubeconfigPath)
+	cloneDir, err := openCloneDir(cfg.CloneDir)
+	if err != nil {
+		return nil, err
+	}
+	localRepoPath := cloneDir.clonePath("repo")
+	if cfg.StateDir == "" {
+		// Keep the state of this instance next to its clones, locked with them.
+		withState := *cfg
+		withState.StateDir = cloneDir.statePath()
+		cfg = &withState
+	}
+	states, err := newStateStores(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create KubeHandler: %w", err)
+		cloneDir.close(false)
+		return nil, err
 	}
 
-	log.Println("Application components initialized successfully.")
-	return &App{
-		cfg:         cfg,
-		poller:      poller,
-		kubeHandler: kubeHandler,
-	}, nil
+	a := &App{cfg: cfg, decryptor: decryptor, states: states, cloneDir: cloneDir}
+	if cfg.ControllerMode {
+		a.controller, err = newController(a, pollerOpts, localRepoPath)
+		if err != nil {
+			cloneDir.close(false)
+			return nil, err
+		}
+		log.Println("Application components initialized successfully in controller mode.")
+		return a, nil
+	}
+
+	clones := make(map[string]*repoClone)
+	kubeHandlers := make(map[string]*kubehandler.KubeHandler)
+	sources := countSources(cfg.Applications)
+	for _, spec := range cfg.Applications {
+		source := spec.RepoURL + "#" + spec.Revision
+		clone, ok := clones[source]
+		if !ok {
+			clonePath := localRepoPath
+			if sources > 1 {
+				clonePath = localRepoPath + "-" + shortHash(source)
+			}
+			poller, err := gitpoller.NewGitPoller(spec.RepoURL, spec.Revision, clonePath, spec.Path, pollerOpts...)
+			if err != nil {
+				cloneDir.close(false)
+				return nil, fmt.Errorf("failed to create GitPoller for application %s: %w", spec.Name, err)
+			}
+			clone = &repoClone{poller: poller}
+			clones[source] = clone
+			a.clones = append(a.clones, clone)
+		}
+
+		kubeconfigPath := spec.Destination.Kubeconfig
+		if kubeconfigPath == "" {
+			kubeconfigPath = cfg.KubeconfigPath
+		}
+		kubeHandler, ok := kubeHandlers[kubeconfigPath]
+		if !ok {
+			kubeHandler, err = kubehandler.NewKubeHandler(kubeconfigPath)
+			if err != nil {
+				cloneDir.close(false)
+				return nil, fmt.Errorf("failed to create KubeHandler for application %s: %w", spec.Name, err)
+			}
+			kubeHandlers[kubeconfigPath] = kubeHandler
+		}
+
+		if cfg.AppsFile != "" {
+			// Keep applications on the same cluster from pruning each other's objects.
+			kubeHandler = kubeHandler.ForApplication(spec.Name)
+			if spec.AdoptUnscopedObjects {
+				kubeHandler = kubeHandler.AdoptUnscoped()
+			}
+		}
+		a.applications = append(a.applications, newApplication(cfg, spec, clone, kubeHandler, decryptor, states.forApplication(spec.Name)))
+	}
+
+	log.Printf("Application components initialized successfully: %d application(s) from %d repository clone(s).", len(a.applications), len(a.clones))
+	return a, nil
 }
 
-// Run starts the main application loop: polls the Git repository for changes
-// and applies manifest files to Kubernetes if new commits are detected.
-// It also handles graceful shutdown on interrupt signals.
+// countSources returns the number of distinct repository revisions deployed by applications.
+func countSources(applications []config.Application) int {
+	sources := make(map[string]bool)
+	for _, spec := range applications {
+		sources[spec.RepoURL+"#"+spec.Revision] = true
+	}
+	return len(sources)
+}
+
+// shortHash returns a short, stable, filesystem-safe digest of s.
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Run clones or opens every repository, then runs the loop of each application
+// concurrently: it polls the Git repository for changes and applies manifest
+// files to Kubernetes if new commits are detected. It also handles graceful
+// shutdown on interrupt signals.
 func (a *App) Run() error {
+	if a.controller != nil {
+		return a.controller.run()
+	}
 	log.Println("Starting application run loop...")
 
 	// Initial Repository Setup
-	log.Printf("Initializing repository at %s (branch: %s)...", a.cfg.RepoURL, a.cfg.RepoBranch)
-	if err := a.poller.InitializeRepo(); err != nil {
-		return fmt.Errorf("failed to initialize repository: %w", err)
+	for _, clone := range a.clones {
+		if err := clone.initialize(); err != nil {
+			a.cloneDir.close(false)
+			return err
+		}
 	}
-	log.Println("Repository initialized successfully.")
-
-	// Setup ticker for polling interval
-	ticker := time.NewTicker(time.Duration(a.cfg.PollIntervalSeconds) * time.Second)
-	defer ticker.Stop()
+	log.Println("Repositories initialized successfully.")
 
 	// Setup channel for OS signals for graceful shutdown
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
-	log.Printf("Starting polling loop every %d seconds. Press Ctrl+C to exit.", a.cfg.PollIntervalSeconds)
+	server, err := a.startHTTPServer()
+	if err != nil {
+		a.cloneDir.close(false)
+		return err
+	}
 
-	// Main application loop
-	for {
-		select {
-		case <-ticker.C:
-			log.Println("Polling for changes...")
-			changed, commitHash, manifestFiles, err := a.poller.Poll()
-			if err != nil {
-				log.Printf("Error during repository poll: %v. Continuing...", err)
-				// Depending on the error, might want to implement backoff or exit
-				continue
-			}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, app := range a.applications {
+		app.poller = app.clone.poller.Share(app.spec.Path)
+		wg.Add(1)
+		go func(app *application) {
+			defer wg.Done()
+			app.run(stop)
+		}(app)
+	}
 
-			if changed {
-				log.Printf("Changes detected! New commit: %s", commitHash)
-				if len(manifestFiles) == 0 {
-					log.Printf("No manifest files found in '%s' for commit %s.", a.cfg.ManifestPath, commitHash)
-				} else {
-					log.Printf("Found %d manifest files to apply for commit %s:", len(manifestFiles), commitHash)
-					for _, filePath := range manifestFiles {
-						log.Printf(" - %s", filePath)
-					}
-
-					var applyErrors []string
-					for _, filePath := range manifestFiles {
-						log.Printf("Applying manifest: %s", filePath)
-						if applyErr := a.kubeHandler.ApplyManifestFile(filePath); applyErr != nil {
-							log.Printf("Error applying manifest %s: %v", filePath, applyErr)
-							applyErrors = append(applyErrors, fmt.Sprintf("%s: %v", filePath, applyErr))
-						} else {
-							log.Printf("Successfully applied manifest: %s", filePath)
-						}
-					}
-
-					if len(applyErrors) > 0 {
-						log.Printf("Finished applying manifests for commit %s with %d error(s).", commitHash, len(applyErrors))
-						// Potentially log details of applyErrors
-					} else {
-						log.Printf("All manifest files for commit %s applied successfully.", commitHash)
-					}
-				}
-			} else {
-				log.Printf("No new changes detected. Current commit: %s", commitHash)
-			}
+	sig := <-signalChan
+	log.Printf("Received signal: %s. Shutting down gracefully...", sig)
+	close(stop)
+	wg.Wait()
+	a.stopHTTPServer(server)
+	a.cloneDir.close(a.cfg.CloneDirCleanup)
+	return nil
+}
+
+// triggerPoll asks every application whose revision push may move, see
+// gitpoller.RevisionMovedBy, to poll as soon as it is idle, if it deploys from
+// the pushed repository. It reports whether any application was asked.
+func (a *App) triggerPoll(push webhook.Push) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	triggered := false
+	for _, app := range a.applications {
+		if push.MatchesRepository(app.spec.RepoURL) && gitpoller.RevisionMovedBy(app.spec.Revision, push.Ref) {
+			app.triggerPoll()
+			triggered = true
+		}
+	}
+	return triggered
+}
+
+// addApplication adds app to the applications reachable through the HTTP server.
+func (a *App) addApplication(app *application) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.applications = append(a.applications, app)
+}
 
-		case sig := <-signalChan:
-			log.Printf("Received signal: %s. Shutting down gracefully...", sig)
-			// Perform any cleanup here if necessary (e.g., delete localRepoPath)
-			// For now, just exit.
-			return nil
+// removeApplication removes app from the applications reachable through the HTTP server.
+func (a *App) removeApplication(app *application) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, candidate := range a.applications {
+		if candidate == app {
+			a.applications = append(a.applications[:i], a.applications[i+1:]...)
+			return
 		}
 	}
 }
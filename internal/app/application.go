@@ -0,0 +1,414 @@
+package app
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/user/go-argo-lite/internal/config"
+	"github.com/user/go-argo-lite/internal/gitpoller"
+	"github.com/user/go-argo-lite/internal/kubehandler"
+)
+
+// application runs the poll, sync and drift detection loop of one configured
+// application.
+type application struct {
+	cfg         *config.Config
+	spec        config.Application
+	clone       *repoClone
+	poller      *gitpoller.GitPoller // Share of clone.poller; set once the clone is initialized
+	kubeHandler *kubehandler.KubeHandler
+	decryptor   *kubehandler.Decryptor // Decrypts SOPS-encrypted manifests; nil without keys
+	logger      *log.Logger            // Prefixes messages with the application name
+	store       stateStore             // Persists the sync history, rollback and last sync
+	pollNow     chan struct{}          // Wakes up the run loop to poll before the next tick
+	rollbackNow chan string            // Wakes up the run loop to roll back to the commit sent
+	// lastFullSyncAt is the start of the most recent sync that applied all
+	// manifests, or of the run loop if it resumed from the state of a previous
+	// run; it is only used by the run loop.
+	lastFullSyncAt time.Time
+	// cloneGeneration is the generation of clone that poller uses; it is
+	// guarded by clone.mu.
+	cloneGeneration int
+
+	// The state below is written by the run loop and read by the HTTP server.
+	mu             sync.Mutex
+	startedAt      time.Time    // Start of the run loop
+	lastPolledAt   time.Time    // Time of the most recent successful poll
+	lastCommitHash string       // Commit found by the most recent successful poll
+	lastPollErr    error        // Error of the most recent poll, nil if it succeeded
+	lastSync       *SyncResult  // Outcome of the most recent sync, nil before the first one
+	syncedCommit   string       // Commit of lastSync, or a later one that changed none of its manifest files
+	appliedCommit  string       // Commit of the most recent successful sync, or a later one like syncedCommit
+	retry          *SyncRetry   // Retries of the failed sync of lastSync, nil if it succeeded
+	pollFailures   int          // Consecutive failed polls
+	nextPollAt     *time.Time   // End of the backoff after pollFailures, nil while polls succeed or without backoff
+	lastDrift      *DriftReport // Outcome of the most recent drift check, nil before the first one
+	cancelWait     func()       // Interrupts the health waits of the sync in progress, nil between syncs
+	state          appState     // Sync history and rollback, loaded from store when the run loop starts
+	resume         bool         // Re-sync the head of the branch on the next poll, ending a rollback
+	// onUpdate, if set, is called from the run loop after every poll and drift
+	// check, e.g. to publish the state of the application.
+	onUpdate func(*application)
+}
+
+// newApplication creates the loop of spec, polling through clone, applying
+// with kubeHandler and persisting its state in store. decryptor decrypts
+// SOPS-encrypted manifests and may be nil.
+func newApplication(cfg *config.Config, spec config.Application, clone *repoClone, kubeHandler *kubehandler.KubeHandler, decryptor *kubehandler.Decryptor, store stateStore) *application {
+	return &application{
+		cfg:         cfg,
+		spec:        spec,
+		clone:       clone,
+		kubeHandler: kubeHandler,
+		decryptor:   decryptor,
+		logger:      log.New(log.Writer(), "["+spec.Name+"] ", log.Flags()|log.Lmsgprefix),
+		store:       store,
+		pollNow:     make(chan struct{}, 1),
+		rollbackNow: make(chan string, 1),
+	}
+}
+
+// run restores the persisted state, then polls and checks for drift until
+// stop is closed.
+func (a *application) run(stop <-chan struct{}) {
+	// Canceling ctx interrupts the health wait of a sync in progress on stop
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Setup ticker for polling interval
+	interval := time.Duration(a.cfg.PollIntervalSeconds) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Setup ticker for drift detection; a nil channel never fires when it is disabled
+	var driftTick <-chan time.Time
+	if a.cfg.DriftCheckIntervalSeconds > 0 {
+		driftTicker := time.NewTicker(time.Duration(a.cfg.DriftCheckIntervalSeconds) * time.Second)
+		defer driftTicker.Stop()
+		driftTick = driftTicker.C
+	}
+
+	state, err := a.store.load()
+	if err != nil {
+		a.logger.Printf("Starting with an empty sync history: %v", err)
+	}
+	a.mu.Lock()
+	a.startedAt = time.Now()
+	a.mu.Unlock()
+	a.restore(state)
+	if state.Rollback != nil {
+		a.logger.Printf("Auto-sync is paused at rollback commit %s until branch %s moves past %s.", state.Rollback.Commit, a.spec.Revision, state.Rollback.BranchCommit)
+	}
+	a.logger.Printf("Starting polling loop for %s (revision: %s, path: %s) every %d seconds.", a.spec.RepoURL, a.spec.Revision, a.spec.Path, a.cfg.PollIntervalSeconds)
+
+	for {
+		// A nil channel never fires when no retry is scheduled. While polls back
+		// off after failures only the backoff timer and webhooks poll.
+		pollTick := ticker.C
+		retryTick, stopRetryTimer := a.retryTimer()
+		backoffTick, stopBackoffTimer := a.backoffTimer()
+		if backoffTick != nil {
+			pollTick, retryTick = nil, nil
+		}
+		select {
+		case <-pollTick:
+			a.poll(ctx)
+			a.update()
+
+		case <-backoffTick:
+			a.poll(ctx)
+			a.update()
+			ticker.Reset(interval) // Do not poll again on a tick missed meanwhile
+
+		case <-a.pollNow:
+			a.logger.Println("Poll triggered by webhook.")
+			a.poll(ctx)
+			a.update()
+
+		case <-retryTick:
+			a.poll(ctx)
+			a.update()
+
+		case commitHash := <-a.rollbackNow:
+			a.rollback(ctx, commitHash)
+			a.update()
+
+		case <-driftTick:
+			if a.hasAppliedCommit() { // Nothing to compare before the first successful sync
+				report := a.checkDrift()
+				a.mu.Lock()
+				a.lastDrift = report
+				a.mu.Unlock()
+				a.update()
+			}
+
+		case <-stop:
+			stopRetryTimer()
+			stopBackoffTimer()
+			a.logger.Println("Stopped polling loop.")
+			return
+		}
+		stopRetryTimer()
+		stopBackoffTimer()
+	}
+}
+
+// restore makes state the state of the application. If state was saved with
+// the same spec, the last sync is restored too and polling resumes from the
+// commit applied before the restart, so that an unchanged head is not synced
+// again; the periodic full sync is then due an interval after the restart.
+func (a *application) restore(state appState) {
+	resumed := state.Spec != nil && *state.Spec == a.persistedSpec() && state.AppliedCommit != ""
+	a.mu.Lock()
+	a.state = state
+	if resumed {
+		a.lastSync = state.LastSync
+		a.syncedCommit = state.SyncedCommit
+		a.appliedCommit = state.AppliedCommit
+	}
+	a.mu.Unlock()
+	if !resumed {
+		return
+	}
+	a.poller.SetLastCommitHash(state.AppliedCommit)
+	a.lastFullSyncAt = time.Now()
+	a.logger.Printf("Resuming from commit %s, which was applied before the restart.", state.AppliedCommit)
+}
+
+// poll checks the repository for a new commit and syncs it, or diffs it in
+// diff-only mode. The manifests are loaded while the clone is locked, so other
+// applications cannot move the working copy to another commit meanwhile. After
+// a rollback nothing is synced until the branch moves or a resume is requested.
+// A commit is skipped if it changed no manifest file and, between full syncs,
+// otherwise only its changed files are applied; see syncScope. A failed sync is
+// repeated, without a new commit, once its retry is due; see scheduleRetry. A
+// failed poll backs off further polls; see backOff. Canceling ctx interrupts the
+// health wait of the sync.
+func (a *application) poll(ctx context.Context) {
+	a.logger.Println("Polling for changes...")
+	a.mu.Lock()
+	resume, rollback := a.resume, a.state.Rollback
+	a.resume = false
+	retry := a.takeDueRetry()
+	a.mu.Unlock()
+	fullSyncDue := a.fullSyncDue()
+
+	a.lockClone()
+	changed, commitHash, manifestFiles, err := a.poller.Poll()
+	// After a restart the first poll reports the head as new unless it was
+	// applied before; see restore. It only ends a rollback if the branch moved
+	// meanwhile.
+	paused := rollback != nil && !resume && (!changed || commitHash == rollback.BranchCommit)
+	var changes *gitpoller.ManifestChanges
+	if changed && !resume && !fullSyncDue {
+		changes = a.syncScope(a.poller.Changes())
+	}
+	unchanged := changes != nil && changes.Empty()
+	retryNow := retry != nil && !changed && retry.Commit == commitHash
+	syncNow := err == nil && !paused && ((changed && !unchanged) || resume || fullSyncDue || retryNow)
+	if syncNow && !changed {
+		manifestFiles, err = a.poller.GetManifestFiles()
+	}
+	var manifests []kubehandler.Manifest
+	var loadErrors []kubehandler.ManifestError
+	var info gitpoller.CommitInfo
+	if syncNow && err == nil {
+		manifests, loadErrors = a.loadManifests(commitHash, manifestFiles)
+		info = a.commitInfo(commitHash)
+	}
+	a.clone.mu.Unlock()
+
+	a.mu.Lock()
+	a.lastPollErr = err
+	if err == nil {
+		a.lastPolledAt = time.Now()
+		a.lastCommitHash = commitHash
+		if unchanged && !paused {
+			a.syncedCommit = commitHash
+			a.appliedCommit = commitHash
+			a.saveState()
+		}
+	} else if resume {
+		a.resume = true // Retry on the next poll
+	}
+	a.mu.Unlock()
+	if err != nil {
+		a.backOff(err)
+		return
+	}
+	a.endBackoff()
+
+	if paused {
+		a.logger.Printf("Auto-sync is paused at rollback commit %s; branch head is %s.", rollback.Commit, commitHash)
+		return
+	}
+	if unchanged {
+		a.logger.Printf("New commit %s changes no manifest file in '%s'; nothing to sync.", commitHash, a.spec.Path)
+		return
+	}
+	if !syncNow {
+		a.logger.Printf("No new changes detected. Current commit: %s", commitHash)
+		return
+	}
+
+	switch {
+	case changed:
+		a.logger.Printf("Changes detected! New commit: %s", commitHash)
+	case resume:
+		a.logger.Printf("Re-syncing commit %s on request.", commitHash)
+	case retryNow:
+		a.logger.Printf("Retrying the failed sync of commit %s (retry %d of %d).", commitHash, retry.Attempts, a.cfg.SyncRetryMaxAttempts)
+	default:
+		a.logger.Printf("Re-applying all manifests of commit %s as a periodic full sync.", commitHash)
+	}
+	if rollback != nil {
+		a.logger.Printf("Resuming auto-sync after the rollback to %s.", rollback.Commit)
+	}
+	if a.cfg.DiffOnly {
+		a.diffCommit(commitHash, manifests, loadErrors)
+		return
+	}
+	if changes == nil {
+		a.lastFullSyncAt = time.Now()
+	}
+	result := a.syncCommit(ctx, commitHash, manifests, loadErrors, changes)
+	a.recordSync(result, info, nil)
+	if result.Succeeded() {
+		a.logger.Printf("Sync of commit %s succeeded.", commitHash)
+	} else {
+		a.logger.Printf("Sync of commit %s failed: %d error(s), health %q.", commitHash, len(result.Errors), result.Health)
+	}
+	a.scheduleRetry(result)
+}
+
+// hasAppliedCommit reports whether a commit was synced successfully.
+func (a *application) hasAppliedCommit() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.appliedCommit != ""
+}
+
+// fullSyncDue reports whether the periodic full sync is due. It never is in
+// diff-only mode or with the full sync interval disabled, where every new
+// commit is synced in full anyway.
+func (a *application) fullSyncDue() bool {
+	if a.cfg.DiffOnly || a.cfg.FullSyncIntervalSeconds <= 0 || a.lastFullSyncAt.IsZero() {
+		return false
+	}
+	return time.Since(a.lastFullSyncAt) >= time.Duration(a.cfg.FullSyncIntervalSeconds)*time.Second
+}
+
+// syncScope returns changes if the new commit can be synced by applying only
+// the files it changed, or skipped if it changed none, or nil if all manifests
+// must be applied: the cluster must run the manifests of the commit the changes
+// are relative to and that sync must have succeeded. A commit that changed
+// files is applied in full if the manifests are rendered, as a change to any
+// file of a kustomization or chart may affect every rendered document, and with
+// the full sync interval disabled.
+func (a *application) syncScope(changes *gitpoller.ManifestChanges) *gitpoller.ManifestChanges {
+	if changes == nil || a.cfg.DiffOnly {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lastSync == nil || !a.lastSync.Succeeded() || a.syncedCommit != changes.From {
+		return nil
+	}
+	if !changes.Empty() && (changes.Rendered || a.cfg.FullSyncIntervalSeconds <= 0) {
+		return nil
+	}
+	return changes
+}
+
+// lockClone locks the clone for reading or moving its working copy. If the
+// clone was replaced since poller last used it, poller switches to the new one.
+func (a *application) lockClone() {
+	a.clone.mu.Lock()
+	if a.cloneGeneration != a.clone.generation {
+		a.poller.UseCloneOf(a.clone.poller)
+		a.cloneGeneration = a.clone.generation
+	}
+}
+
+// update calls onUpdate if it is set.
+func (a *application) update() {
+	if a.onUpdate != nil {
+		a.onUpdate(a)
+	}
+}
+
+// loadManifests decodes or renders manifestFiles of commitHash.
+func (a *application) loadManifests(commitHash string, manifestFiles []string) ([]kubehandler.Manifest, []kubehandler.ManifestError) {
+	if len(manifestFiles) == 0 {
+		a.logger.Printf("No manifest files found in '%s' for commit %s.", a.spec.Path, commitHash)
+	} else {
+		a.logger.Printf("Found %d manifest files for commit %s:", len(manifestFiles), commitHash)
+		for _, filePath := range manifestFiles {
+			a.logger.Printf(" - %s", filePath)
+		}
+	}
+	return a.readManifests(commitHash, manifestFiles)
+}
+
+// readManifests decodes or renders manifestFiles of commitHash, from the
+// working tree or, for a bare clone, from the Git objects of the commit.
+func (a *application) readManifests(commitHash string, manifestFiles []string) ([]kubehandler.Manifest, []kubehandler.ManifestError) {
+	if !a.cfg.GitBareClone {
+		return kubehandler.LoadManifests(manifestFiles, a.loadOptions())
+	}
+	_, contents, err := a.poller.ReadManifestFiles(commitHash)
+	if err != nil {
+		return nil, []kubehandler.ManifestError{{Source: a.spec.Path, Message: err.Error()}}
+	}
+	return kubehandler.LoadManifestContents(manifestFiles, contents, a.loadOptions())
+}
+
+// loadOptions returns the options used to render the manifest sources.
+func (a *application) loadOptions() kubehandler.LoadOptions {
+	helmNamespace := a.cfg.HelmNamespace
+	if helmNamespace == "" {
+		helmNamespace = a.spec.Destination.Namespace
+	}
+	return kubehandler.LoadOptions{
+		Namespace: a.spec.Destination.Namespace,
+		Root:      a.poller.LocalPath(),
+		Helm: kubehandler.HelmOptions{
+			ReleaseName: a.cfg.HelmReleaseName,
+			Namespace:   helmNamespace,
+			ValuesFiles: a.cfg.HelmValuesFiles,
+			Set:         a.cfg.HelmSet,
+		},
+		Decryptor: a.decryptor,
+	}
+}
+
+// triggerPoll asks the run loop to poll as soon as it is idle, interrupting the
+// health wait of a sync in progress. Requests that arrive while one is already
+// pending are coalesced.
+func (a *application) triggerPoll() {
+	select {
+	case a.pollNow <- struct{}{}:
+	default:
+	}
+	a.interruptWait()
+}
+
+// interruptWait interrupts the health wait of the sync in progress, if any, so
+// that the run loop turns to a request queued meanwhile.
+func (a *application) interruptWait() {
+	a.mu.Lock()
+	cancel := a.cancelWait
+	a.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
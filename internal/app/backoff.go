@@ -0,0 +1,79 @@
+package app
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/user/go-argo-lite/internal/gitpoller"
+)
+
+// fetchBackoff returns how long polls wait after failures consecutive failed
+// polls, the last one failing with an error of class: twice the base after the
+// first failure, doubled after every further one and capped at max, with equal
+// jitter so that applications failing together spread their next attempts.
+// Auth and ref-not-found errors back off by max right away, as they persist
+// until the configuration or the remote changes. It never returns less than
+// base, the poll interval.
+func fetchBackoff(failures int, class gitpoller.ErrorClass, base, max time.Duration) time.Duration {
+	delay := max
+	if class != gitpoller.ErrorAuth && class != gitpoller.ErrorRefNotFound {
+		delay = retryBackoff(failures, base, max)
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if delay < base {
+		delay = base
+	}
+	return delay
+}
+
+// backOff records err, the error of a failed poll. A corrupted clone is deleted
+// and cloned again. Unless FetchBackoffMaxSeconds is 0, the run loop then stops
+// polling on its ticker until the backoff for the consecutive failures passes;
+// webhooks still trigger polls meanwhile.
+func (a *application) backOff(err error) {
+	class := gitpoller.ClassifyError(err)
+	if class == gitpoller.ErrorCorrupted {
+		a.logger.Printf("The local clone of %s is corrupted: %v.", a.spec.RepoURL, err)
+		if recloneErr := a.clone.reclone(); recloneErr != nil {
+			a.logger.Printf("Failed to clone the repository again: %v.", recloneErr)
+		} else {
+			a.logger.Println("Cloned the repository again.")
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pollFailures++
+	if a.cfg.FetchBackoffMaxSeconds <= 0 {
+		a.logger.Printf("Error during repository poll (%s): %v. Continuing...", class, err)
+		return
+	}
+	base := time.Duration(a.cfg.PollIntervalSeconds) * time.Second
+	delay := fetchBackoff(a.pollFailures, class, base, time.Duration(a.cfg.FetchBackoffMaxSeconds)*time.Second)
+	next := time.Now().Add(delay)
+	a.nextPollAt = &next
+	a.logger.Printf("Error during repository poll (%s, %d in a row): %v. Polling again in %s.", class, a.pollFailures, err, delay.Round(time.Second))
+}
+
+// endBackoff resets the failed polls after a successful one.
+func (a *application) endBackoff() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pollFailures > 0 {
+		a.logger.Printf("Polled the repository successfully after %d failed poll(s).", a.pollFailures)
+	}
+	a.pollFailures = 0
+	a.nextPollAt = nil
+}
+
+// backoffTimer returns a channel receiving when polls resume after failures,
+// nil if they are not backing off, and a function stopping the timer behind it.
+func (a *application) backoffTimer() (<-chan time.Time, func() bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.nextPollAt == nil {
+		return nil, func() bool { return false }
+	}
+	timer := time.NewTimer(time.Until(*a.nextPollAt))
+	return timer.C, timer.Stop
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"github.com/user/go-argo-lite/internal/config"
+	"github.com/user/go-argo-lite/internal/gitpoller"
+)
+
+func TestFetchBackoff(t *testing.T) {
+	t.Helper()
+	base, max := 60*time.Second, 900*time.Second
+	for failures, ceiling := range []time.Duration{0, 120 * time.Second, 240 * time.Second, 480 * time.Second, 900 * time.Second, 900 * time.Second} {
+		if failures == 0 {
+			continue
+		}
+		for i := 0; i < 20; i++ {
+			delay := fetchBackoff(failures, gitpoller.ErrorNetwork, base, max)
+			floor := ceiling / 2
+			if floor < base {
+				floor = base
+			}
+			if delay < floor || delay > ceiling {
+				t.Fatalf("fetchBackoff(%d) = %s, expected between %s and %s", failures, delay, floor, ceiling)
+			}
+		}
+	}
+	if delay := fetchBackoff(1, gitpoller.ErrorAuth, base, max); delay < max/2 {
+		t.Errorf("expected an auth error to back off by the maximum right away, got %s", delay)
+	}
+}
+
+func TestBackOff(t *testing.T) {
+	t.Helper()
+	cfg := &config.Config{PollIntervalSeconds: 60, FetchBackoffMaxSeconds: 900}
+	app := newApplication(cfg, config.Application{Name: "web"}, nil, nil, nil, nil)
+	fetchErr := fmt.Errorf("failed during fetch: %w", transport.ErrAuthenticationRequired)
+
+	if tick, _ := app.backoffTimer(); tick != nil {
+		t.Fatal("expected no backoff before a failed poll")
+	}
+	app.backOff(fetchErr)
+	app.backOff(fetchErr)
+	if app.pollFailures != 2 || app.nextPollAt == nil {
+		t.Fatalf("expected 2 failed polls and a backoff, got %d and %v", app.pollFailures, app.nextPollAt)
+	}
+	if delay := time.Until(*app.nextPollAt); delay < 7*time.Minute {
+		t.Errorf("expected an auth error to back off by up to 15 minutes, got %s", delay)
+	}
+	tick, stop := app.backoffTimer()
+	if tick == nil {
+		t.Error("expected a backoff timer")
+	}
+	stop()
+
+	app.lastPollErr = fetchErr
+	if status := app.status(); status.PollErrorClass != string(gitpoller.ErrorAuth) || status.PollFailures != 2 || status.NextPollAt == nil {
+		t.Errorf("expected the backoff in the status, got %+v", status)
+	}
+
+	app.endBackoff()
+	if app.pollFailures != 0 || app.nextPollAt != nil {
+		t.Errorf("expected a successful poll to end the backoff, got %d and %v", app.pollFailures, app.nextPollAt)
+	}
+
+	cfg.FetchBackoffMaxSeconds = 0
+	app.backOff(errors.New("failed during fetch: connection reset"))
+	if app.pollFailures != 1 || app.nextPollAt != nil {
+		t.Errorf("expected no backoff with it disabled, got %d and %v", app.pollFailures, app.nextPollAt)
+	}
+}
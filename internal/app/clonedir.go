@@ -0,0 +1,96 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// cloneLockFile is the name of the lock file of a clone directory.
+const cloneLockFile = ".lock"
+
+// cloneStateDir is the name of the directory holding the state of the
+// applications in a clone directory, unless the configuration names another.
+const cloneStateDir = "state"
+
+// cloneDir is the directory holding the repository clones of the process,
+// locked so that no other process uses the clones at the same time.
+type cloneDir struct {
+	path      string
+	temporary bool     // Created by openCloneDir; removed by close
+	lock      *os.File // Locked while the directory is in use
+}
+
+// openCloneDir creates path if needed and locks it. An empty path creates a
+// new temporary directory.
+func openCloneDir(path string) (*cloneDir, error) {
+	d := &cloneDir{path: path}
+	if path == "" {
+		tempDir, err := os.MkdirTemp("", "go-argo-lite-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary clone directory: %w", err)
+		}
+		d.path, d.temporary = tempDir, true
+	} else if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create clone directory %s: %w", path, err)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(d.path, cloneLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file of clone directory %s: %w", d.path, err)
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		if errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("clone directory %s is in use by another process", d.path)
+		}
+		return nil, fmt.Errorf("failed to lock clone directory %s: %w", d.path, err)
+	}
+	d.lock = lock
+	log.Printf("Using clone directory %s.", d.path)
+	return d, nil
+}
+
+// statePath returns the path of the state directory in d.
+func (d *cloneDir) statePath() string {
+	return filepath.Join(d.path, cloneStateDir)
+}
+
+// clonePath returns the path of the clone name in d.
+func (d *cloneDir) clonePath(name string) string {
+	return filepath.Join(d.path, name)
+}
+
+// close unlocks d. A temporary directory is removed, as are the clones, but
+// not the state, of any other directory if removeClones is set.
+func (d *cloneDir) close(removeClones bool) {
+	if d.temporary {
+		removeClones = true
+	}
+	if removeClones {
+		entries, err := os.ReadDir(d.path)
+		if err != nil {
+			log.Printf("Failed to list clone directory %s: %v", d.path, err)
+		}
+		for _, entry := range entries {
+			if entry.Name() == cloneLockFile || entry.Name() == cloneStateDir {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(d.path, entry.Name())); err != nil {
+				log.Printf("Failed to remove clone %s: %v", entry.Name(), err)
+			}
+		}
+	}
+	// A temporary directory goes with its lock file while it is still locked.
+	if d.temporary {
+		if err := os.RemoveAll(d.path); err != nil {
+			log.Printf("Failed to remove clone directory %s: %v", d.path, err)
+		}
+	}
+	d.lock.Close()
+	if removeClones {
+		log.Printf("Removed the clones in %s.", d.path)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package app
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked is returned by lockFile if another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// lockFile does nothing on platforms without flock; the clone directory is
+// not protected from other processes there.
+func lockFile(f *os.File) error {
+	return nil
+}
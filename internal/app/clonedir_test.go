@@ -0,0 +1,51 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCloneDir(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clones")
+	dir, err := openCloneDir(path)
+	if err != nil {
+		t.Fatalf("openCloneDir() returned an unexpected error: %v", err)
+	}
+	if _, err := openCloneDir(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected a second open of the locked directory to fail, got %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir.clonePath("repo"), ".git"), 0755); err != nil {
+		t.Fatalf("Failed to create clone: %v", err)
+	}
+	if err := os.MkdirAll(dir.statePath(), 0755); err != nil {
+		t.Fatalf("Failed to create state directory: %v", err)
+	}
+
+	dir.close(true)
+	if _, err := os.Stat(dir.clonePath("repo")); !os.IsNotExist(err) {
+		t.Errorf("expected the clone to be removed, got %v", err)
+	}
+	if _, err := os.Stat(dir.statePath()); err != nil {
+		t.Errorf("expected the state directory to be kept, got %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected a configured directory to be kept, got %v", err)
+	}
+	reopened, err := openCloneDir(path)
+	if err != nil {
+		t.Fatalf("expected the directory to be unlocked after close, got %v", err)
+	}
+	reopened.close(false)
+
+	temporary, err := openCloneDir("")
+	if err != nil {
+		t.Fatalf("openCloneDir() returned an unexpected error: %v", err)
+	}
+	temporary.close(false)
+	if _, err := os.Stat(temporary.path); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary directory to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package app
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errLocked is returned by lockFile if another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// lockFile takes an exclusive lock on f without waiting for it. The lock is
+// released when f is closed, also when the process dies.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
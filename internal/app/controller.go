@@ -0,0 +1,479 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/user/go-argo-lite/internal/config"
+	"github.com/user/go-argo-lite/internal/gitpoller"
+	"github.com/user/go-argo-lite/internal/kubehandler"
+	"github.com/user/go-argo-lite/internal/metrics"
+)
+
+// ApplicationResource identifies the Application custom resource watched in
+// controller mode. Its definition is in deploy/crds/application.yaml.
+var ApplicationResource = schema.GroupVersionResource{Group: "go-argo-lite.io", Version: "v1alpha1", Resource: "applications"}
+
+// Sync states reported in the status of an Application resource.
+const (
+	SyncStatusSynced    = "Synced"    // The last commit was applied and the cluster matches it
+	SyncStatusOutOfSync = "OutOfSync" // A newer commit is not applied yet, or live objects drifted
+	SyncStatusFailed    = "Failed"    // The last sync had errors or unhealthy resources
+	SyncStatusUnknown   = "Unknown"   // Nothing has been synced yet
+)
+
+// applicationResourceSpec is the spec of an Application resource.
+type applicationResourceSpec struct {
+	Source struct {
+		RepoURL  string `json:"repoURL"`
+		Revision string `json:"revision"`
+		Path     string `json:"path,omitempty"`
+	} `json:"source"`
+	Destination struct {
+		Namespace string `json:"namespace,omitempty"`
+	} `json:"destination,omitempty"`
+	SyncPolicy config.SyncPolicy `json:"syncPolicy,omitempty"`
+}
+
+// applicationResourceStatus is the status written back to an Application resource.
+type applicationResourceStatus struct {
+	ObservedGeneration int64        `json:"observedGeneration"`
+	Sync               string       `json:"sync"`
+	Revision           string       `json:"revision,omitempty"`        // Last polled commit
+	SyncedRevision     string       `json:"syncedRevision,omitempty"`  // Commit of the last sync
+	AppliedRevision    string       `json:"appliedRevision,omitempty"` // Commit of the last successful sync
+	Health             string       `json:"health,omitempty"`
+	LastSyncedAt       *metav1.Time `json:"lastSyncedAt,omitempty"`
+	Retry              *SyncRetry   `json:"retry,omitempty"` // Retries of the failed last sync
+	PollFailures       int          `json:"pollFailures,omitempty"`
+	NextPollAt         *metav1.Time `json:"nextPollAt,omitempty"` // End of the backoff after PollFailures
+	Errors             []string     `json:"errors,omitempty"`
+}
+
+// controller runs one application per Application resource, starting, restarting
+// and stopping it as resources are created, have their spec changed and are deleted.
+type controller struct {
+	app           *App
+	client        dynamic.Interface
+	kubeHandler   *kubehandler.KubeHandler
+	pollerOpts    []gitpoller.Option // Authentication and signature verification of every clone
+	localRepoPath string             // Prefix of the clone directories
+
+	mu        sync.Mutex
+	clones    map[string]*repoClone  // Keyed by repository URL and revision; see cloneSource
+	cloneRefs map[string]int         // Applications using each clone, including stopping ones
+	managed   map[string]*managedApp // Keyed by namespace/name of the resource
+	stopping  bool                   // Set on shutdown, when unused clones are kept on disk
+	running   sync.WaitGroup         // Applications that have not stopped yet, including replaced ones
+}
+
+// managedApp is the running application of one Application resource.
+type managedApp struct {
+	app        *application  // Nil if the resource is invalid
+	generation int64         // Generation of the resource spec the application runs
+	stop       chan struct{} // Closed to stop the application
+	done       chan struct{} // Closed once the application stopped
+	lastStatus []byte        // Last status written, to skip unchanged updates
+}
+
+// newController connects to the cluster of cfg.KubeconfigPath, which both holds
+// the Application resources and receives their manifests.
+func newController(a *App, pollerOpts []gitpoller.Option, localRepoPath string) (*controller, error) {
+	restConfig, err := kubehandler.RESTConfig(a.cfg.KubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	kubeHandler, err := kubehandler.NewKubeHandlerForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create KubeHandler: %w", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes dynamic client: %w", err)
+	}
+	return &controller{
+		app:           a,
+		client:        client,
+		kubeHandler:   kubeHandler,
+		pollerOpts:    pollerOpts,
+		localRepoPath: localRepoPath,
+		clones:        make(map[string]*repoClone),
+		cloneRefs:     make(map[string]int),
+		managed:       make(map[string]*managedApp),
+	}, nil
+}
+
+// run watches the Application resources until an interrupt signal arrives, then
+// stops every application.
+func (c *controller) run() error {
+	log.Println("Starting controller...")
+	namespace := c.app.cfg.WatchNamespace // Empty watches all namespaces
+	if _, err := c.client.Resource(ApplicationResource).Namespace(namespace).List(context.TODO(), metav1.ListOptions{Limit: 1}); err != nil {
+		c.app.cloneDir.close(false)
+		return fmt.Errorf("failed to list %s (is the CustomResourceDefinition installed?): %w", ApplicationResource.GroupResource(), err)
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	server, err := c.app.startHTTPServer()
+	if err != nil {
+		c.app.cloneDir.close(false)
+		return err
+	}
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client, 0, namespace, nil)
+	informer := factory.ForResource(ApplicationResource).Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.reconcile,
+		UpdateFunc: func(_, obj interface{}) { c.reconcile(obj) },
+		DeleteFunc: c.delete,
+	}); err != nil {
+		c.app.stopHTTPServer(server)
+		c.app.cloneDir.close(false)
+		return fmt.Errorf("failed to watch %s: %w", ApplicationResource.GroupResource(), err)
+	}
+	stopInformer := make(chan struct{})
+	factory.Start(stopInformer)
+	log.Printf("Watching %s in %s.", ApplicationResource.GroupResource(), namespaceDescription(namespace))
+
+	sig := <-signalChan
+	log.Printf("Received signal: %s. Shutting down gracefully...", sig)
+	close(stopInformer)
+	factory.Shutdown()
+
+	c.mu.Lock()
+	c.stopping = true
+	for key, m := range c.managed {
+		c.stopManaged(key, m)
+	}
+	c.mu.Unlock()
+	c.running.Wait()
+	c.app.stopHTTPServer(server)
+	c.app.cloneDir.close(c.app.cfg.CloneDirCleanup)
+	return nil
+}
+
+// namespaceDescription describes the namespace scope of the watch for logging.
+func namespaceDescription(namespace string) string {
+	if namespace == "" {
+		return "all namespaces"
+	}
+	return "namespace " + namespace
+}
+
+// reconcile starts the application of a created resource, and restarts it when
+// the spec of the resource changed. Status-only updates are ignored.
+func (c *controller) reconcile(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	key := u.GetNamespace() + "/" + u.GetName()
+
+	c.mu.Lock()
+	previous := c.managed[key]
+	if previous != nil && previous.generation == u.GetGeneration() {
+		c.mu.Unlock()
+		return
+	}
+
+	spec, err := applicationFromResource(u)
+	var clone *repoClone
+	if err == nil {
+		clone, err = c.acquireClone(spec)
+	}
+	if err != nil {
+		log.Printf("Invalid Application %s: %v", key, err)
+		if previous != nil {
+			c.stopManaged(key, previous)
+		}
+		// Remember the generation, so that the status update below is ignored.
+		invalid := &managedApp{generation: u.GetGeneration(), stop: make(chan struct{}), done: make(chan struct{})}
+		close(invalid.done)
+		c.managed[key] = invalid
+		c.mu.Unlock()
+		// Written unlocked, so that other resources do not wait for the API server.
+		c.writeStatus(u.GetNamespace(), u.GetName(), applicationResourceStatus{
+			ObservedGeneration: u.GetGeneration(),
+			Sync:               SyncStatusUnknown,
+			Errors:             []string{err.Error()},
+		})
+		return
+	}
+
+	switch {
+	case previous == nil:
+		log.Printf("Application %s added.", key)
+	case previous.app == nil:
+		log.Printf("Application %s is valid now; starting it.", key)
+	default:
+		log.Printf("Application %s changed; restarting it.", key)
+	}
+	if previous != nil {
+		c.stopManaged(key, previous)
+	}
+	c.startManaged(u.GetNamespace(), u.GetName(), u.GetGeneration(), spec, clone, previous)
+	c.mu.Unlock()
+}
+
+// delete stops the application of a deleted resource. The objects it deployed
+// are left in place.
+func (c *controller) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	key := u.GetNamespace() + "/" + u.GetName()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m := c.managed[key]; m != nil {
+		if m.app == nil {
+			delete(c.managed, key)
+			return
+		}
+		log.Printf("Application %s deleted; stopping it. Its objects are left in the cluster.", key)
+		c.stopManaged(key, m)
+		metrics.ForgetApplication(key)
+	}
+}
+
+// startManaged starts the application of spec, deploying from clone, for the
+// resource namespace/name. It waits for previous, the application it replaces,
+// to stop first, so that the two never sync concurrently. Once stopped, the
+// application releases its reference to clone, taken by acquireClone. c.mu
+// must be held.
+func (c *controller) startManaged(namespace, name string, generation int64, spec config.Application, clone *repoClone, previous *managedApp) {
+	key := namespace + "/" + name
+	// Keep applications on the same cluster from pruning each other's objects,
+	// and anyone allowed to create an Application from managing objects outside
+	// of its namespace.
+	kh := c.kubeHandler.ForApplication(key).Restrict(namespace, c.app.cfg.ClusterKinds)
+	app := newApplication(c.app.cfg, spec, clone, kh, c.app.decryptor, c.app.states.forApplication(key))
+	m := &managedApp{app: app, generation: generation, stop: make(chan struct{}), done: make(chan struct{})}
+	app.onUpdate = func(*application) {
+		c.publishStatus(namespace, name, m)
+	}
+	c.managed[key] = m
+
+	c.running.Add(1)
+	go func() {
+		defer c.running.Done()
+		defer c.releaseClone(cloneSource(spec))
+		defer close(m.done)
+		if previous != nil {
+			<-previous.done
+		}
+		// Retry until the repository can be cloned or the application is stopped.
+		for {
+			err := clone.initialize()
+			if err == nil {
+				break
+			}
+			app.logger.Printf("%v", err)
+			app.mu.Lock()
+			app.lastPollErr = err
+			app.mu.Unlock()
+			c.publishStatus(namespace, name, m)
+			select {
+			case <-m.stop:
+				return
+			case <-time.After(time.Duration(c.app.cfg.PollIntervalSeconds) * time.Second):
+			}
+		}
+		clone.mu.Lock() // Other applications may be polling through the clone
+		app.poller = clone.poller.Share(spec.Path)
+		clone.mu.Unlock()
+		c.app.addApplication(app)
+		defer c.app.removeApplication(app)
+		app.run(m.stop)
+	}()
+}
+
+// stopManaged stops m, the application of the resource key, without waiting
+// for it. c.mu must be held.
+func (c *controller) stopManaged(key string, m *managedApp) {
+	close(m.stop)
+	delete(c.managed, key)
+}
+
+// cloneSource returns the key of the clone of the repository revision of spec.
+func cloneSource(spec config.Application) string {
+	return spec.RepoURL + "#" + spec.Revision
+}
+
+// acquireClone returns the clone of the repository revision of spec, creating
+// it on first use, and takes a reference to it; see releaseClone. c.mu must be
+// held.
+func (c *controller) acquireClone(spec config.Application) (*repoClone, error) {
+	source := cloneSource(spec)
+	clone, ok := c.clones[source]
+	if !ok {
+		poller, err := gitpoller.NewGitPoller(spec.RepoURL, spec.Revision, c.clonePath(source), spec.Path, c.pollerOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GitPoller: %w", err)
+		}
+		clone = &repoClone{poller: poller}
+		c.clones[source] = clone
+	}
+	c.cloneRefs[source]++
+	return clone, nil
+}
+
+// releaseClone drops a reference to the clone of source taken by acquireClone.
+// Once no application uses the clone anymore, it is forgotten and deleted from
+// disk, unless the controller is shutting down: then it is kept for the next
+// start.
+func (c *controller) releaseClone(source string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cloneRefs[source]--
+	if c.cloneRefs[source] > 0 {
+		return
+	}
+	delete(c.clones, source)
+	delete(c.cloneRefs, source)
+	if c.stopping {
+		return
+	}
+	// Deleted while c.mu is held, so that a new clone of source cannot be
+	// created in the same place meanwhile.
+	path := c.clonePath(source)
+	log.Printf("Deleting the clone at %s, which no application uses anymore.", path)
+	if err := os.RemoveAll(path); err != nil {
+		log.Printf("Failed to delete the clone at %s: %v", path, err)
+	}
+}
+
+// clonePath returns where the clone of source is kept.
+func (c *controller) clonePath(source string) string {
+	return c.localRepoPath + "-" + shortHash(source)
+}
+
+// publishStatus writes the state of the application of m to its resource
+// unless it is unchanged since the last write.
+func (c *controller) publishStatus(namespace, name string, m *managedApp) {
+	status := resourceStatus(m.app, m.generation)
+	data, err := json.Marshal(status)
+	if err != nil || string(data) == string(m.lastStatus) {
+		return
+	}
+	if c.writeStatus(namespace, name, status) {
+		m.lastStatus = data
+	}
+}
+
+// writeStatus replaces the status of the Application resource namespace/name.
+// It reports whether that succeeded.
+func (c *controller) writeStatus(namespace, name string, status applicationResourceStatus) bool {
+	patch, err := json.Marshal([]map[string]interface{}{{"op": "add", "path": "/status", "value": status}})
+	if err != nil {
+		log.Printf("Failed to encode status of Application %s/%s: %v", namespace, name, err)
+		return false
+	}
+	_, err = c.client.Resource(ApplicationResource).Namespace(namespace).Patch(context.TODO(), name, types.JSONPatchType, patch, metav1.PatchOptions{FieldManager: kubehandler.FieldManager}, "status")
+	if err != nil {
+		log.Printf("Failed to update status of Application %s/%s: %v", namespace, name, err)
+		return false
+	}
+	return true
+}
+
+// resourceStatus summarizes the state of app for the status of its resource.
+func resourceStatus(app *application, generation int64) applicationResourceStatus {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	status := applicationResourceStatus{ObservedGeneration: generation, Sync: SyncStatusUnknown, Revision: app.lastCommitHash, AppliedRevision: app.appliedCommit}
+	if app.retry != nil {
+		retry := *app.retry
+		status.Retry = &retry
+	}
+	if app.lastPollErr != nil {
+		status.Errors = append(status.Errors, app.lastPollErr.Error())
+	}
+	status.PollFailures = app.pollFailures
+	if app.nextPollAt != nil {
+		status.NextPollAt = &metav1.Time{Time: *app.nextPollAt}
+	}
+	if app.lastSync != nil {
+		status.SyncedRevision = app.lastSync.Commit
+		status.Health = string(app.lastSync.Health)
+		status.LastSyncedAt = &metav1.Time{Time: app.lastSync.FinishedAt}
+		status.Errors = append(status.Errors, app.lastSync.Errors...)
+		switch {
+		case !app.lastSync.Succeeded():
+			status.Sync = SyncStatusFailed
+		case status.Revision != "" && app.deployedCommit() != app.syncedCommit:
+			status.Sync = SyncStatusOutOfSync
+		case app.lastDrift != nil && app.lastDrift.Commit == app.syncedCommit && len(app.lastDrift.Resources) > 0 && !app.lastDrift.Healed:
+			status.Sync = SyncStatusOutOfSync
+		default:
+			status.Sync = SyncStatusSynced
+		}
+	} else if status.Revision != "" {
+		status.Sync = SyncStatusOutOfSync // Polled, but only diffed so far
+	}
+	if app.lastDrift != nil {
+		status.Errors = append(status.Errors, app.lastDrift.Errors...)
+	}
+	return status
+}
+
+// applicationFromResource converts an Application resource into the
+// application it declares, named after the namespace and name of the resource.
+func applicationFromResource(u *unstructured.Unstructured) (config.Application, error) {
+	var spec applicationResourceSpec
+	rawSpec, _ := u.Object["spec"].(map[string]interface{})
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawSpec, &spec); err != nil {
+		return config.Application{}, fmt.Errorf("failed to decode spec: %w", err)
+	}
+	app := config.Application{
+		Name:       u.GetNamespace() + "/" + u.GetName(),
+		RepoURL:    spec.Source.RepoURL,
+		Revision:   spec.Source.Revision,
+		Path:       spec.Source.Path,
+		SyncPolicy: spec.SyncPolicy,
+	}
+	// Objects of an Application stay in its namespace; see startManaged.
+	app.Destination.Namespace = spec.Destination.Namespace
+	if app.Destination.Namespace == "" {
+		app.Destination.Namespace = u.GetNamespace()
+	}
+	if app.Path == "" {
+		app.Path = "manifests"
+	}
+	switch {
+	case app.RepoURL == "":
+		return config.Application{}, errors.New("spec.source.repoURL is required")
+	case app.Revision == "":
+		return config.Application{}, errors.New("spec.source.revision is required")
+	case app.Destination.Namespace != u.GetNamespace():
+		return config.Application{}, fmt.Errorf("spec.destination.namespace %q must be the namespace of the Application", app.Destination.Namespace)
+	case !filepath.IsLocal(filepath.Clean(app.Path)):
+		// Clones of other applications share the clone directory.
+		return config.Application{}, fmt.Errorf("spec.source.path %q must be a directory within the repository", app.Path)
+	}
+	return app, nil
+}
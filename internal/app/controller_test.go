@@ -0,0 +1,136 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/user/go-argo-lite/internal/config"
+	"github.com/user/go-argo-lite/internal/kubehandler"
+)
+
+func TestApplicationFromResource(t *testing.T) {
+	t.Helper()
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "go-argo-lite.io/v1alpha1",
+		"kind":       "Application",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "team-a"},
+		"spec": map[string]interface{}{
+			"source":      map[string]interface{}{"repoURL": "https://git.example.com/web.git", "revision": "main"},
+			"destination": map[string]interface{}{"namespace": "team-a"},
+			"syncPolicy":  map[string]interface{}{"prune": true},
+		},
+	}}
+
+	spec, err := applicationFromResource(u)
+	if err != nil {
+		t.Fatalf("applicationFromResource() returned an unexpected error: %v", err)
+	}
+	if spec.Name != "team-a/web" || spec.RepoURL != "https://git.example.com/web.git" || spec.Revision != "main" {
+		t.Errorf("unexpected application %+v", spec)
+	}
+	if spec.Path != "manifests" || spec.Destination.Namespace != "team-a" || !spec.SyncPolicy.Prune || spec.SyncPolicy.SelfHeal {
+		t.Errorf("unexpected path, destination or sync policy in %+v", spec)
+	}
+
+	unstructured.SetNestedField(u.Object, "kube-system", "spec", "destination", "namespace")
+	if _, err := applicationFromResource(u); err == nil {
+		t.Error("expected an error for a destination outside the namespace of the Application")
+	}
+	unstructured.RemoveNestedField(u.Object, "spec", "destination")
+	if spec, err := applicationFromResource(u); err != nil || spec.Destination.Namespace != "team-a" {
+		t.Errorf("expected the destination to default to the namespace of the Application, got %q (%v)", spec.Destination.Namespace, err)
+	}
+
+	for _, path := range []string{"/etc", "..", "../repo-0123456789ab/secrets", "a/../../b"} {
+		unstructured.SetNestedField(u.Object, path, "spec", "source", "path")
+		if _, err := applicationFromResource(u); err == nil {
+			t.Errorf("expected an error for spec.source.path %q outside the repository", path)
+		}
+	}
+	unstructured.SetNestedField(u.Object, "apps/../web", "spec", "source", "path")
+	if spec, err := applicationFromResource(u); err != nil || spec.Path != "apps/../web" {
+		t.Errorf("expected a path within the repository to be accepted, got %q (%v)", spec.Path, err)
+	}
+
+	unstructured.RemoveNestedField(u.Object, "spec", "source", "revision")
+	if _, err := applicationFromResource(u); err == nil {
+		t.Error("expected an error for a resource without spec.source.revision")
+	}
+}
+
+func TestResourceStatus(t *testing.T) {
+	t.Helper()
+	app := &application{}
+	if status := resourceStatus(app, 3); status.Sync != SyncStatusUnknown || status.ObservedGeneration != 3 {
+		t.Errorf("expected Unknown at generation 3 before the first poll, got %+v", status)
+	}
+
+	app.lastPollErr = errors.New("fetch failed")
+	app.lastSync = &SyncResult{Commit: "abc", FinishedAt: time.Now(), Health: kubehandler.HealthHealthy}
+	app.syncedCommit = "abc"
+	status := resourceStatus(app, 3)
+	if status.Sync != SyncStatusSynced || status.SyncedRevision != "abc" || status.Health != "Healthy" || status.LastSyncedAt == nil {
+		t.Errorf("expected Synced and Healthy at commit abc, got %+v", status)
+	}
+	if len(status.Errors) != 1 || status.Errors[0] != "fetch failed" {
+		t.Errorf("expected the poll error to be reported, got %v", status.Errors)
+	}
+
+	app.lastDrift = &DriftReport{Commit: "abc", Resources: []DriftedResource{{Missing: true}}}
+	if status := resourceStatus(app, 3); status.Sync != SyncStatusOutOfSync {
+		t.Errorf("expected OutOfSync after unhealed drift, got %s", status.Sync)
+	}
+
+	app.lastSync.Errors = []string{"apply failed"}
+	if status := resourceStatus(app, 3); status.Sync != SyncStatusFailed {
+		t.Errorf("expected Failed after a sync with errors, got %s", status.Sync)
+	}
+}
+
+func TestControllerClones(t *testing.T) {
+	t.Helper()
+	c := &controller{
+		localRepoPath: filepath.Join(t.TempDir(), "repo"),
+		clones:        make(map[string]*repoClone),
+		cloneRefs:     make(map[string]int),
+	}
+	spec := config.Application{RepoURL: "https://git.example.com/web.git", Revision: "main", Path: "manifests"}
+	first, err := c.acquireClone(spec)
+	if err != nil {
+		t.Fatalf("acquireClone() returned an unexpected error: %v", err)
+	}
+	second, err := c.acquireClone(spec)
+	if err != nil || second != first {
+		t.Fatalf("expected applications of the same revision to share a clone, got %p and %p (%v)", first, second, err)
+	}
+	path := c.clonePath(cloneSource(spec))
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatalf("Failed to create clone directory: %v", err)
+	}
+
+	c.releaseClone(cloneSource(spec))
+	if _, err := os.Stat(path); err != nil || len(c.clones) != 1 {
+		t.Fatalf("expected the clone to be kept while an application uses it, got %d clone(s) (%v)", len(c.clones), err)
+	}
+	c.releaseClone(cloneSource(spec))
+	if _, err := os.Stat(path); !os.IsNotExist(err) || len(c.clones) != 0 || len(c.cloneRefs) != 0 {
+		t.Errorf("expected the unused clone to be forgotten and deleted, got %d clone(s) (%v)", len(c.clones), err)
+	}
+
+	if _, err := c.acquireClone(spec); err != nil {
+		t.Fatalf("acquireClone() returned an unexpected error: %v", err)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatalf("Failed to create clone directory: %v", err)
+	}
+	c.stopping = true
+	c.releaseClone(cloneSource(spec))
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the clone to be kept on shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"time"
+
+	"github.com/user/go-argo-lite/internal/kubehandler"
+)
+
+// DriftedResource is a declared object whose live state no longer matches Git.
+type DriftedResource struct {
+	Key     kubehandler.ResourceKey
+	Missing bool     // The object was deleted from the cluster
+	Fields  []string // Dotted paths of the fields that differ from the manifest
+}
+
+// DriftReport records the outcome of one drift check.
+type DriftReport struct {
+	Commit    string
+	CheckedAt time.Time
+	Resources []DriftedResource // Drifted objects; empty when the cluster matches Git
+	Healed    bool              // All drifted objects were re-applied successfully
+	Errors    []string          // Load, diff and self-heal errors
+}
+
+// checkDrift compares the live objects with the manifests of the applied
+// commit, the last one synced successfully, using server-side dry runs. A
+// commit that was fetched but failed to sync is not compared: it is left to
+// the sync retries, which apply it in waves and run its hooks. Drifted objects
+// are logged and, when self-heal is enabled, re-applied, unless a retry of a
+// failed sync is pending. Hooks are not checked.
+func (a *application) checkDrift() *DriftReport {
+	a.mu.Lock()
+	commitHash := a.appliedCommit
+	retry := a.retry
+	a.mu.Unlock()
+	report := &DriftReport{Commit: commitHash, CheckedAt: time.Now()}
+	a.logger.Printf("Checking for drift from commit %s...", commitHash)
+
+	a.lockClone()
+	// Other applications of the clone may have moved the working copy.
+	_, err := a.poller.Checkout(commitHash)
+	var manifestFiles []string
+	if err == nil {
+		manifestFiles, err = a.poller.GetManifestFiles()
+	}
+	var manifests []kubehandler.Manifest
+	var loadErrors []kubehandler.ManifestError
+	if err == nil {
+		manifests, loadErrors = a.readManifests(commitHash, manifestFiles)
+	}
+	a.clone.mu.Unlock()
+	if err != nil {
+		a.logger.Printf("Error listing manifest files for drift check: %v", err)
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	plan, planErrors := kubehandler.PlanSync(manifests)
+	for _, loadErr := range append(loadErrors, planErrors...) {
+		report.Errors = append(report.Errors, loadErr.Error())
+	}
+
+	var drifted []kubehandler.Manifest
+	for _, m := range plan.Resources() {
+		diffs, diffErrors := a.kubeHandler.DiffManifests([]kubehandler.Manifest{m})
+		for _, diffErr := range diffErrors {
+			a.logger.Printf("Error checking drift: %v", diffErr)
+			report.Errors = append(report.Errors, diffErr.Error())
+		}
+		for _, d := range diffs {
+			if !d.Changed() {
+				continue
+			}
+			drifted = append(drifted, m)
+			report.Resources = append(report.Resources, DriftedResource{Key: d.Key, Missing: d.New, Fields: d.Fields})
+			if d.New {
+				a.logger.Printf("Drift detected: %s is missing from the cluster", d.Key)
+			} else {
+				a.logger.Printf("Drift detected: %s differs from Git in %v:\n%s", d.Key, d.Fields, d.Diff)
+			}
+		}
+	}
+
+	if len(drifted) == 0 {
+		a.logger.Printf("No drift from commit %s detected.", commitHash)
+		return report
+	}
+	if !a.spec.SyncPolicy.SelfHeal || a.cfg.DiffOnly {
+		a.logger.Printf("%d resource(s) drifted from commit %s; self-heal is disabled.", len(drifted), commitHash)
+		return report
+	}
+	if retry != nil {
+		a.logger.Printf("%d resource(s) drifted from commit %s; self-heal waits for the retry of the failed sync of commit %s.", len(drifted), commitHash, retry.Commit)
+		return report
+	}
+
+	a.logger.Printf("Self-healing %d drifted resource(s) from commit %s...", len(drifted), commitHash)
+	applyErrors := a.kubeHandler.ApplyManifests(drifted)
+	for _, applyErr := range applyErrors {
+		a.logger.Printf("Error self-healing: %v", applyErr)
+		report.Errors = append(report.Errors, applyErr.Error())
+	}
+	report.Healed = len(applyErrors) == 0
+	if report.Healed {
+		a.logger.Printf("Self-heal of commit %s complete.", commitHash)
+	}
+	return report
+}
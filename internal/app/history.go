@@ -0,0 +1,123 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/user/go-argo-lite/internal/config"
+)
+
+// HistoryEntry records one sync in the history of an application.
+type HistoryEntry struct {
+	Commit    string    `json:"commit"`
+	Author    string    `json:"author,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	SyncedAt  time.Time `json:"syncedAt"`
+	Succeeded bool      `json:"succeeded"`
+	Health    string    `json:"health,omitempty"`
+	Errors    []string  `json:"errors,omitempty"`
+	Rollback  bool      `json:"rollback,omitempty"` // The sync rolled back to an earlier commit
+}
+
+// RollbackState records that auto-sync is paused after a rollback.
+type RollbackState struct {
+	Commit string `json:"commit"` // Commit rolled back to
+	// BranchCommit is the head of the branch at the time of the rollback;
+	// auto-sync resumes once the branch moves past it.
+	BranchCommit string `json:"branchCommit"`
+}
+
+// appState is the state of an application that survives restarts.
+type appState struct {
+	History  []HistoryEntry `json:"history"` // Oldest first
+	Rollback *RollbackState `json:"rollback,omitempty"`
+	// LastSync, SyncedCommit and AppliedCommit are those of the application
+	// when it had the spec Spec. They are restored after a restart if the spec
+	// is unchanged, so that polling resumes from AppliedCommit instead of
+	// syncing the head of the revision again.
+	Spec          *config.Application `json:"spec,omitempty"`
+	LastSync      *SyncResult         `json:"lastSync,omitempty"`
+	SyncedCommit  string              `json:"syncedCommit,omitempty"`
+	AppliedCommit string              `json:"appliedCommit,omitempty"`
+}
+
+// trimHistory keeps the limit most recent entries of the history.
+func (s *appState) trimHistory(limit int) {
+	if excess := len(s.History) - limit; excess > 0 {
+		s.History = append([]HistoryEntry(nil), s.History[excess:]...)
+	}
+}
+
+// previousCommit returns the commit of the most recent successful sync before
+// the one of the current commit, or an empty string if there is none.
+func (s *appState) previousCommit(current string) string {
+	for i := len(s.History) - 1; i >= 0; i-- {
+		if entry := s.History[i]; entry.Succeeded && entry.Commit != current {
+			return entry.Commit
+		}
+	}
+	return ""
+}
+
+// stateStore persists the appState of one application; see config.StateStore.
+type stateStore interface {
+	// load reads the persisted state, returning an empty state if none was
+	// saved yet.
+	load() (appState, error)
+	// save trims the history of state to the limit of the store and writes it.
+	save(state *appState) error
+}
+
+// fileStateStore persists the appState of one application as a JSON file.
+type fileStateStore struct {
+	path  string
+	limit int // Maximum number of history entries kept
+}
+
+// newFileStateStore returns the store of the application name in dir.
+func newFileStateStore(dir, name string, limit int) *fileStateStore {
+	// Applications of controller mode are named namespace/name.
+	fileName := strings.ReplaceAll(name, "/", "_") + ".json"
+	return &fileStateStore{path: filepath.Join(dir, fileName), limit: limit}
+}
+
+func (s *fileStateStore) load() (appState, error) {
+	var state appState
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read state file %s: %w", s.path, err)
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return appState{}, fmt.Errorf("failed to parse state file %s: %w", s.path, err)
+	}
+	return state, nil
+}
+
+// save replaces the file atomically, so a crash never leaves a partial state
+// behind.
+func (s *fileStateStore) save(state *appState) error {
+	state.trimHistory(s.limit)
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("failed to write state file %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace state file %s: %w", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"time"
+)
+
+// SyncRetry reports the retries of a failed sync of one commit.
+type SyncRetry struct {
+	Commit   string `json:"commit"`
+	Attempts int    `json:"attempts"` // Failed syncs of Commit so far
+	// NextAttemptAt is when the sync is retried; nil once the retries are
+	// exhausted, then only a new commit, a resume or a full sync syncs again.
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+}
+
+// retryBackoff returns the delay before retry number retries+1: base, doubled
+// after every failed retry and capped at max.
+func retryBackoff(retries int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 0; i < retries && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// scheduleRetry updates the retries after result, a sync by the run loop. A
+// success ends them; a failure schedules the next retry of the commit with
+// exponential backoff, unless SyncRetryMaxAttempts retries failed already.
+func (a *application) scheduleRetry(result *SyncResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if result.Succeeded() {
+		if a.retry != nil && a.retry.Commit == result.Commit {
+			a.logger.Printf("Sync of commit %s succeeded after %d failed attempt(s).", result.Commit, a.retry.Attempts)
+		}
+		a.retry = nil
+		return
+	}
+
+	retry := &SyncRetry{Commit: result.Commit}
+	if a.retry != nil && a.retry.Commit == result.Commit {
+		retry.Attempts = a.retry.Attempts
+	}
+	retry.Attempts++
+	retries := retry.Attempts - 1
+	if retries >= a.cfg.SyncRetryMaxAttempts {
+		if a.cfg.SyncRetryMaxAttempts > 0 {
+			a.logger.Printf("Giving up on commit %s after %d failed attempt(s); it is synced again on a new commit, a resume or the next full sync.", result.Commit, retry.Attempts)
+		}
+	} else {
+		delay := a.postponeRetry(retry)
+		a.logger.Printf("Retrying the sync of commit %s in %s (retry %d of %d).", result.Commit, delay, retries+1, a.cfg.SyncRetryMaxAttempts)
+	}
+	a.retry = retry
+}
+
+// postponeRetry schedules the next retry of retry after the backoff for its
+// failed attempts and returns that delay.
+func (a *application) postponeRetry(retry *SyncRetry) time.Duration {
+	delay := retryBackoff(retry.Attempts-1, time.Duration(a.cfg.SyncRetryBackoffSeconds)*time.Second, time.Duration(a.cfg.SyncRetryMaxBackoffSeconds)*time.Second)
+	next := time.Now().Add(delay)
+	retry.NextAttemptAt = &next
+	return delay
+}
+
+// takeDueRetry returns a copy of the retries if the next one is due, nil
+// otherwise. A due retry is postponed as if it failed, in case the poll making
+// it fails before syncing; the outcome of the sync replaces that schedule.
+// a.mu must be held.
+func (a *application) takeDueRetry() *SyncRetry {
+	if a.retry == nil || a.retry.NextAttemptAt == nil || time.Now().Before(*a.retry.NextAttemptAt) {
+		return nil
+	}
+	retry := *a.retry
+	a.postponeRetry(a.retry)
+	return &retry
+}
+
+// retryTimer returns a channel receiving when the next retry is due, nil if
+// none is scheduled, and a function stopping the timer behind it.
+func (a *application) retryTimer() (<-chan time.Time, func() bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.retry == nil || a.retry.NextAttemptAt == nil {
+		return nil, func() bool { return false }
+	}
+	timer := time.NewTimer(time.Until(*a.retry.NextAttemptAt))
+	return timer.C, timer.Stop
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/user/go-argo-lite/internal/config"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	t.Helper()
+	base, max := 10*time.Second, 60*time.Second
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 60 * time.Second, 60 * time.Second}
+	for retries, delay := range expected {
+		if got := retryBackoff(retries, base, max); got != delay {
+			t.Errorf("retryBackoff(%d) = %s, expected %s", retries, got, delay)
+		}
+	}
+}
+
+func TestScheduleRetry(t *testing.T) {
+	t.Helper()
+	cfg := &config.Config{SyncRetryMaxAttempts: 2, SyncRetryBackoffSeconds: 10, SyncRetryMaxBackoffSeconds: 300}
+	app := newApplication(cfg, config.Application{Name: "web"}, nil, nil, nil, nil)
+	failed := func(commit string) *SyncResult {
+		return &SyncResult{Commit: commit, Errors: []string{"apply failed"}}
+	}
+
+	start := time.Now()
+	app.scheduleRetry(failed("abc"))
+	if app.retry == nil || app.retry.Commit != "abc" || app.retry.Attempts != 1 || app.retry.NextAttemptAt == nil {
+		t.Fatalf("expected a first retry of abc to be scheduled, got %+v", app.retry)
+	}
+	if delay := app.retry.NextAttemptAt.Sub(start); delay < 10*time.Second || delay > 11*time.Second {
+		t.Errorf("expected the first retry after 10s, got %s", delay)
+	}
+	if app.takeDueRetry() != nil {
+		t.Error("expected no retry to be due before its time")
+	}
+
+	past := time.Now().Add(-time.Second)
+	app.retry.NextAttemptAt = &past
+	due := app.takeDueRetry()
+	if due == nil || due.Commit != "abc" || due.Attempts != 1 {
+		t.Fatalf("expected the retry of abc to be due, got %+v", due)
+	}
+	if !app.retry.NextAttemptAt.After(time.Now()) {
+		t.Error("expected a taken retry to be postponed until the sync reports back")
+	}
+
+	app.scheduleRetry(failed("abc"))
+	if app.retry.Attempts != 2 || app.retry.NextAttemptAt == nil {
+		t.Fatalf("expected a second retry of abc, got %+v", app.retry)
+	}
+	if delay := time.Until(*app.retry.NextAttemptAt); delay < 19*time.Second {
+		t.Errorf("expected the backoff to double, got %s", delay)
+	}
+	app.scheduleRetry(failed("abc"))
+	if app.retry.Attempts != 3 || app.retry.NextAttemptAt != nil {
+		t.Errorf("expected the retries to be exhausted after 2 retries, got %+v", app.retry)
+	}
+
+	app.scheduleRetry(failed("def"))
+	if app.retry.Commit != "def" || app.retry.Attempts != 1 || app.retry.NextAttemptAt == nil {
+		t.Errorf("expected the retries to start over for a new commit, got %+v", app.retry)
+	}
+	app.scheduleRetry(&SyncResult{Commit: "def"})
+	if app.retry != nil {
+		t.Errorf("expected a successful sync to end the retries, got %+v", app.retry)
+	}
+
+	cfg.SyncRetryMaxAttempts = 0
+	app.scheduleRetry(failed("ghi"))
+	if app.retry == nil || app.retry.NextAttemptAt != nil {
+		t.Errorf("expected no retry to be scheduled with retries disabled, got %+v", app.retry)
+	}
+}
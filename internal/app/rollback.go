@@ -0,0 +1,175 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/user/go-argo-lite/internal/config"
+	"github.com/user/go-argo-lite/internal/gitpoller"
+	"github.com/user/go-argo-lite/internal/kubehandler"
+	"github.com/user/go-argo-lite/internal/metrics"
+	"github.com/user/go-argo-lite/pkg/utils"
+)
+
+// Errors returned by requestRollback and requestResume.
+var (
+	errRollbackPending = errors.New("a rollback is already pending")
+	errNotPaused       = errors.New("auto-sync is not paused")
+	errNoPrevious      = errors.New("the sync history has no earlier successful commit")
+	errNotPolled       = errors.New("the repository has not been polled yet")
+)
+
+// requestRollback resolves revision, or the commit of the most recent successful
+// sync before the current one if revision is empty, verifies its signature if
+// required, and asks the run loop to roll back to it. It returns the full hash
+// of the commit.
+func (a *application) requestRollback(revision string) (string, error) {
+	a.mu.Lock()
+	if a.lastCommitHash == "" {
+		a.mu.Unlock()
+		return "", errNotPolled
+	}
+	if revision == "" {
+		revision = a.state.previousCommit(a.deployedCommit())
+	}
+	a.mu.Unlock()
+	if revision == "" {
+		return "", errNoPrevious
+	}
+
+	a.lockClone()
+	commitHash, err := a.poller.ResolveCommit(revision)
+	if err == nil {
+		err = a.poller.VerifyCommit(commitHash) // Only trusted commits may be deployed
+	}
+	a.clone.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case a.rollbackNow <- commitHash:
+		a.interruptWait()
+		return commitHash, nil
+	default:
+		return "", errRollbackPending
+	}
+}
+
+// requestResume ends a rollback: the next poll, which is triggered right away,
+// syncs the head of the branch again.
+func (a *application) requestResume() error {
+	a.mu.Lock()
+	paused := a.state.Rollback != nil
+	a.resume = paused
+	a.mu.Unlock()
+	if !paused {
+		return errNotPaused
+	}
+	a.triggerPoll()
+	return nil
+}
+
+// deployedCommit returns the commit the cluster is meant to run: the rollback
+// commit while auto-sync is paused, the last polled commit otherwise. a.mu must
+// be held.
+func (a *application) deployedCommit() string {
+	if a.state.Rollback != nil {
+		return a.state.Rollback.Commit
+	}
+	return a.lastCommitHash
+}
+
+// rollback checks out commitHash in the clone, syncs its manifests and pauses
+// auto-sync until the branch moves past its current head or a resume is requested.
+// Canceling ctx interrupts the health wait of the sync.
+func (a *application) rollback(ctx context.Context, commitHash string) {
+	a.logger.Printf("Rolling back to commit %s...", commitHash)
+	a.lockClone()
+	var manifests []kubehandler.Manifest
+	var loadErrors []kubehandler.ManifestError
+	var info gitpoller.CommitInfo
+	_, err := a.poller.Checkout(commitHash)
+	if err == nil {
+		var manifestFiles []string
+		manifestFiles, err = a.poller.GetManifestFiles()
+		if err == nil {
+			manifests, loadErrors = a.loadManifests(commitHash, manifestFiles)
+			info = a.commitInfo(commitHash)
+		}
+	}
+	a.clone.mu.Unlock()
+	if err != nil {
+		a.logger.Printf("Rollback to commit %s failed: %v", commitHash, err)
+		return
+	}
+
+	result := a.syncCommit(ctx, commitHash, manifests, loadErrors, nil)
+	a.recordSync(result, info, &RollbackState{Commit: commitHash, BranchCommit: a.poller.LastCommitHash()})
+	if result.Succeeded() {
+		a.logger.Printf("Rollback to commit %s succeeded. Auto-sync is paused until branch %s moves or it is resumed.", commitHash, a.spec.Revision)
+	} else {
+		a.logger.Printf("Rollback to commit %s failed: %d error(s), health %q. Auto-sync is paused.", commitHash, len(result.Errors), result.Health)
+	}
+}
+
+// recordSync makes result the last sync, adds it to the history and persists
+// the state with rollback, the new rollback state (nil ends a rollback).
+func (a *application) recordSync(result *SyncResult, info gitpoller.CommitInfo, rollback *RollbackState) {
+	metrics.ObserveSync(a.spec.Name, result.Commit, result.FinishedAt.Sub(result.StartedAt), result.Succeeded())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastSync = result
+	a.syncedCommit = result.Commit
+	if result.Succeeded() {
+		a.appliedCommit = result.Commit
+	}
+	a.state.Rollback = rollback
+	if rollback != nil {
+		a.retry = nil // Failed rollbacks are not retried; auto-sync is paused
+	}
+	a.state.History = append(a.state.History, HistoryEntry{
+		Commit:    result.Commit,
+		Author:    info.Author,
+		Message:   info.Message,
+		SyncedAt:  result.FinishedAt,
+		Succeeded: result.Succeeded(),
+		Health:    string(result.Health),
+		Errors:    result.Errors,
+		Rollback:  rollback != nil,
+	})
+	a.saveState()
+}
+
+// saveState persists the sync history and rollback together with the last
+// sync, its commits and the spec they belong to. a.mu must be held.
+func (a *application) saveState() {
+	spec := a.persistedSpec()
+	a.state.Spec = &spec
+	a.state.LastSync = a.lastSync
+	a.state.SyncedCommit = a.syncedCommit
+	a.state.AppliedCommit = a.appliedCommit
+	if err := a.store.save(&a.state); err != nil {
+		a.logger.Printf("Failed to persist the sync history: %v", err)
+	}
+}
+
+// commitInfo returns the author and message of commitHash, or only its hash
+// if they cannot be read. a.clone.mu must be held.
+func (a *application) commitInfo(commitHash string) gitpoller.CommitInfo {
+	info, err := a.poller.CommitInfo(commitHash)
+	if err != nil {
+		a.logger.Printf("%v", err)
+		return gitpoller.CommitInfo{Hash: commitHash}
+	}
+	return info
+}
+
+// persistedSpec returns the spec of a as it is persisted with its state,
+// without the credentials of its repository URL.
+func (a *application) persistedSpec() config.Application {
+	spec := a.spec
+	spec.RepoURL = utils.RedactURL(spec.RepoURL)
+	return spec
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/user/go-argo-lite/internal/metrics"
+	"github.com/user/go-argo-lite/internal/webhook"
+)
+
+// httpShutdownTimeout bounds how long in-flight requests may take on shutdown.
+const httpShutdownTimeout = 5 * time.Second
+
+// startHTTPServer starts serving the HTTP endpoints in the background. It returns
+// nil if no listen address is configured.
+func (a *App) startHTTPServer() (*http.Server, error) {
+	if a.cfg.HTTPListenAddr == "" {
+		return nil, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+	mux.HandleFunc("/api/status", a.handleStatus)
+	mux.Handle("/metrics", metrics.Handler())
+	if a.cfg.APIToken != "" {
+		mux.HandleFunc(applicationsPath, a.handleApplicationAction)
+	}
+	if a.cfg.WebhookSecret != "" {
+		mux.Handle("/webhook", webhook.NewHandler(a.cfg.WebhookSecret, a.triggerPoll))
+	}
+
+	listener, err := net.Listen("tcp", a.cfg.HTTPListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", a.cfg.HTTPListenAddr, err)
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server stopped: %v", err)
+		}
+	}()
+	log.Printf("HTTP server listening on %s", listener.Addr())
+	return server, nil
+}
+
+// stopHTTPServer gracefully shuts down server if it is running.
+func (a *App) stopHTTPServer(server *http.Server) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/user/go-argo-lite/internal/config"
+	"github.com/user/go-argo-lite/internal/kubehandler"
+)
+
+const (
+	// stateConfigMapPrefix starts the names of the ConfigMaps of the state store.
+	stateConfigMapPrefix = "go-argo-lite-state-"
+	// stateConfigMapKey is the data key of a state ConfigMap holding the state.
+	stateConfigMapKey = "state.json"
+	// stateApplicationAnnotation names the application of a state ConfigMap.
+	stateApplicationAnnotation = "go-argo-lite/application"
+)
+
+// stateStores creates the stateStore of each application, in the backend
+// selected by config.StateStore.
+type stateStores struct {
+	cfg        *config.Config
+	configMaps corev1client.ConfigMapInterface // ConfigMaps of StateNamespace; nil for the file store
+}
+
+// newStateStores returns the stores of cfg. For the ConfigMap store, it
+// connects to the cluster of cfg.KubeconfigPath.
+func newStateStores(cfg *config.Config) (*stateStores, error) {
+	stores := &stateStores{cfg: cfg}
+	if cfg.StateStore != config.StateStoreConfigMap {
+		log.Printf("Keeping the state of the applications in %s.", cfg.StateDir)
+		return stores, nil
+	}
+	restConfig, err := kubehandler.RESTConfig(cfg.KubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure the state store: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the client of the state store: %w", err)
+	}
+	stores.configMaps = clientset.CoreV1().ConfigMaps(cfg.StateNamespace)
+	log.Printf("Keeping the state of the applications in ConfigMaps of namespace %s.", cfg.StateNamespace)
+	return stores, nil
+}
+
+// forApplication returns the store of the application name.
+func (s *stateStores) forApplication(name string) stateStore {
+	if s.configMaps != nil {
+		return newConfigMapStateStore(s.configMaps, name, s.cfg.SyncHistoryLimit)
+	}
+	return newFileStateStore(s.cfg.StateDir, name, s.cfg.SyncHistoryLimit)
+}
+
+// configMapStateStore persists the appState of one application as JSON in a
+// ConfigMap, so that it survives restarts without a persistent volume.
+type configMapStateStore struct {
+	configMaps  corev1client.ConfigMapInterface
+	name        string // Name of the ConfigMap
+	application string
+	limit       int // Maximum number of history entries kept
+}
+
+// newConfigMapStateStore returns the store of the application name in
+// configMaps.
+func newConfigMapStateStore(configMaps corev1client.ConfigMapInterface, name string, limit int) *configMapStateStore {
+	// Application names may contain characters that object names cannot.
+	return &configMapStateStore{configMaps: configMaps, name: stateConfigMapPrefix + shortHash(name), application: name, limit: limit}
+}
+
+func (s *configMapStateStore) load() (appState, error) {
+	var state appState
+	configMap, err := s.configMaps.Get(context.TODO(), s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read state ConfigMap %s: %w", s.name, err)
+	}
+	content, ok := configMap.Data[stateConfigMapKey]
+	if !ok {
+		return state, nil
+	}
+	if err := json.Unmarshal([]byte(content), &state); err != nil {
+		return appState{}, fmt.Errorf("failed to parse state ConfigMap %s: %w", s.name, err)
+	}
+	return state, nil
+}
+
+// save creates the ConfigMap on the first save and updates it afterwards.
+func (s *configMapStateStore) save(state *appState) error {
+	state.trimHistory(s.limit)
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	configMap, err := s.configMaps.Get(context.TODO(), s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        s.name,
+				Annotations: map[string]string{stateApplicationAnnotation: s.application},
+			},
+			Data: map[string]string{stateConfigMapKey: string(content)},
+		}
+		if _, err := s.configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create state ConfigMap %s: %w", s.name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state ConfigMap %s: %w", s.name, err)
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[stateConfigMapKey] = string(content)
+	if _, err := s.configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update state ConfigMap %s: %w", s.name, err)
+	}
+	return nil
+}
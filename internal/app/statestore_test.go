@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/user/go-argo-lite/internal/config"
+)
+
+func TestConfigMapStateStore(t *testing.T) {
+	t.Helper()
+	configMaps := fake.NewSimpleClientset().CoreV1().ConfigMaps("argo-lite")
+	store := newConfigMapStateStore(configMaps, "team-a/web", 2)
+
+	state, err := store.load()
+	if err != nil || len(state.History) != 0 || state.Rollback != nil {
+		t.Fatalf("expected an empty state before the first save, got %+v (%v)", state, err)
+	}
+
+	spec := config.Application{Name: "team-a/web", RepoURL: "https://git.example.com/web.git", Revision: "main"}
+	for _, commit := range []string{"a", "b", "c"} {
+		state.History = append(state.History, HistoryEntry{Commit: commit, SyncedAt: time.Now(), Succeeded: true})
+		state.Spec, state.AppliedCommit = &spec, commit
+		state.LastSync = &SyncResult{Commit: commit, FinishedAt: time.Now()}
+		if err := store.save(&state); err != nil {
+			t.Fatalf("save() returned an unexpected error: %v", err)
+		}
+	}
+
+	loaded, err := store.load()
+	if err != nil {
+		t.Fatalf("load() returned an unexpected error: %v", err)
+	}
+	if len(loaded.History) != 2 || loaded.History[0].Commit != "b" || loaded.History[1].Commit != "c" {
+		t.Errorf("expected the two most recent entries b and c, got %+v", loaded.History)
+	}
+	if loaded.Spec == nil || *loaded.Spec != spec || loaded.AppliedCommit != "c" || loaded.LastSync == nil || loaded.LastSync.Commit != "c" {
+		t.Errorf("expected the last sync of c with its spec, got %+v", loaded)
+	}
+
+	configMap, err := configMaps.Get(context.TODO(), store.name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the state ConfigMap %s, got %v", store.name, err)
+	}
+	if configMap.Annotations[stateApplicationAnnotation] != "team-a/web" {
+		t.Errorf("expected the ConfigMap to name its application, got %v", configMap.Annotations)
+	}
+	if other := newConfigMapStateStore(configMaps, "team-b/web", 2); other.name == store.name {
+		t.Errorf("expected applications to get their own ConfigMap, got %s for both", store.name)
+	}
+}
+
+func TestStateStores(t *testing.T) {
+	t.Helper()
+	cfg := &config.Config{StateStore: config.StateStoreFile, StateDir: t.TempDir(), SyncHistoryLimit: 5}
+	stores, err := newStateStores(cfg)
+	if err != nil {
+		t.Fatalf("newStateStores() returned an unexpected error: %v", err)
+	}
+	if _, ok := stores.forApplication("web").(*fileStateStore); !ok {
+		t.Errorf("expected the file store by default, got %T", stores.forApplication("web"))
+	}
+	stores.configMaps = fake.NewSimpleClientset().CoreV1().ConfigMaps("argo-lite")
+	if _, ok := stores.forApplication("web").(*configMapStateStore); !ok {
+		t.Errorf("expected the ConfigMap store, got %T", stores.forApplication("web"))
+	}
+}
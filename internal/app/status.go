@@ -0,0 +1,182 @@
+package app
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/user/go-argo-lite/internal/gitpoller"
+	"github.com/user/go-argo-lite/pkg/utils"
+)
+
+// readyPollIntervals is how many poll intervals may pass without a successful
+// poll before an application is no longer ready.
+const readyPollIntervals = 3
+
+// statusResponse is the body of /api/status.
+type statusResponse struct {
+	Ready        bool                `json:"ready"`
+	Applications []applicationStatus `json:"applications"`
+}
+
+// applicationStatus reports the state of one application.
+type applicationStatus struct {
+	Name           string      `json:"name"`
+	RepoURL        string      `json:"repoURL"`  // Without credentials
+	Revision       string      `json:"revision"` // Branch, tag, commit SHA or semver constraint followed
+	Branch         string      `json:"branch"`   // Deprecated: the same as Revision, which it was called before
+	Path           string      `json:"path"`
+	Ready          bool        `json:"ready"`
+	LastPolledAt   *time.Time  `json:"lastPolledAt,omitempty"`
+	LastCommitHash string      `json:"lastCommitHash,omitempty"` // Last fetched commit
+	AppliedCommit  string      `json:"appliedCommit,omitempty"`  // Last successfully synced commit
+	LastPollError  string      `json:"lastPollError,omitempty"`
+	PollErrorClass string      `json:"pollErrorClass,omitempty"` // gitpoller.ErrorClass of LastPollError
+	PollFailures   int         `json:"pollFailures,omitempty"`   // Consecutive failed polls
+	NextPollAt     *time.Time  `json:"nextPollAt,omitempty"`     // End of the backoff after PollFailures
+	LastSync       *syncStatus `json:"lastSync,omitempty"`
+	Retry          *SyncRetry  `json:"retry,omitempty"` // Set while the last sync failed
+	// Rollback is set while auto-sync is paused after a rollback.
+	Rollback *RollbackState `json:"rollback,omitempty"`
+	History  []HistoryEntry `json:"history"` // Most recent sync first
+}
+
+// syncStatus reports the outcome of a sync.
+type syncStatus struct {
+	Commit         string                 `json:"commit"`
+	StartedAt      time.Time              `json:"startedAt"`
+	FinishedAt     time.Time              `json:"finishedAt"`
+	Succeeded      bool                   `json:"succeeded"`
+	Health         string                 `json:"health,omitempty"`
+	Errors         []string               `json:"errors,omitempty"`
+	ManifestErrors []manifestErrorStatus  `json:"manifestErrors,omitempty"`
+	Pruned         []string               `json:"pruned,omitempty"`
+	Resources      []resourceHealthStatus `json:"resources,omitempty"`
+}
+
+// manifestErrorStatus reports an error of one manifest document.
+type manifestErrorStatus struct {
+	Source  string `json:"source"`
+	Index   int    `json:"index,omitempty"` // Position of the document in Source, starting at 1; 0 for the whole file
+	Line    int    `json:"line,omitempty"`  // Line of Source where the document starts
+	Message string `json:"message"`
+}
+
+// resourceHealthStatus reports the health of one applied object.
+type resourceHealthStatus struct {
+	Resource string `json:"resource"`
+	Health   string `json:"health"`
+	Message  string `json:"message,omitempty"`
+}
+
+// handleHealthz reports that the process is alive.
+func (a *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether every application has initialized its
+// repository and polled it recently.
+func (a *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !a.status().Ready {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// handleStatus reports the state of every application as JSON.
+func (a *App) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(a.status()); err != nil {
+		log.Printf("Failed to write status response: %v", err)
+	}
+}
+
+// status collects the state of every application.
+func (a *App) status() statusResponse {
+	a.mu.Lock()
+	applications := append([]*application(nil), a.applications...)
+	a.mu.Unlock()
+
+	response := statusResponse{Ready: true, Applications: []applicationStatus{}}
+	for _, app := range applications {
+		status := app.status()
+		response.Ready = response.Ready && status.Ready
+		response.Applications = append(response.Applications, status)
+	}
+	return response
+}
+
+// status reports the state of a. It is ready once its run loop started and, at
+// most readyPollIntervals poll intervals ago, either polled successfully or,
+// before its first poll, started.
+func (a *application) status() applicationStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	status := applicationStatus{
+		Name:           a.spec.Name,
+		RepoURL:        utils.RedactURL(a.spec.RepoURL),
+		Revision:       a.spec.Revision,
+		Branch:         a.spec.Revision,
+		Path:           a.spec.Path,
+		LastCommitHash: a.lastCommitHash,
+		AppliedCommit:  a.appliedCommit,
+	}
+	lastActive := a.startedAt
+	if !a.lastPolledAt.IsZero() {
+		polledAt := a.lastPolledAt
+		status.LastPolledAt = &polledAt
+		lastActive = polledAt
+	}
+	window := readyPollIntervals * time.Duration(a.cfg.PollIntervalSeconds) * time.Second
+	status.Ready = !lastActive.IsZero() && time.Since(lastActive) < window
+	if a.lastPollErr != nil {
+		status.LastPollError = a.lastPollErr.Error()
+		status.PollErrorClass = string(gitpoller.ClassifyError(a.lastPollErr))
+	}
+	status.PollFailures = a.pollFailures
+	if a.nextPollAt != nil {
+		nextPollAt := *a.nextPollAt
+		status.NextPollAt = &nextPollAt
+	}
+
+	status.Rollback = a.state.Rollback
+	if a.retry != nil {
+		retry := *a.retry
+		status.Retry = &retry
+	}
+	status.History = make([]HistoryEntry, 0, len(a.state.History))
+	for i := len(a.state.History) - 1; i >= 0; i-- {
+		status.History = append(status.History, a.state.History[i])
+	}
+
+	if result := a.lastSync; result != nil {
+		sync := &syncStatus{
+			Commit:     result.Commit,
+			StartedAt:  result.StartedAt,
+			FinishedAt: result.FinishedAt,
+			Succeeded:  result.Succeeded(),
+			Health:     string(result.Health),
+			Errors:     result.Errors,
+		}
+		for _, err := range result.ManifestErrors {
+			sync.ManifestErrors = append(sync.ManifestErrors, manifestErrorStatus{Source: err.Source, Index: err.Index, Line: err.Line, Message: err.Message})
+		}
+		for _, key := range result.Pruned {
+			sync.Pruned = append(sync.Pruned, key.String())
+		}
+		for _, resource := range result.Resources {
+			sync.Resources = append(sync.Resources, resourceHealthStatus{Resource: resource.Key.String(), Health: string(resource.Status), Message: resource.Message})
+		}
+		status.LastSync = sync
+	}
+	return status
+}
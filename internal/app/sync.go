@@ -0,0 +1,268 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/user/go-argo-lite/internal/gitpoller"
+	"github.com/user/go-argo-lite/internal/kubehandler"
+)
+
+// SyncResult records the outcome of applying one commit to the cluster.
+type SyncResult struct {
+	Commit     string                       `json:"commit"`
+	StartedAt  time.Time                    `json:"startedAt"`
+	FinishedAt time.Time                    `json:"finishedAt"`
+	Errors     []string                     `json:"errors,omitempty"`    // Apply and prune errors, one entry per failing manifest or step
+	Pruned     []kubehandler.ResourceKey    `json:"pruned,omitempty"`    // Objects deleted because they left Git
+	Health     kubehandler.HealthStatus     `json:"health,omitempty"`    // Aggregated health; empty when it was not assessed
+	Resources  []kubehandler.ResourceHealth `json:"resources,omitempty"` // Per-object health
+	// ManifestErrors are the errors of Errors that belong to a single manifest
+	// document: load, plan, apply and hook errors.
+	ManifestErrors []kubehandler.ManifestError `json:"manifestErrors,omitempty"`
+}
+
+// Succeeded reports whether every manifest applied cleanly and, if health was
+// assessed, all resources became healthy.
+func (r *SyncResult) Succeeded() bool {
+	return len(r.Errors) == 0 && (r.Health == "" || r.Health == kubehandler.HealthHealthy)
+}
+
+// addManifestError records err, which belongs to a single manifest document.
+func (r *SyncResult) addManifestError(err kubehandler.ManifestError) {
+	r.Errors = append(r.Errors, err.Error())
+	r.ManifestErrors = append(r.ManifestErrors, err)
+}
+
+// syncCommit syncs the manifests of commitHash to the cluster, recording
+// loadErrors as sync errors. Unless changes is nil, only the documents of the
+// files it added or modified are applied; all manifests still count for
+// pruning, health and hooks. PreSync hooks
+// run first, then the regular manifests are applied wave by wave, objects that are
+// no longer declared are pruned when pruning is enabled, and the declared objects
+// are waited on until healthy when a health timeout is configured. PostSync hooks
+// run if all of that succeeded, SyncFail hooks otherwise. Health waits end early
+// when the sync is superseded; see waitContext. No hooks run after that.
+func (a *application) syncCommit(ctx context.Context, commitHash string, manifests []kubehandler.Manifest, loadErrors []kubehandler.ManifestError, changes *gitpoller.ManifestChanges) *SyncResult {
+	result := &SyncResult{Commit: commitHash, StartedAt: time.Now()}
+	ctx, release := a.waitContext(ctx)
+	defer release()
+
+	plan, planErrors := kubehandler.PlanSync(manifests)
+	for _, loadErr := range append(loadErrors, planErrors...) {
+		a.logger.Printf("Error loading manifest %v", loadErr)
+		result.addManifestError(loadErr)
+	}
+
+	applyPlan := plan
+	if changes != nil {
+		applyPlan = changedOnly(plan, changes)
+		a.logger.Printf("Applying only the %d of %d document(s) in the %d file(s) added or modified by commit %s.", len(applyPlan.Resources()), len(plan.Resources()), len(changes.Added)+len(changes.Modified), commitHash)
+	}
+
+	completed := a.runHooks(kubehandler.HookPreSync, plan, result) && a.applyWaves(ctx, commitHash, applyPlan, result)
+
+	desired, keyErrors := a.kubeHandler.ResourceKeys(plan.Resources())
+	for _, keyErr := range keyErrors {
+		a.logger.Printf("Could not determine resource identity: %v", keyErr)
+	}
+	complete := len(loadErrors) == 0 && len(planErrors) == 0 && len(keyErrors) == 0
+
+	if a.spec.SyncPolicy.Prune {
+		if completed && complete {
+			a.prune(commitHash, desired, result)
+		} else {
+			a.logger.Printf("Skipping prune for commit %s: the sync was aborted or the set of declared resources is incomplete.", commitHash)
+		}
+	}
+
+	if completed && a.cfg.HealthTimeoutSeconds > 0 && len(desired) > 0 {
+		timeout := time.Duration(a.cfg.HealthTimeoutSeconds) * time.Second
+		a.logger.Printf("Waiting up to %s for %d resource(s) of commit %s to become healthy...", timeout, len(desired), commitHash)
+		result.Resources = a.kubeHandler.WaitForHealthy(ctx, desired, timeout)
+		result.Health = kubehandler.AggregateHealth(result.Resources)
+		a.logger.Printf("Health of commit %s: %s", commitHash, result.Health)
+	}
+
+	if ctx.Err() != nil {
+		a.logger.Printf("Stopped waiting for the resources of commit %s to become healthy: a newer revision or request is pending. Skipping its PostSync and SyncFail hooks.", commitHash)
+		result.Errors = append(result.Errors, "interrupted before the resources became healthy")
+		result.FinishedAt = time.Now()
+		return result
+	}
+
+	if result.Succeeded() {
+		a.runHooks(kubehandler.HookPostSync, plan, result)
+	}
+	if !result.Succeeded() {
+		a.runHooks(kubehandler.HookSyncFail, plan, result)
+	}
+
+	result.FinishedAt = time.Now()
+	return result
+}
+
+// changedOnly returns plan without the regular manifests of files that changes
+// does not list as added or modified. Waves left empty are dropped.
+func changedOnly(plan *kubehandler.SyncPlan, changes *gitpoller.ManifestChanges) *kubehandler.SyncPlan {
+	changed := map[string]bool{}
+	for _, file := range append(append([]string(nil), changes.Added...), changes.Modified...) {
+		changed[file] = true
+	}
+	filtered := &kubehandler.SyncPlan{Hooks: plan.Hooks}
+	for _, wave := range plan.Waves {
+		var manifests []kubehandler.Manifest
+		for _, m := range wave.Manifests {
+			if changed[m.Source] {
+				manifests = append(manifests, m)
+			}
+		}
+		if len(manifests) > 0 {
+			filtered.Waves = append(filtered.Waves, kubehandler.SyncWave{Wave: wave.Wave, Manifests: manifests})
+		}
+	}
+	return filtered
+}
+
+// applyWaves applies the sync waves of plan in order. Before moving on to the next
+// wave it requires the current one to have applied without errors and, when a health
+// timeout is configured, to have become healthy. It returns false if a later wave
+// was skipped because of that.
+func (a *application) applyWaves(ctx context.Context, commitHash string, plan *kubehandler.SyncPlan, result *SyncResult) bool {
+	for i, wave := range plan.Waves {
+		a.logger.Printf("Applying sync wave %d (%d document(s)) for commit %s in dependency order...", wave.Wave, len(wave.Manifests), commitHash)
+		applyErrors := a.kubeHandler.ApplyManifests(wave.Manifests)
+		for _, applyErr := range applyErrors {
+			result.addManifestError(applyErr)
+		}
+		if len(applyErrors) > 0 {
+			a.logger.Printf("Finished applying sync wave %d for commit %s with %d error(s).", wave.Wave, commitHash, len(applyErrors))
+		} else {
+			a.logger.Printf("All documents of sync wave %d for commit %s applied successfully.", wave.Wave, commitHash)
+		}
+
+		if i == len(plan.Waves)-1 {
+			break
+		}
+		if len(applyErrors) > 0 {
+			a.logger.Printf("Not applying later sync waves for commit %s because wave %d failed.", commitHash, wave.Wave)
+			return false
+		}
+		if a.cfg.HealthTimeoutSeconds > 0 {
+			keys, _ := a.kubeHandler.ResourceKeys(wave.Manifests)
+			timeout := time.Duration(a.cfg.HealthTimeoutSeconds) * time.Second
+			a.logger.Printf("Waiting up to %s for sync wave %d to become healthy...", timeout, wave.Wave)
+			if health := kubehandler.AggregateHealth(a.kubeHandler.WaitForHealthy(ctx, keys, timeout)); health != kubehandler.HealthHealthy {
+				a.logger.Printf("Not applying later sync waves for commit %s because wave %d is %s.", commitHash, wave.Wave, health)
+				result.Errors = append(result.Errors, fmt.Sprintf("sync wave %d is %s", wave.Wave, health))
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// waitContext returns the context of the health waits of a sync. It is canceled
+// with ctx, when a webhook or rollback request is queued for the run loop (see
+// interruptWait), or when a poll tick finds that the revision moved on the
+// remote, so that a long health wait does not hold up newer revisions. release
+// must be called when the sync is done.
+func (a *application) waitContext(ctx context.Context) (waitCtx context.Context, release func()) {
+	waitCtx, cancel := context.WithCancel(ctx)
+	a.mu.Lock()
+	a.cancelWait = cancel
+	a.mu.Unlock()
+	release = func() {
+		a.mu.Lock()
+		a.cancelWait = nil
+		a.mu.Unlock()
+		cancel()
+	}
+	if a.cfg.HealthTimeoutSeconds <= 0 {
+		return waitCtx, release // Nothing waits
+	}
+
+	remoteHead := a.poller.RemoteHead()
+	go func() {
+		initial, err := remoteHead()
+		if err != nil {
+			a.logger.Printf("Not watching %s for new commits during the sync: %v", a.spec.Revision, err)
+			return
+		}
+		ticker := time.NewTicker(time.Duration(a.cfg.PollIntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-waitCtx.Done():
+				return
+			case <-ticker.C:
+				if head, err := remoteHead(); err == nil && head != initial {
+					a.logger.Printf("Revision %s moved to %s during the sync.", a.spec.Revision, head)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return waitCtx, release
+}
+
+// runHooks runs the hooks of phase from plan, recording failures in result.
+// It returns true if all of them succeeded.
+func (a *application) runHooks(phase kubehandler.HookPhase, plan *kubehandler.SyncPlan, result *SyncResult) bool {
+	hooks := plan.Hooks[phase]
+	if len(hooks) == 0 {
+		return true
+	}
+	a.logger.Printf("Running %d %s hook(s) for commit %s...", len(hooks), phase, result.Commit)
+	hookErrors := a.kubeHandler.RunHooks(phase, hooks, time.Duration(a.cfg.HookTimeoutSeconds)*time.Second)
+	for _, hookErr := range hookErrors {
+		result.addManifestError(hookErr)
+	}
+	return len(hookErrors) == 0
+}
+
+// prune deletes tracked objects that are not in desired and records the outcome in result.
+func (a *application) prune(commitHash string, desired []kubehandler.ResourceKey, result *SyncResult) {
+	a.logger.Printf("Pruning resources not declared in commit %s (%d desired)...", commitHash, len(desired))
+	pruned, err := a.kubeHandler.Prune(desired)
+	for _, key := range pruned {
+		a.logger.Printf("Pruned %s", key)
+	}
+	result.Pruned = pruned
+	if err != nil {
+		a.logger.Printf("Pruning for commit %s finished with errors: %v", commitHash, err)
+		result.Errors = append(result.Errors, fmt.Sprintf("prune: %v", err))
+		return
+	}
+	a.logger.Printf("Pruning for commit %s complete: %d object(s) deleted.", commitHash, len(pruned))
+}
+
+// diffCommit logs what applying the manifests of commitHash would change in
+// the cluster, using server-side dry runs. Nothing is applied or pruned.
+func (a *application) diffCommit(commitHash string, manifests []kubehandler.Manifest, loadErrors []kubehandler.ManifestError) {
+	a.logger.Printf("Diff-only mode: computing changes for commit %s without applying them.", commitHash)
+	for _, loadErr := range loadErrors {
+		a.logger.Printf("Error loading manifest %v", loadErr)
+	}
+
+	diffs, diffErrors := a.kubeHandler.DiffManifests(manifests)
+	changedResources := 0
+	for _, d := range diffs {
+		switch {
+		case d.New:
+			changedResources++
+			a.logger.Printf("%s would be created:\n%s", d.Key, d.Diff)
+		case d.Diff != "":
+			changedResources++
+			a.logger.Printf("%s would be changed:\n%s", d.Key, d.Diff)
+		default:
+			a.logger.Printf("%s is up to date", d.Key)
+		}
+	}
+	for _, diffErr := range diffErrors {
+		a.logger.Printf("Error diffing manifest %v", diffErr)
+	}
+	a.logger.Printf("Diff for commit %s complete: %d resource(s) would change.", commitHash, changedResources)
+}
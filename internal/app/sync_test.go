@@ -0,0 +1,89 @@
+package app
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/user/go-argo-lite/internal/config"
+	"github.com/user/go-argo-lite/internal/gitpoller"
+	"github.com/user/go-argo-lite/internal/kubehandler"
+)
+
+func TestChangedOnly(t *testing.T) {
+	t.Helper()
+	manifest := func(source string) kubehandler.Manifest {
+		return kubehandler.Manifest{Source: source, Index: 1, Object: &unstructured.Unstructured{}}
+	}
+	hook := manifest("hooks.yaml")
+	plan := &kubehandler.SyncPlan{
+		Waves: []kubehandler.SyncWave{
+			{Wave: -1, Manifests: []kubehandler.Manifest{manifest("crds.yaml")}},
+			{Wave: 0, Manifests: []kubehandler.Manifest{manifest("a.yaml"), manifest("b.yaml"), manifest("c.yaml")}},
+		},
+		Hooks: map[kubehandler.HookPhase][]kubehandler.Manifest{kubehandler.HookPreSync: {hook}},
+	}
+
+	filtered := changedOnly(plan, &gitpoller.ManifestChanges{Added: []string{"c.yaml"}, Modified: []string{"a.yaml"}, Deleted: []string{"crds.yaml"}})
+	if len(filtered.Waves) != 1 || filtered.Waves[0].Wave != 0 {
+		t.Fatalf("expected only wave 0 to remain, got %+v", filtered.Waves)
+	}
+	resources := filtered.Resources()
+	if len(resources) != 2 || resources[0].Source != "a.yaml" || resources[1].Source != "c.yaml" {
+		t.Errorf("expected the documents of a.yaml and c.yaml, got %+v", resources)
+	}
+	if len(filtered.Hooks[kubehandler.HookPreSync]) != 1 {
+		t.Errorf("expected hooks to be kept, got %+v", filtered.Hooks)
+	}
+	if len(plan.Resources()) != 4 {
+		t.Errorf("expected the original plan to be unchanged, got %d resources", len(plan.Resources()))
+	}
+}
+
+func TestSyncScope(t *testing.T) {
+	t.Helper()
+	app := &application{cfg: &config.Config{FullSyncIntervalSeconds: 3600}}
+	changes := &gitpoller.ManifestChanges{From: "abc", To: "def", Modified: []string{"a.yaml"}}
+	if app.syncScope(changes) != nil {
+		t.Error("expected a full sync before the first sync")
+	}
+
+	app.lastSync = &SyncResult{Commit: "abc"}
+	app.syncedCommit = "abc"
+	if app.syncScope(changes) != changes {
+		t.Error("expected an incremental sync after a successful sync of the parent commit")
+	}
+	if app.syncScope(nil) != nil {
+		t.Error("expected a full sync when the changes are unknown")
+	}
+
+	rendered := &gitpoller.ManifestChanges{From: "abc", To: "def", Modified: []string{"values.yaml"}, Rendered: true}
+	if app.syncScope(rendered) != nil {
+		t.Error("expected a full sync after a change to a rendered manifest directory")
+	}
+	unchangedRendered := &gitpoller.ManifestChanges{From: "abc", To: "def", Rendered: true}
+	if app.syncScope(unchangedRendered) != unchangedRendered {
+		t.Error("expected an unchanged rendered manifest directory to be skipped")
+	}
+
+	app.syncedCommit = "xyz"
+	if app.syncScope(changes) != nil {
+		t.Error("expected a full sync when the cluster does not run the parent commit")
+	}
+
+	app.syncedCommit = "abc"
+	app.lastSync.Errors = []string{"apply failed"}
+	if app.syncScope(changes) != nil {
+		t.Error("expected a full sync after a failed sync")
+	}
+
+	app.lastSync.Errors = nil
+	app.cfg.FullSyncIntervalSeconds = 0
+	if app.syncScope(changes) != nil {
+		t.Error("expected a full sync with incremental syncs disabled")
+	}
+	unchanged := &gitpoller.ManifestChanges{From: "abc", To: "def"}
+	if app.syncScope(unchanged) != unchanged {
+		t.Error("expected a commit that changes no manifest file to be skipped with incremental syncs disabled")
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Application is one set of manifests in Git deployed to one destination.
+type Application struct {
+	Name        string      `json:"name"`
+	RepoURL     string      `json:"repoURL"`
+	Revision    string      `json:"revision"`       // Branch, tag, full commit SHA or semver constraint on tags to follow
+	Path        string      `json:"path,omitempty"` // Manifest directory within the repository; defaults to "manifests"
+	Destination Destination `json:"destination,omitempty"`
+	SyncPolicy  SyncPolicy  `json:"syncPolicy,omitempty"`
+	// AdoptUnscopedObjects lets the application prune objects that were applied
+	// without an application, i.e. from the environment configuration before
+	// APPS_FILE was set. At most one application may set it.
+	AdoptUnscopedObjects bool `json:"adoptUnscopedObjects,omitempty"`
+}
+
+// Destination is the cluster and namespace an Application is deployed to.
+type Destination struct {
+	// Kubeconfig selects the cluster; empty means KUBECONFIG_PATH, or the
+	// in-cluster configuration if that is unset as well.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Namespace  string `json:"namespace,omitempty"` // Namespace of objects that do not set one; defaults to "default"
+}
+
+// SyncPolicy controls how an Application is kept in sync with Git.
+type SyncPolicy struct {
+	Prune    bool `json:"prune,omitempty"`
+	SelfHeal bool `json:"selfHeal,omitempty"`
+}
+
+// appsFile is the document format of APPS_FILE.
+type appsFile struct {
+	Applications []Application `json:"applications"`
+}
+
+// LoadApplications reads the YAML or JSON apps file at path and validates the
+// applications it declares.
+func LoadApplications(path string) ([]Application, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apps file %s: %w", path, err)
+	}
+	var file appsFile
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse apps file %s: %w", path, err)
+	}
+	if len(file.Applications) == 0 {
+		return nil, fmt.Errorf("apps file %s declares no applications", path)
+	}
+
+	seen := make(map[string]bool, len(file.Applications))
+	adopter := ""
+	for i := range file.Applications {
+		app := &file.Applications[i]
+		if app.Path == "" {
+			app.Path = "manifests"
+		}
+		if err := app.validate(); err != nil {
+			return nil, fmt.Errorf("apps file %s: application #%d: %w", path, i+1, err)
+		}
+		if seen[app.Name] {
+			return nil, fmt.Errorf("apps file %s: duplicate application name %q", path, app.Name)
+		}
+		seen[app.Name] = true
+		if app.AdoptUnscopedObjects {
+			if adopter != "" {
+				return nil, fmt.Errorf("apps file %s: applications %q and %q both adopt unscoped objects", path, adopter, app.Name)
+			}
+			adopter = app.Name
+		}
+	}
+	return file.Applications, nil
+}
+
+// validate checks the fields of a that have no usable default.
+func (a *Application) validate() error {
+	if msgs := validation.IsDNS1123Label(a.Name); len(msgs) > 0 {
+		return fmt.Errorf("invalid name %q: %s", a.Name, msgs[0])
+	}
+	if a.RepoURL == "" {
+		return errors.New("repoURL is required")
+	}
+	if a.Revision == "" {
+		return errors.New("revision is required")
+	}
+	if !filepath.IsLocal(filepath.Clean(a.Path)) {
+		return fmt.Errorf("path %q must be a directory within the repository", a.Path)
+	}
+	if a.Destination.Namespace != "" {
+		if msgs := validation.IsDNS1123Label(a.Destination.Namespace); len(msgs) > 0 {
+			return fmt.Errorf("invalid destination namespace %q: %s", a.Destination.Namespace, msgs[0])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadApplications(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "apps.yaml")
+	content := `applications:
+- name: web
+  repoURL: https://git.example.com/apps.git
+  revision: main
+  path: deploy/web
+  destination:
+    namespace: web
+  syncPolicy:
+    prune: true
+- name: api
+  repoURL: https://git.example.com/apps.git
+  revision: main
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write apps file: %v", err)
+	}
+
+	apps, err := LoadApplications(path)
+	if err != nil {
+		t.Fatalf("LoadApplications() returned an unexpected error: %v", err)
+	}
+	if len(apps) != 2 {
+		t.Fatalf("expected 2 applications, got %d", len(apps))
+	}
+	if apps[0].Path != "deploy/web" || apps[0].Destination.Namespace != "web" || !apps[0].SyncPolicy.Prune || apps[0].SyncPolicy.SelfHeal {
+		t.Errorf("unexpected first application: %+v", apps[0])
+	}
+	if apps[1].Path != "manifests" {
+		t.Errorf("expected the path of the second application to default to manifests, got %q", apps[1].Path)
+	}
+}
+
+func TestLoadApplications_Invalid(t *testing.T) {
+	t.Helper()
+	tests := []struct {
+		name        string
+		content     string
+		expectedErr string
+	}{
+		{"empty", `applications: []`, "declares no applications"},
+		{"unknown field", `{"applications": [{"name": "web", "repoURL": "u", "revision": "main", "branch": "main"}]}`, "unknown field"},
+		{"missing revision", "applications:\n- name: web\n  repoURL: u\n", "revision is required"},
+		{"invalid name", "applications:\n- name: Web_App\n  repoURL: u\n  revision: main\n", "invalid name"},
+		{"absolute path", "applications:\n- name: web\n  repoURL: u\n  revision: main\n  path: /etc\n", "must be a directory within the repository"},
+		{"parent path", "applications:\n- name: web\n  repoURL: u\n  revision: main\n  path: ../repo-1/secrets\n", "must be a directory within the repository"},
+		{"escaping path", "applications:\n- name: web\n  repoURL: u\n  revision: main\n  path: a/../../b\n", "must be a directory within the repository"},
+		{"two adopters", "applications:\n- name: web\n  repoURL: u\n  revision: main\n  adoptUnscopedObjects: true\n- name: api\n  repoURL: u\n  revision: main\n  adoptUnscopedObjects: true\n", "both adopt unscoped objects"},
+		{"duplicate name", "applications:\n- name: web\n  repoURL: u\n  revision: main\n- name: web\n  repoURL: v\n  revision: main\n", "duplicate application name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "apps.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write apps file: %v", err)
+			}
+			_, err := LoadApplications(path)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_AppsFile(t *testing.T) {
+	t.Helper()
+	originalRepoURL := os.Getenv("REPO_URL")
+	originalRepoBranch := os.Getenv("REPO_BRANCH")
+	originalAppsFile := os.Getenv("APPS_FILE")
+
+	path := filepath.Join(t.TempDir(), "apps.json")
+	if err := os.WriteFile(path, []byte(`{"applications": [{"name": "web", "repoURL": "https://git.example.com/web.git", "revision": "main"}]}`), 0644); err != nil {
+		t.Fatalf("Failed to write apps file: %v", err)
+	}
+	os.Unsetenv("REPO_URL")
+	os.Unsetenv("REPO_BRANCH")
+	os.Setenv("APPS_FILE", path)
+
+	defer func() {
+		os.Setenv("REPO_URL", originalRepoURL)
+		os.Setenv("REPO_BRANCH", originalRepoBranch)
+		os.Setenv("APPS_FILE", originalAppsFile)
+	}()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
+	}
+	if len(cfg.Applications) != 1 || cfg.Applications[0].Name != "web" {
+		t.Errorf("expected the application from the apps file, got %+v", cfg.Applications)
+	}
+
+	os.Unsetenv("APPS_FILE")
+	os.Setenv("REPO_URL", "https://git.example.com/repo.git")
+	os.Setenv("REPO_BRANCH", "main")
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
+	}
+	if len(cfg.Applications) != 1 || cfg.Applications[0].RepoURL != "https://git.example.com/repo.git" || cfg.Applications[0].Revision != "main" {
+		t.Errorf("expected a single default application from the environment, got %+v", cfg.Applications)
+	}
+}
+
+func TestLoadConfig_ControllerMode(t *testing.T) {
+	t.Helper()
+	originalRepoURL := os.Getenv("REPO_URL")
+	originalRepoBranch := os.Getenv("REPO_BRANCH")
+	originalAppsFile := os.Getenv("APPS_FILE")
+	originalControllerMode := os.Getenv("CONTROLLER_MODE")
+	originalWatchNamespace := os.Getenv("WATCH_NAMESPACE")
+
+	os.Unsetenv("REPO_URL")
+	os.Unsetenv("REPO_BRANCH")
+	os.Unsetenv("APPS_FILE")
+	os.Setenv("CONTROLLER_MODE", "true")
+	os.Setenv("WATCH_NAMESPACE", "apps")
+
+	defer func() {
+		os.Setenv("REPO_URL", originalRepoURL)
+		os.Setenv("REPO_BRANCH", originalRepoBranch)
+		os.Setenv("APPS_FILE", originalAppsFile)
+		os.Setenv("CONTROLLER_MODE", originalControllerMode)
+		os.Setenv("WATCH_NAMESPACE", originalWatchNamespace)
+	}()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
+	}
+	if !cfg.ControllerMode || cfg.WatchNamespace != "apps" || len(cfg.Applications) != 0 {
+		t.Errorf("expected controller mode in namespace apps without applications, got %+v", cfg)
+	}
+
+	os.Setenv("APPS_FILE", filepath.Join(t.TempDir(), "apps.yaml"))
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error when both CONTROLLER_MODE and APPS_FILE are set")
+	}
+}
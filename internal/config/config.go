@@ -2,30 +2,156 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
+)
+
+// Values of Config.StateStore.
+const (
+	StateStoreFile      = "file"
+	StateStoreConfigMap = "configmap"
 )
 
 // Config holds the application configuration, loaded from environment variables.
 type Config struct {
-	RepoURL             string
+	// Applications are the applications deployed by this process: the ones
+	// declared in AppsFile or, without one, a single application named
+	// "default" built from RepoURL, RepoBranch, ManifestPath, Prune and SelfHeal.
+	// It is empty in controller mode.
+	Applications []Application
+	AppsFile     string
+
+	// ControllerMode deploys the Application custom resources found in the
+	// cluster instead of the applications above; WatchNamespace restricts it to
+	// one namespace, empty meaning all namespaces. An Application only manages
+	// objects in its own namespace and cluster-scoped objects of ClusterKinds,
+	// given as Kind or Kind.group.
+	ControllerMode bool
+	WatchNamespace string
+	ClusterKinds   []string
+
+	RepoURL string
+	// RepoBranch is the revision to follow: a branch, a tag, a full commit SHA
+	// or a semver constraint on tags such as "~1.4".
 	RepoBranch          string
 	KubeconfigPath      string
 	PollIntervalSeconds int
 	ManifestPath        string
+	Prune               bool // Delete tracked objects that disappeared from the manifests
+	DiffOnly            bool // Log a dry-run diff for each new commit instead of applying it
+	// HealthTimeoutSeconds bounds the wait for applied resources (and each sync
+	// wave) to become healthy; 0 disables health checks.
+	HealthTimeoutSeconds int
+	HookTimeoutSeconds   int // Maximum run time of a single PreSync/PostSync/SyncFail hook
+	// DriftCheckIntervalSeconds is how often live objects are compared with the
+	// manifests of the last commit between polls; 0 disables drift detection.
+	DriftCheckIntervalSeconds int
+	SelfHeal                  bool // Re-apply drifted objects instead of only reporting them
+	// FullSyncIntervalSeconds is how often all manifests are re-applied, even
+	// without a new commit; in between, a new commit only applies the manifest
+	// files it changed. 0 re-applies all manifests on every new commit that
+	// changed a manifest file.
+	FullSyncIntervalSeconds int
+	// A failed sync is retried for the same commit up to SyncRetryMaxAttempts
+	// times (0 disables retries), waiting SyncRetryBackoffSeconds before the
+	// first retry and twice as long before each further one, up to
+	// SyncRetryMaxBackoffSeconds.
+	SyncRetryMaxAttempts       int
+	SyncRetryBackoffSeconds    int
+	SyncRetryMaxBackoffSeconds int
+	// FetchBackoffMaxSeconds caps the backoff of polls after consecutive
+	// repository fetch failures: the wait starts at PollIntervalSeconds and
+	// doubles with every failure, with jitter. 0 keeps polling at the interval.
+	FetchBackoffMaxSeconds int
+
+	// Rendering of a Helm chart at ManifestPath. Values files are relative to
+	// the chart directory and applied in order; HelmSet uses `helm --set` syntax.
+	HelmReleaseName string
+	HelmNamespace   string
+	HelmValuesFiles []string
+	HelmSet         string
+
+	HTTPListenAddr string // Address of the HTTP server, e.g. ":8080"; empty disables it
+	WebhookSecret  string // Shared secret of the push webhook endpoint; empty disables it
+	// APIToken authenticates the rollback and resume endpoints as a bearer
+	// token; empty disables them.
+	APIToken string
+
+	// StateStore is where the sync history, rollback and last sync of every
+	// application are persisted: StateStoreFile writes a file per application
+	// to StateDir, StateStoreConfigMap a ConfigMap per application to
+	// StateNamespace of the cluster of KubeconfigPath. An empty StateDir uses a
+	// directory within CloneDir, so that each instance keeps its own state,
+	// lost on shutdown if CloneDir is temporary.
+	StateStore       string
+	StateDir         string
+	StateNamespace   string
+	SyncHistoryLimit int // Number of syncs kept in the history of each application
+
+	// CloneDir holds the repository clones and is locked against use by other
+	// processes; empty uses a new temporary directory, which is removed on
+	// shutdown. CloneDirCleanup also removes the clones of CloneDir on shutdown,
+	// but not the state kept there.
+	CloneDir        string
+	CloneDirCleanup bool
+	// GitCloneDepth makes clones shallow, fetching only the last commits of
+	// each revision; 0 fetches the full history. Older commits are fetched
+	// when a diff or rollback needs them.
+	GitCloneDepth int
+	// GitSparseCheckout writes only the manifest directories to the working
+	// tree; kustomizations and charts must not refer to files outside them.
+	GitSparseCheckout bool
+	// GitBareClone keeps bare clones without a working tree: manifests are
+	// read from the Git objects of the commit being synced. Like with a sparse
+	// checkout, kustomizations and charts must not refer to files outside
+	// their directory.
+	GitBareClone bool
+
+	// Credentials for private repositories. Secrets may be given directly or,
+	// through the matching *_FILE variable, read from a mounted file.
+	GitUsername          string // Required together with GitPassword
+	GitPassword          string // HTTPS password or access token
+	GitSSHUser           string
+	GitSSHPrivateKey     string // PEM encoded private key
+	GitSSHKeyPassphrase  string
+	GitSSHKnownHostsPath string
+
+	// Keys trusted to sign commits. When either is set, only commits signed by
+	// one of the keys are deployed. Both may also be read from a file through
+	// the matching *_FILE variable.
+	GitTrustedGPGKeys string // Armored OpenPGP public keys
+	GitAllowedSigners string // SSH keys in the allowed signers format of ssh-keygen
+
+	// Private keys decrypting manifest files encrypted with SOPS. Both may
+	// also be read from a file through the matching *_FILE variable.
+	SopsAgeKey string // age identities, one per line
+	SopsPGPKey string // Armored OpenPGP private keys without a passphrase
 }
 
 // LoadConfig loads configuration from environment variables.
 // It returns a Config struct and an error if required variables are missing
 // or if there's an issue parsing them.
 func LoadConfig() (*Config, error) {
+	appsFile := os.Getenv("APPS_FILE")
+	controllerMode, err := getEnvBool("CONTROLLER_MODE", false)
+	if err != nil {
+		return nil, err
+	}
+	if controllerMode && appsFile != "" {
+		return nil, errors.New("CONTROLLER_MODE and APPS_FILE are mutually exclusive")
+	}
+	// Without a single application to build, the repository variables are optional.
+	standalone := appsFile == "" && !controllerMode
+
 	repoURL := os.Getenv("REPO_URL")
-	if repoURL == "" {
+	if repoURL == "" && standalone {
 		return nil, errors.New("REPO_URL environment variable is required")
 	}
 
 	repoBranch := os.Getenv("REPO_BRANCH")
-	if repoBranch == "" {
+	if repoBranch == "" && standalone {
 		return nil, errors.New("REPO_BRANCH environment variable is required")
 	}
 
@@ -46,11 +172,309 @@ func LoadConfig() (*Config, error) {
 		manifestPath = "manifests" // Default value
 	}
 
+	prune, err := getEnvBool("PRUNE", false)
+	if err != nil {
+		return nil, err
+	}
+
+	diffOnly, err := getEnvBool("DIFF_ONLY", false)
+	if err != nil {
+		return nil, err
+	}
+
+	healthTimeoutSeconds, err := getEnvInt("HEALTH_TIMEOUT_SECONDS", 300, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	hookTimeoutSeconds, err := getEnvInt("HOOK_TIMEOUT_SECONDS", 600, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	driftCheckIntervalSeconds, err := getEnvInt("DRIFT_CHECK_INTERVAL_SECONDS", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fullSyncIntervalSeconds, err := getEnvInt("FULL_SYNC_INTERVAL_SECONDS", 3600, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	syncRetryMaxAttempts, err := getEnvInt("SYNC_RETRY_MAX_ATTEMPTS", 5, 0)
+	if err != nil {
+		return nil, err
+	}
+	syncRetryBackoffSeconds, err := getEnvInt("SYNC_RETRY_BACKOFF_SECONDS", 10, 1)
+	if err != nil {
+		return nil, err
+	}
+	syncRetryMaxBackoffSeconds, err := getEnvInt("SYNC_RETRY_MAX_BACKOFF_SECONDS", 300, 1)
+	if err != nil {
+		return nil, err
+	}
+	if syncRetryMaxBackoffSeconds < syncRetryBackoffSeconds {
+		return nil, errors.New("SYNC_RETRY_MAX_BACKOFF_SECONDS must not be less than SYNC_RETRY_BACKOFF_SECONDS")
+	}
+
+	fetchBackoffMaxSeconds, err := getEnvInt("FETCH_BACKOFF_MAX_SECONDS", 900, 0)
+	if err != nil {
+		return nil, err
+	}
+	if fetchBackoffMaxSeconds > 0 && fetchBackoffMaxSeconds < pollIntervalSeconds {
+		return nil, errors.New("FETCH_BACKOFF_MAX_SECONDS must not be less than POLL_INTERVAL_SECONDS")
+	}
+
+	selfHeal, err := getEnvBool("SELF_HEAL", false)
+	if err != nil {
+		return nil, err
+	}
+
+	var helmValuesFiles []string
+	for _, name := range strings.Split(os.Getenv("HELM_VALUES_FILES"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			helmValuesFiles = append(helmValuesFiles, name)
+		}
+	}
+
+	var clusterKinds []string
+	for _, kind := range strings.Split(os.Getenv("CONTROLLER_CLUSTER_KINDS"), ",") {
+		if kind = strings.TrimSpace(kind); kind != "" {
+			clusterKinds = append(clusterKinds, kind)
+		}
+	}
+	if len(clusterKinds) > 0 && !controllerMode {
+		return nil, errors.New("CONTROLLER_CLUSTER_KINDS requires CONTROLLER_MODE")
+	}
+
+	httpListenAddr := os.Getenv("HTTP_LISTEN_ADDR")
+	webhookSecret, err := getEnvOrFile("WEBHOOK_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	if webhookSecret != "" && httpListenAddr == "" {
+		return nil, errors.New("WEBHOOK_SECRET requires HTTP_LISTEN_ADDR to be set")
+	}
+	apiToken, err := getEnvOrFile("API_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	if apiToken != "" && httpListenAddr == "" {
+		return nil, errors.New("API_TOKEN requires HTTP_LISTEN_ADDR to be set")
+	}
+
+	stateStore := os.Getenv("STATE_STORE")
+	if stateStore == "" {
+		stateStore = StateStoreFile // Default value
+	}
+	if stateStore != StateStoreFile && stateStore != StateStoreConfigMap {
+		return nil, fmt.Errorf("STATE_STORE must be %q or %q", StateStoreFile, StateStoreConfigMap)
+	}
+	stateNamespace := os.Getenv("STATE_NAMESPACE")
+	if stateStore == StateStoreConfigMap && stateNamespace == "" {
+		return nil, errors.New("STATE_STORE=configmap requires STATE_NAMESPACE to be set")
+	}
+	syncHistoryLimit, err := getEnvInt("SYNC_HISTORY_LIMIT", 20, 1)
+	if err != nil {
+		return nil, err
+	}
+	cloneDirCleanup, err := getEnvBool("CLONE_DIR_CLEANUP", false)
+	if err != nil {
+		return nil, err
+	}
+	gitCloneDepth, err := getEnvInt("GIT_CLONE_DEPTH", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	gitSparseCheckout, err := getEnvBool("GIT_SPARSE_CHECKOUT", false)
+	if err != nil {
+		return nil, err
+	}
+	gitBareClone, err := getEnvBool("GIT_BARE_CLONE", false)
+	if err != nil {
+		return nil, err
+	}
+	if gitBareClone && gitSparseCheckout {
+		return nil, errors.New("GIT_BARE_CLONE and GIT_SPARSE_CHECKOUT are mutually exclusive")
+	}
+
+	gitPassword, err := getEnvOrFile("GIT_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	gitSSHPrivateKey, err := getEnvOrFile("GIT_SSH_PRIVATE_KEY")
+	if err != nil {
+		return nil, err
+	}
+	gitSSHKeyPassphrase, err := getEnvOrFile("GIT_SSH_KEY_PASSPHRASE")
+	if err != nil {
+		return nil, err
+	}
+	if gitPassword != "" && gitSSHPrivateKey != "" {
+		return nil, errors.New("GIT_PASSWORD and GIT_SSH_PRIVATE_KEY are mutually exclusive")
+	}
+	if (os.Getenv("GIT_USERNAME") == "") != (gitPassword == "") {
+		return nil, errors.New("GIT_USERNAME and GIT_PASSWORD must be set together")
+	}
+	if gitSSHPrivateKey == "" && (os.Getenv("GIT_SSH_USER") != "" || gitSSHKeyPassphrase != "") {
+		return nil, errors.New("GIT_SSH_USER and GIT_SSH_KEY_PASSPHRASE require GIT_SSH_PRIVATE_KEY")
+	}
+
+	gitTrustedGPGKeys, err := getEnvOrFile("GIT_TRUSTED_GPG_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	gitAllowedSigners, err := getEnvOrFile("GIT_ALLOWED_SIGNERS")
+	if err != nil {
+		return nil, err
+	}
+
+	sopsAgeKey, err := getEnvOrFile("SOPS_AGE_KEY")
+	if err != nil {
+		return nil, err
+	}
+	sopsPGPKey, err := getEnvOrFile("SOPS_PGP_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	applications := []Application{{
+		Name:       "default",
+		RepoURL:    repoURL,
+		Revision:   repoBranch,
+		Path:       manifestPath,
+		SyncPolicy: SyncPolicy{Prune: prune, SelfHeal: selfHeal},
+	}}
+	if appsFile != "" {
+		applications, err = LoadApplications(appsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if controllerMode {
+		applications = nil
+	}
+
 	return &Config{
-		RepoURL:             repoURL,
-		RepoBranch:          repoBranch,
-		KubeconfigPath:      kubeconfigPath,
-		PollIntervalSeconds: pollIntervalSeconds,
-		ManifestPath:        manifestPath,
+		Applications: applications,
+		AppsFile:     appsFile,
+
+		ControllerMode: controllerMode,
+		WatchNamespace: os.Getenv("WATCH_NAMESPACE"),
+		ClusterKinds:   clusterKinds,
+
+		RepoURL:              repoURL,
+		RepoBranch:           repoBranch,
+		KubeconfigPath:       kubeconfigPath,
+		PollIntervalSeconds:  pollIntervalSeconds,
+		ManifestPath:         manifestPath,
+		Prune:                prune,
+		DiffOnly:             diffOnly,
+		HealthTimeoutSeconds: healthTimeoutSeconds,
+		HookTimeoutSeconds:   hookTimeoutSeconds,
+
+		DriftCheckIntervalSeconds: driftCheckIntervalSeconds,
+		SelfHeal:                  selfHeal,
+		FullSyncIntervalSeconds:   fullSyncIntervalSeconds,
+
+		SyncRetryMaxAttempts:       syncRetryMaxAttempts,
+		SyncRetryBackoffSeconds:    syncRetryBackoffSeconds,
+		SyncRetryMaxBackoffSeconds: syncRetryMaxBackoffSeconds,
+		FetchBackoffMaxSeconds:     fetchBackoffMaxSeconds,
+
+		HelmReleaseName: os.Getenv("HELM_RELEASE_NAME"),
+		HelmNamespace:   os.Getenv("HELM_NAMESPACE"),
+		HelmValuesFiles: helmValuesFiles,
+		HelmSet:         os.Getenv("HELM_SET"),
+
+		HTTPListenAddr: httpListenAddr,
+		WebhookSecret:  webhookSecret,
+		APIToken:       apiToken,
+
+		StateStore:       stateStore,
+		StateDir:         os.Getenv("STATE_DIR"),
+		StateNamespace:   stateNamespace,
+		SyncHistoryLimit: syncHistoryLimit,
+
+		CloneDir:        os.Getenv("CLONE_DIR"),
+		CloneDirCleanup: cloneDirCleanup,
+
+		GitCloneDepth:     gitCloneDepth,
+		GitSparseCheckout: gitSparseCheckout,
+		GitBareClone:      gitBareClone,
+
+		GitUsername:          os.Getenv("GIT_USERNAME"),
+		GitPassword:          gitPassword,
+		GitSSHUser:           os.Getenv("GIT_SSH_USER"),
+		GitSSHPrivateKey:     gitSSHPrivateKey,
+		GitSSHKeyPassphrase:  gitSSHKeyPassphrase,
+		GitSSHKnownHostsPath: os.Getenv("GIT_SSH_KNOWN_HOSTS_PATH"),
+
+		GitTrustedGPGKeys: gitTrustedGPGKeys,
+		GitAllowedSigners: gitAllowedSigners,
+
+		SopsAgeKey: sopsAgeKey,
+		SopsPGPKey: sopsPGPKey,
 	}, nil
 }
+
+// String implements fmt.Stringer with secrets masked, so the configuration can be logged.
+func (c Config) String() string {
+	type plainConfig Config // Same fields without the String method
+	redacted := plainConfig(c)
+	for _, secret := range []*string{&redacted.GitPassword, &redacted.GitSSHPrivateKey, &redacted.GitSSHKeyPassphrase, &redacted.WebhookSecret, &redacted.APIToken, &redacted.SopsAgeKey, &redacted.SopsPGPKey} {
+		if *secret != "" {
+			*secret = "<redacted>"
+		}
+	}
+	return fmt.Sprintf("%+v", redacted)
+}
+
+// getEnvInt parses the integer environment variable name, returning def when it
+// is unset. Values below min are rejected; min must be 0 or 1.
+func getEnvInt(name string, def, min int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < min {
+		if min > 0 {
+			return 0, errors.New(name + " must be a positive integer")
+		}
+		return 0, errors.New(name + " must be a non-negative integer")
+	}
+	return parsed, nil
+}
+
+// getEnvOrFile returns the value of the environment variable name or, if it is
+// unset, the contents of the file named by name+"_FILE" with trailing newlines
+// removed. This lets secrets be mounted from Kubernetes Secrets as files.
+func getEnvOrFile(name string) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE could not be read: %w", name, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// getEnvBool parses the boolean environment variable name, returning def when it is unset.
+func getEnvBool(name string, def bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New(name + " must be a valid boolean")
+	}
+	return parsed, nil
+}
@@ -0,0 +1,72 @@
+package gitpoller
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// AuthConfig holds the credentials used to access a private repository.
+// Either the HTTPS fields or the SSH fields may be set, not both.
+type AuthConfig struct {
+	Username string // HTTPS username; required together with Password
+	Password string // HTTPS password or personal access token
+
+	SSHUser           string // SSH user; defaults to "git"
+	SSHPrivateKey     []byte // PEM encoded private key
+	SSHKeyPassphrase  string // Optional passphrase for an encrypted private key
+	SSHKnownHostsPath string // known_hosts file; defaults to SSH_KNOWN_HOSTS or ~/.ssh/known_hosts
+}
+
+// NewAuthMethod builds the go-git transport.AuthMethod for cfg. It returns a nil
+// AuthMethod and no error when no credentials are configured, which go-git treats
+// as anonymous access. Incomplete credentials are an error rather than falling
+// back to anonymous access.
+func NewAuthMethod(cfg AuthConfig) (transport.AuthMethod, error) {
+	hasHTTPS := cfg.Username != "" || cfg.Password != ""
+	hasSSH := len(cfg.SSHPrivateKey) > 0
+	if hasHTTPS && hasSSH {
+		return nil, fmt.Errorf("both HTTPS and SSH credentials are configured, only one may be used")
+	}
+
+	if hasHTTPS {
+		if cfg.Username == "" || cfg.Password == "" {
+			return nil, fmt.Errorf("HTTPS authentication requires both a username and a password")
+		}
+		log.Printf("Using HTTPS basic authentication as user %s", cfg.Username)
+		return &http.BasicAuth{Username: cfg.Username, Password: cfg.Password}, nil
+	}
+
+	if cfg.SSHUser != "" || cfg.SSHKeyPassphrase != "" {
+		return nil, fmt.Errorf("an SSH user or key passphrase is configured without an SSH private key")
+	}
+
+	if hasSSH {
+		user := cfg.SSHUser
+		if user == "" {
+			user = "git"
+		}
+		publicKeys, err := ssh.NewPublicKeys(user, cfg.SSHPrivateKey, cfg.SSHKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH private key: %w", err)
+		}
+
+		var knownHostsFiles []string
+		if cfg.SSHKnownHostsPath != "" {
+			knownHostsFiles = append(knownHostsFiles, cfg.SSHKnownHostsPath)
+		}
+		hostKeyCallback, err := ssh.NewKnownHostsCallback(knownHostsFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SSH known_hosts: %w", err)
+		}
+		publicKeys.HostKeyCallback = hostKeyCallback
+
+		log.Printf("Using SSH public key authentication as user %s", user)
+		return publicKeys, nil
+	}
+
+	return nil, nil
+}
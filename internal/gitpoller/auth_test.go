@@ -0,0 +1,86 @@
+package gitpoller
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNewAuthMethod(t *testing.T) {
+	t.Helper()
+	t.Run("NoCredentials", func(t *testing.T) {
+		auth, err := NewAuthMethod(AuthConfig{})
+		if err != nil {
+			t.Fatalf("Expected no error without credentials, got %v", err)
+		}
+		if auth != nil {
+			t.Errorf("Expected nil auth without credentials, got %v", auth)
+		}
+	})
+
+	t.Run("HTTPSToken", func(t *testing.T) {
+		auth, err := NewAuthMethod(AuthConfig{Username: "deploy", Password: "token"})
+		if err != nil {
+			t.Fatalf("Expected no error for HTTPS token, got %v", err)
+		}
+		basic, ok := auth.(*http.BasicAuth)
+		if !ok {
+			t.Fatalf("Expected *http.BasicAuth, got %T", auth)
+		}
+		if basic.Username != "deploy" || basic.Password != "token" {
+			t.Errorf("Unexpected basic auth credentials: %s", basic)
+		}
+	})
+
+	t.Run("SSHKey", func(t *testing.T) {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		block, err := ssh.MarshalPrivateKey(privateKey, "test")
+		if err != nil {
+			t.Fatalf("Failed to marshal key: %v", err)
+		}
+		knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+		if err := os.WriteFile(knownHosts, nil, 0644); err != nil {
+			t.Fatalf("Failed to write known_hosts: %v", err)
+		}
+
+		auth, err := NewAuthMethod(AuthConfig{SSHPrivateKey: pem.EncodeToMemory(block), SSHKnownHostsPath: knownHosts})
+		if err != nil {
+			t.Fatalf("Expected no error for SSH key, got %v", err)
+		}
+		publicKeys, ok := auth.(*gitssh.PublicKeys)
+		if !ok {
+			t.Fatalf("Expected *ssh.PublicKeys, got %T", auth)
+		}
+		if publicKeys.User != "git" || publicKeys.HostKeyCallback == nil {
+			t.Errorf("Expected user 'git' with a host key callback, got user '%s'", publicKeys.User)
+		}
+	})
+
+	t.Run("InvalidInputs", func(t *testing.T) {
+		if _, err := NewAuthMethod(AuthConfig{Password: "token", SSHPrivateKey: []byte("key")}); err == nil {
+			t.Error("Expected error when both HTTPS and SSH credentials are set, got nil")
+		}
+		if _, err := NewAuthMethod(AuthConfig{SSHPrivateKey: []byte("not a key")}); err == nil {
+			t.Error("Expected error for an unparsable SSH key, got nil")
+		}
+		if _, err := NewAuthMethod(AuthConfig{Password: "token"}); err == nil {
+			t.Error("Expected error for a password without a username, got nil")
+		}
+		if _, err := NewAuthMethod(AuthConfig{Username: "deploy"}); err == nil {
+			t.Error("Expected error for a username without a password, got nil")
+		}
+		if _, err := NewAuthMethod(AuthConfig{SSHUser: "deploy"}); err == nil {
+			t.Error("Expected error for an SSH user without a private key, got nil")
+		}
+	})
+}
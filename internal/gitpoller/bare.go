@@ -0,0 +1,114 @@
+package gitpoller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/api/konfig"
+)
+
+// WithBareClone keeps a bare clone, if enabled: no working tree is ever
+// written, and polls and checkouts only move HEAD. The manifests of a commit
+// are read from its Git objects with ReadManifestFiles, so kustomizations and
+// charts must not refer to files outside their directory. It takes precedence
+// over WithSparseCheckout.
+func WithBareClone(enabled bool) Option {
+	return func(gp *GitPoller) {
+		gp.bare = enabled
+	}
+}
+
+// usesWorktree reports whether checkouts go through the go-git worktree. Bare
+// and sparse clones move HEAD themselves; see checkoutObjects.
+func (gp *GitPoller) usesWorktree() bool {
+	return !gp.bare && gp.sparse == nil
+}
+
+// gitDir returns the path whose presence shows that the local clone exists.
+func (gp *GitPoller) gitDir() string {
+	if gp.bare {
+		return filepath.Join(gp.localPath, "HEAD")
+	}
+	return filepath.Join(gp.localPath, ".git")
+}
+
+// ReadManifestFiles lists the manifest files of the commit commitHash the way
+// GetManifestFiles lists those of the working tree, but reads them from the Git
+// objects of the commit, and returns their contents keyed by path. For a
+// kustomization or Helm chart, the contents of every file of the manifest
+// directory are returned, since rendering may read any of them. The paths are
+// those the files would have in the working tree. Symbolic links are skipped.
+func (gp *GitPoller) ReadManifestFiles(commitHash string) ([]string, map[string][]byte, error) {
+	if gp.repository == nil {
+		return nil, nil, fmt.Errorf("repository not initialized")
+	}
+	return gp.readManifestTree(commitHash, true)
+}
+
+// readManifestTree lists the manifest files of the commit commitHash, and reads
+// their contents if withContents is set; see ReadManifestFiles.
+func (gp *GitPoller) readManifestTree(commitHash string, withContents bool) ([]string, map[string][]byte, error) {
+	tree, err := gp.commitTree(commitHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := path.Clean(filepath.ToSlash(gp.manifestPathInRepo))
+	if dir != "." {
+		subtree, err := tree.Tree(dir)
+		if errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil, nil, fmt.Errorf("manifest directory '%s' not found in commit %s", gp.manifestPathInRepo, commitHash)
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to read manifest directory '%s' of commit %s: %w", gp.manifestPathInRepo, commitHash, err)
+		}
+		tree = subtree
+	}
+
+	var rendered string // Kustomization file or Chart.yaml, which decides what is rendered
+	for _, name := range append(konfig.RecognizedKustomizationFileNames(), chartutil.ChartfileName) {
+		if entry, err := tree.FindEntry(name); err == nil && entry.Mode != filemode.Dir && entry.Mode != filemode.Submodule {
+			rendered = gp.clonePath(path.Join(dir, name))
+			log.Printf("Found %s in commit %s", rendered, commitHash)
+			break
+		}
+	}
+
+	var files []string
+	contents := make(map[string][]byte)
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if rendered == "" && !isManifestFile(file.Name) {
+			return nil
+		}
+		filePath := gp.clonePath(path.Join(dir, file.Name))
+		if file.Mode == filemode.Symlink {
+			log.Printf("Skipping symbolic link %s, which is not followed in a bare clone", filePath)
+			return nil
+		}
+		if rendered == "" {
+			files = append(files, filePath)
+		}
+		if !withContents {
+			return nil
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		contents[filePath] = []byte(content)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read manifest directory '%s' of commit %s: %w", gp.manifestPathInRepo, commitHash, err)
+	}
+	if rendered != "" {
+		files = []string{rendered}
+	} else if len(files) == 0 {
+		log.Printf("No manifest files (.yaml/.yml/.json) found in '%s' of commit %s", gp.manifestPathInRepo, commitHash)
+	}
+	return files, contents, nil
+}
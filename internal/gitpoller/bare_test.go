@@ -0,0 +1,75 @@
+package gitpoller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPoll_BareClone(t *testing.T) {
+	t.Helper()
+	remote, remoteDir, hashes := initRemote(t, 1)
+	head, err := remote.Head()
+	if err != nil {
+		t.Fatalf("Failed to get HEAD: %v", err)
+	}
+
+	localDir := filepath.Join(t.TempDir(), "clone")
+	poller, err := NewGitPoller(remoteDir, head.Name().Short(), localDir, "manifests", WithBareClone(true))
+	if err != nil {
+		t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+	}
+	if err := poller.InitializeRepo(); err != nil {
+		t.Fatalf("InitializeRepo() returned an unexpected error: %v", err)
+	}
+	manifest := filepath.Join(localDir, "manifests", "app.yaml")
+	_, commitHash, files, err := poller.Poll()
+	if err != nil || commitHash != hashes[0] || len(files) != 1 || files[0] != manifest {
+		t.Fatalf("expected the first poll to find %s with %s, got %s, %v (%v)", hashes[0], manifest, commitHash, files, err)
+	}
+
+	next := commitFile(t, remote, remoteDir, "manifests/app.yaml", "replicas: 5\n", "Scale up")
+	if changed, commitHash, _, err := poller.Poll(); err != nil || !changed || commitHash != next {
+		t.Fatalf("expected the new commit %s, got changed %t, commit %s (%v)", next, changed, commitHash, err)
+	}
+	if changes := poller.Changes(); changes == nil || len(changes.Modified) != 1 || changes.Modified[0] != manifest {
+		t.Errorf("expected %s to be modified, got %+v", manifest, changes)
+	}
+	files, contents, err := poller.ReadManifestFiles(next)
+	if err != nil || len(files) != 1 || string(contents[manifest]) != "replicas: 5\n" {
+		t.Fatalf("expected the manifest of the new commit, got %v, %q (%v)", files, contents[manifest], err)
+	}
+	if _, contents, err := poller.ReadManifestFiles(hashes[0]); err != nil || string(contents[manifest]) != "replicas: 1\n" {
+		t.Errorf("expected the manifest of the first commit, got %q (%v)", contents[manifest], err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "manifests")); !os.IsNotExist(err) {
+		t.Errorf("expected no working tree to be written, got %v", err)
+	}
+
+	if _, err := poller.Checkout(hashes[0]); err != nil {
+		t.Fatalf("Checkout() returned an unexpected error: %v", err)
+	}
+	if current, err := poller.GetCurrentCommitHash(); err != nil || current != hashes[0] {
+		t.Errorf("expected HEAD at %s, got %s (%v)", hashes[0], current, err)
+	}
+
+	rendered := commitFile(t, remote, remoteDir, "manifests/kustomization.yaml", "resources:\n- app.yaml\n", "Use kustomize")
+	_, _, files, err = poller.Poll()
+	if err != nil || len(files) != 1 || files[0] != filepath.Join(localDir, "manifests", "kustomization.yaml") {
+		t.Fatalf("expected only the kustomization to be listed, got %v (%v)", files, err)
+	}
+	if _, contents, err = poller.ReadManifestFiles(rendered); err != nil {
+		t.Fatalf("ReadManifestFiles() returned an unexpected error: %v", err)
+	}
+	if string(contents[manifest]) != "replicas: 5\n" {
+		t.Errorf("expected the other files of the kustomization to be read, got %v", contents)
+	}
+
+	reopened, err := NewGitPoller(remoteDir, head.Name().Short(), localDir, "manifests", WithBareClone(true))
+	if err != nil {
+		t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+	}
+	if err := reopened.InitializeRepo(); err != nil {
+		t.Fatalf("expected the bare clone to be opened again, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package gitpoller
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// ManifestChanges lists the files under the manifest directory that differ
+// between two commits, as paths within the local clone like the ones returned
+// by GetManifestFiles. A renamed file is reported as deleted and added.
+type ManifestChanges struct {
+	From     string // Commit the changes are relative to
+	To       string
+	Added    []string
+	Modified []string
+	Deleted  []string
+	// Rendered is set if the manifest directory is a kustomization or Helm
+	// chart; then every changed file is listed, not only manifest files.
+	Rendered bool
+}
+
+// Empty reports whether no file under the manifest directory changed.
+func (c *ManifestChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Modified) == 0 && len(c.Deleted) == 0
+}
+
+// Changes returns the manifest changes of the new commit found by the most
+// recent Poll, or nil if it found none, it was the first poll, or the changes
+// could not be determined.
+func (gp *GitPoller) Changes() *ManifestChanges {
+	return gp.changes
+}
+
+// diffManifests compares the trees of the commits from and to and returns the
+// changes under the manifest directory. Only manifest files, see
+// isManifestFile, are reported, unless rendered is set: then every file may
+// affect the rendering of the kustomization or Helm chart and is reported.
+func (gp *GitPoller) diffManifests(from, to string, rendered bool) (*ManifestChanges, error) {
+	fromTree, err := gp.commitTree(from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := gp.commitTree(to)
+	if err != nil {
+		return nil, err
+	}
+	treeChanges, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff commits %s and %s: %w", from, to, err)
+	}
+
+	changes := &ManifestChanges{From: from, To: to, Rendered: rendered}
+	for _, change := range treeChanges {
+		action, err := change.Action()
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff commits %s and %s: %w", from, to, err)
+		}
+		switch action {
+		case merkletrie.Insert:
+			if name := change.To.Name; gp.isManifestChange(name, rendered) {
+				changes.Added = append(changes.Added, gp.clonePath(name))
+			}
+		case merkletrie.Delete:
+			if name := change.From.Name; gp.isManifestChange(name, rendered) {
+				changes.Deleted = append(changes.Deleted, gp.clonePath(name))
+			}
+		case merkletrie.Modify:
+			if name := change.To.Name; gp.isManifestChange(name, rendered) {
+				changes.Modified = append(changes.Modified, gp.clonePath(name))
+			}
+		}
+	}
+	return changes, nil
+}
+
+// isManifestChange reports whether name, a slash-separated path within the
+// repository, lies under the manifest directory and is relevant to it.
+func (gp *GitPoller) isManifestChange(name string, rendered bool) bool {
+	dir := path.Clean(filepath.ToSlash(gp.manifestPathInRepo))
+	if dir != "." && name != dir && !strings.HasPrefix(name, dir+"/") {
+		return false
+	}
+	return rendered || isManifestFile(name)
+}
+
+// clonePath returns the path within the local clone of name, a slash-separated
+// path within the repository.
+func (gp *GitPoller) clonePath(name string) string {
+	return filepath.Join(gp.localPath, filepath.FromSlash(name))
+}
+
+// commitTree returns the root tree of the commit hash, deepening a shallow
+// clone if the commit was not fetched yet.
+func (gp *GitPoller) commitTree(hash string) (*object.Tree, error) {
+	if err := gp.deepen(plumbing.NewHash(hash)); err != nil {
+		return nil, err
+	}
+	commit, err := gp.repository.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree of commit %s: %w", hash, err)
+	}
+	return tree, nil
+}
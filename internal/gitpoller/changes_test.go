@@ -0,0 +1,165 @@
+package gitpoller
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestPoll_Changes(t *testing.T) {
+	t.Helper()
+	remoteDir := t.TempDir()
+	remote, err := git.PlainInit(remoteDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	for _, dir := range []string{"manifests", "docs"} {
+		if err := os.Mkdir(filepath.Join(remoteDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	commitFile(t, remote, remoteDir, "manifests/a.yaml", "kind: A\n", "Add a")
+	commitFile(t, remote, remoteDir, "manifests/b.yaml", "kind: B\n", "Add b")
+	first := commitFile(t, remote, remoteDir, "docs/readme.md", "docs\n", "Add docs")
+
+	localDir := filepath.Join(t.TempDir(), "clone")
+	poller, err := NewGitPoller(remoteDir, "master", localDir, "manifests")
+	if err != nil {
+		t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+	}
+	if err := poller.InitializeRepo(); err != nil {
+		t.Fatalf("InitializeRepo() returned an unexpected error: %v", err)
+	}
+	if _, _, _, err := poller.Poll(); err != nil {
+		t.Fatalf("Poll() returned an unexpected error: %v", err)
+	}
+	if poller.Changes() != nil {
+		t.Errorf("expected no changes on the first poll, got %+v", poller.Changes())
+	}
+
+	commitFile(t, remote, remoteDir, "manifests/a.yaml", "kind: A2\n", "Modify a")
+	commitFile(t, remote, remoteDir, "manifests/c.yml", "kind: C\n", "Add c")
+	commitFile(t, remote, remoteDir, "manifests/notes.txt", "notes\n", "Add notes")
+	w, err := remote.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get worktree: %v", err)
+	}
+	if _, err := w.Remove("manifests/b.yaml"); err != nil {
+		t.Fatalf("Failed to remove b.yaml: %v", err)
+	}
+	commitFile(t, remote, remoteDir, "docs/readme.md", "more docs\n", "Remove b")
+	changed, commitHash, _, err := poller.Poll()
+	if err != nil || !changed {
+		t.Fatalf("expected a new commit, got changed %t, error %v", changed, err)
+	}
+	expected := &ManifestChanges{
+		From:     first,
+		To:       commitHash,
+		Added:    []string{filepath.Join(localDir, "manifests", "c.yml")},
+		Modified: []string{filepath.Join(localDir, "manifests", "a.yaml")},
+		Deleted:  []string{filepath.Join(localDir, "manifests", "b.yaml")},
+	}
+	if !reflect.DeepEqual(poller.Changes(), expected) {
+		t.Errorf("expected changes %+v, got %+v", expected, poller.Changes())
+	}
+
+	commitFile(t, remote, remoteDir, "docs/readme.md", "even more docs\n", "Update docs")
+	if changed, _, _, err := poller.Poll(); err != nil || !changed {
+		t.Fatalf("expected a new commit, got changed %t, error %v", changed, err)
+	}
+	if changes := poller.Changes(); changes == nil || !changes.Empty() {
+		t.Errorf("expected empty changes for a commit outside the manifest directory, got %+v", changes)
+	}
+
+	if changed, _, _, err := poller.Poll(); err != nil || changed || poller.Changes() != nil {
+		t.Errorf("expected no changes without a new commit, got changed %t, changes %+v, error %v", changed, poller.Changes(), err)
+	}
+}
+
+func TestPoll_ManifestDirMissing(t *testing.T) {
+	t.Helper()
+	remote, remoteDir, hashes := initRemote(t, 1)
+	poller, err := NewGitPoller(remoteDir, "master", filepath.Join(t.TempDir(), "clone"), "manifests")
+	if err != nil {
+		t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+	}
+	if err := poller.InitializeRepo(); err != nil {
+		t.Fatalf("InitializeRepo() returned an unexpected error: %v", err)
+	}
+	if _, _, _, err := poller.Poll(); err != nil {
+		t.Fatalf("Poll() returned an unexpected error: %v", err)
+	}
+
+	w, err := remote.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get worktree: %v", err)
+	}
+	if _, err := w.Remove("manifests/app.yaml"); err != nil {
+		t.Fatalf("Failed to remove the manifest: %v", err)
+	}
+	if _, err := w.Commit("Remove manifests", &git.CommitOptions{Author: &object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: time.Now()}}); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if changed, _, _, err := poller.Poll(); err == nil || !changed {
+			t.Fatalf("poll %d: expected the commit without manifest directory to fail as new, got changed %t (%v)", i+1, changed, err)
+		}
+	}
+	if poller.LastCommitHash() != hashes[0] {
+		t.Errorf("expected the last polled commit to stay at %s, got %s", hashes[0], poller.LastCommitHash())
+	}
+
+	if err := os.MkdirAll(filepath.Join(remoteDir, "manifests"), 0755); err != nil {
+		t.Fatalf("Failed to create manifest directory: %v", err)
+	}
+	restored := commitFile(t, remote, remoteDir, "manifests/app.yaml", "replicas: 2\n", "Restore manifests")
+	changed, commitHash, files, err := poller.Poll()
+	if err != nil || !changed || commitHash != restored || len(files) != 1 {
+		t.Fatalf("expected the restored commit %s with one manifest, got changed %t, commit %s, files %v (%v)", restored, changed, commitHash, files, err)
+	}
+	if changes := poller.Changes(); changes == nil || changes.From != hashes[0] || len(changes.Modified) != 1 {
+		t.Errorf("expected the manifest to be modified since %s, got %+v", hashes[0], changes)
+	}
+}
+
+func TestPoll_SetLastCommitHash(t *testing.T) {
+	t.Helper()
+	remote, remoteDir, hashes := initRemote(t, 2)
+	localDir := filepath.Join(t.TempDir(), "clone")
+	restarted := func(lastCommitHash string) *GitPoller {
+		poller, err := NewGitPoller(remoteDir, "master", localDir, "manifests")
+		if err != nil {
+			t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+		}
+		if err := poller.InitializeRepo(); err != nil {
+			t.Fatalf("InitializeRepo() returned an unexpected error: %v", err)
+		}
+		poller.SetLastCommitHash(lastCommitHash)
+		return poller
+	}
+
+	poller := restarted(hashes[1])
+	if changed, commitHash, files, err := poller.Poll(); err != nil || changed || commitHash != hashes[1] || files != nil {
+		t.Fatalf("expected the head synced before the restart not to be new, got changed %t, commit %s, files %v (%v)", changed, commitHash, files, err)
+	}
+
+	poller = restarted(hashes[0])
+	changed, commitHash, _, err := poller.Poll()
+	if err != nil || !changed || commitHash != hashes[1] {
+		t.Fatalf("expected %s to be new since %s, got changed %t, commit %s (%v)", hashes[1], hashes[0], changed, commitHash, err)
+	}
+	if changes := poller.Changes(); changes == nil || changes.From != hashes[0] || len(changes.Modified) != 1 {
+		t.Errorf("expected the manifest to be modified since %s, got %+v", hashes[0], changes)
+	}
+
+	next := commitFile(t, remote, remoteDir, "manifests/app.yaml", "replicas: 3\n", "Scale up")
+	poller = restarted(hashes[1])
+	if changed, commitHash, _, err := poller.Poll(); err != nil || !changed || commitHash != next {
+		t.Errorf("expected the commit pushed meanwhile, %s, to be new, got changed %t, commit %s (%v)", next, changed, commitHash, err)
+	}
+}
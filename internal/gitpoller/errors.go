@@ -0,0 +1,108 @@
+package gitpoller
+
+import (
+	"compress/zlib"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+)
+
+// ErrorClass is the cause of a failed Poll, as far as it can be told from the
+// error.
+type ErrorClass string
+
+const (
+	ErrorAuth        ErrorClass = "auth"          // The credentials or host key were rejected
+	ErrorNetwork     ErrorClass = "network"       // The remote could not be reached or failed to respond
+	ErrorRefNotFound ErrorClass = "ref-not-found" // The repository, branch, tag or commit does not exist
+	ErrorCorrupted   ErrorClass = "corrupted"     // The local clone is damaged and must be cloned again
+	ErrorOther       ErrorClass = "other"
+)
+
+// errRevisionNotFound is wrapped by errors for revisions that designate no
+// commit of the remote.
+var errRevisionNotFound = errors.New("revision not found")
+
+// corruptionErrors are the errors of go-git that mean the local clone is
+// damaged: unreadable repository, pack, index or reference files. A missing
+// object or remote is not among them: those also follow from commits that were
+// never fetched and from the configuration, which a new clone does not fix.
+var corruptionErrors = []error{
+	git.ErrRepositoryNotExists,
+	git.ErrRepositoryIncomplete,
+	dotgit.ErrPackfileNotFound,
+	dotgit.ErrIdxNotFound,
+	dotgit.ErrPackedRefsBadFormat,
+	dotgit.ErrPackedRefsDuplicatedRef,
+	idxfile.ErrMalformedIdxFile,
+	index.ErrMalformedSignature,
+	index.ErrInvalidChecksum,
+	objfile.ErrHeader,
+	objfile.ErrNegativeSize,
+	zlib.ErrChecksum,
+	zlib.ErrHeader,
+}
+
+// ClassifyError returns the class of err, an error returned by Poll or
+// FetchLatest. Only ErrorCorrupted calls for wiping the local clone; auth and
+// ref-not-found errors persist until the configuration or the remote changes.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+	var unexpected *plumbing.UnexpectedError
+	if errors.As(err, &unexpected) {
+		// go-git does not unwrap these, e.g. around HTTP 5xx responses
+		return classifyUnexpected(unexpected.Err)
+	}
+
+	// The SSH client formats the errors of its handshake into plain text
+	message := err.Error()
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired),
+		errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod),
+		strings.Contains(message, "ssh: unable to authenticate"),
+		strings.Contains(message, "knownhosts: key"):
+		return ErrorAuth
+	case errors.Is(err, transport.ErrRepositoryNotFound),
+		errors.Is(err, transport.ErrEmptyRemoteRepository),
+		errors.Is(err, git.NoMatchingRefSpecError{}),
+		errors.Is(err, plumbing.ErrReferenceNotFound),
+		errors.Is(err, errRevisionNotFound):
+		return ErrorRefNotFound
+	}
+	var packErr *packfile.Error
+	if errors.As(err, &packErr) {
+		return ErrorCorrupted
+	}
+	for _, corruption := range corruptionErrors {
+		if errors.Is(err, corruption) {
+			return ErrorCorrupted
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, transport.ErrEmptyUploadPackRequest) {
+		return ErrorNetwork
+	}
+	return ErrorOther
+}
+
+// classifyUnexpected classifies the error wrapped by a plumbing.UnexpectedError,
+// which comes from the transport: what is not otherwise known is a failure of
+// the remote.
+func classifyUnexpected(err error) ErrorClass {
+	if class := ClassifyError(err); class != ErrorOther {
+		return class
+	}
+	return ErrorNetwork
+}
@@ -0,0 +1,106 @@
+package gitpoller
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Helper()
+	wrap := func(err error) error {
+		return fmt.Errorf("failed during fetch: %w", err)
+	}
+	cases := map[string]struct {
+		err      error
+		expected ErrorClass
+	}{
+		"nil":                {nil, ""},
+		"auth required":      {wrap(transport.ErrAuthenticationRequired), ErrorAuth},
+		"auth failed":        {wrap(transport.ErrAuthorizationFailed), ErrorAuth},
+		"ssh auth":           {errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey]"), ErrorAuth},
+		"host key":           {errors.New("ssh: handshake failed: knownhosts: key mismatch"), ErrorAuth},
+		"repository missing": {wrap(transport.ErrRepositoryNotFound), ErrorRefNotFound},
+		"branch missing":     {wrap(git.NoMatchingRefSpecError{}), ErrorRefNotFound},
+		"reference missing":  {wrap(plumbing.ErrReferenceNotFound), ErrorRefNotFound},
+		"no matching tag":    {wrap(fmt.Errorf("no tag matches semver constraint ~1.4: %w", errRevisionNotFound)), ErrorRefNotFound},
+		"network":            {wrap(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}), ErrorNetwork},
+		"server error":       {wrap(plumbing.NewUnexpectedError(errors.New("unexpected requesting status code: 502"))), ErrorNetwork},
+		"unexpected auth":    {wrap(plumbing.NewUnexpectedError(transport.ErrAuthorizationFailed)), ErrorAuth},
+		"bad packfile":       {wrap(packfile.ErrInvalidObject.AddDetails("checksum")), ErrorCorrupted},
+		"bad index":          {wrap(index.ErrInvalidChecksum), ErrorCorrupted},
+		"bad packed refs":    {wrap(dotgit.ErrPackedRefsBadFormat), ErrorCorrupted},
+		"missing object":     {wrap(plumbing.ErrObjectNotFound), ErrorOther},
+		"unreadable commit":  {fmt.Errorf("refusing to deploy commit abc: failed to read commit abc: %w", plumbing.ErrObjectNotFound), ErrorOther},
+		"not a commit":       {wrap(plumbing.ErrInvalidType), ErrorOther},
+		"missing remote":     {wrap(git.ErrRemoteNotFound), ErrorOther},
+		"other":              {errors.New("manifest directory 'deploy' not found"), ErrorOther},
+	}
+	for name, tc := range cases {
+		if class := ClassifyError(tc.err); class != tc.expected {
+			t.Errorf("%s: ClassifyError(%v) = %q, expected %q", name, tc.err, class, tc.expected)
+		}
+	}
+}
+
+func TestReclone(t *testing.T) {
+	t.Helper()
+	remoteDir := t.TempDir()
+	remote, err := git.PlainInit(remoteDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(remoteDir, "manifests"), 0755); err != nil {
+		t.Fatalf("Failed to create manifest directory: %v", err)
+	}
+	commitFile(t, remote, remoteDir, "manifests/app.yaml", "replicas: 1\n", "Initial commit")
+	head, err := remote.Head()
+	if err != nil {
+		t.Fatalf("Failed to get HEAD: %v", err)
+	}
+
+	localDir := filepath.Join(t.TempDir(), "clone")
+	poller, err := NewGitPoller(remoteDir, head.Name().Short(), localDir, "manifests")
+	if err != nil {
+		t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+	}
+	if err := poller.InitializeRepo(); err != nil {
+		t.Fatalf("InitializeRepo() returned an unexpected error: %v", err)
+	}
+	share := poller.Share("manifests")
+	if _, _, _, err := share.Poll(); err != nil {
+		t.Fatalf("Poll() returned an unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(localDir, ".git", "index"), []byte("garbage"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt the clone: %v", err)
+	}
+	commitFile(t, remote, remoteDir, "manifests/app.yaml", "replicas: 2\n", "Scale up")
+	_, _, _, err = share.Poll()
+	if class := ClassifyError(err); class != ErrorCorrupted {
+		t.Fatalf("expected a poll of the corrupted clone to fail as corrupted, got %q (%v)", class, err)
+	}
+
+	if err := poller.Reclone(); err != nil {
+		t.Fatalf("Reclone() returned an unexpected error: %v", err)
+	}
+	share.UseCloneOf(poller)
+	changed, _, _, err := share.Poll()
+	if err != nil || !changed {
+		t.Fatalf("expected the new commit to be found in the new clone, got changed %t, error %v", changed, err)
+	}
+	content, err := os.ReadFile(filepath.Join(localDir, "manifests", "app.yaml"))
+	if err != nil || string(content) != "replicas: 2\n" {
+		t.Errorf("expected the worktree at the new commit, got %q (%v)", content, err)
+	}
+}
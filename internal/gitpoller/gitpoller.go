@@ -5,55 +5,158 @@ import (
 	"log"
 	"os"
 	"path/filepath" // For joining paths
+	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	gogitconfig "github.com/go-git/go-git/v5/config" // Renamed import
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	// "github.com/go-git/go-git/v5/plumbing/transport" // For auth if needed later
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/api/konfig"
+
+	"github.com/user/go-argo-lite/internal/metrics"
 )
 
 // GitPoller manages cloning and polling a git repository
 type GitPoller struct {
 	repoURL            string
-	repoBranch         string
+	revision           string       // Branch, tag, full commit SHA or semver constraint on tags
+	kind               RevisionKind // How revision is interpreted; determined by InitializeRepo
+	tag                string       // Tag fetched last, for tag and semver revisions
 	localPath          string
 	manifestPathInRepo string // e.g., "manifests" or "k8s"
 	lastCommitHash     string
+	changes            *ManifestChanges // Changes of the new commit found by the last Poll
 	repository         *git.Repository
-	// auth           transport.AuthMethod // Optional: for private repositories
+	auth               transport.AuthMethod // Optional: for private repositories
+	verifier           *SignatureVerifier   // Optional: commits must be signed by a trusted key
+	depth              int                  // Optional: commits fetched per revision head; 0 fetches the full history
+	sparse             *sparseCheckout      // Optional: directories written to the working tree; nil writes all
+	bare               bool                 // Optional: the clone has no working tree; see WithBareClone
+}
+
+// Option configures optional GitPoller behaviour.
+type Option func(*GitPoller)
+
+// WithAuth sets the credentials used to clone and fetch the repository.
+// A nil auth means anonymous access.
+func WithAuth(auth transport.AuthMethod) Option {
+	return func(gp *GitPoller) {
+		gp.auth = auth
+	}
 }
 
-// NewGitPoller creates a new GitPoller instance
-func NewGitPoller(repoURL, repoBranch, localPath, manifestPathInRepo string) (*GitPoller, error) {
-	if repoURL == "" || repoBranch == "" || localPath == "" {
-		return nil, fmt.Errorf("repoURL, repoBranch, and localPath must be provided")
+// NewGitPoller creates a new GitPoller instance. The revision may name a branch,
+// a tag, a full commit SHA or a semver constraint such as "~1.4" that selects
+// the highest matching tag; see RevisionKind.
+func NewGitPoller(repoURL, revision, localPath, manifestPathInRepo string, opts ...Option) (*GitPoller, error) {
+	if repoURL == "" || revision == "" || localPath == "" {
+		return nil, fmt.Errorf("repoURL, revision, and localPath must be provided")
 	}
 	if manifestPathInRepo == "" {
 		// Or allow it to be empty and GetManifestFiles would return empty/error
 		return nil, fmt.Errorf("manifestPathInRepo must be provided")
 	}
-	return &GitPoller{
+	gp := &GitPoller{
 		repoURL:            repoURL,
-		repoBranch:         repoBranch,
+		revision:           revision,
 		localPath:          localPath,
 		manifestPathInRepo: manifestPathInRepo,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(gp)
+	}
+	return gp, nil
 }
 
-// InitializeRepo clones the repository if it doesn't exist, or opens it if it does.
-// It also performs an initial checkout of the specified branch.
+// Share returns a GitPoller that uses the local clone of gp but lists the
+// manifests under manifestPathInRepo and tracks the last polled commit on its
+// own, so several applications can deploy from one clone. It must be called
+// after InitializeRepo. In a sparse clone, the manifest directory of the share
+// is written from the next Poll on. GitPollers sharing a clone are not safe for
+// concurrent use, Share included; callers must serialize polling and reading
+// of manifest files.
+func (gp *GitPoller) Share(manifestPathInRepo string) *GitPoller {
+	share := &GitPoller{
+		repoURL:            gp.repoURL,
+		revision:           gp.revision,
+		kind:               gp.kind,
+		localPath:          gp.localPath,
+		manifestPathInRepo: manifestPathInRepo,
+		repository:         gp.repository,
+		auth:               gp.auth,
+		verifier:           gp.verifier,
+		depth:              gp.depth,
+		sparse:             gp.sparse,
+		bare:               gp.bare,
+	}
+	if share.sparse != nil {
+		share.sparse.add(manifestPathInRepo)
+	}
+	return share
+}
+
+// UseCloneOf makes gp, a share of source, use the clone of source again after
+// Reclone replaced it. The last polled commit is kept, so the next Poll only
+// reports a new commit if the revision moved meanwhile.
+func (gp *GitPoller) UseCloneOf(source *GitPoller) {
+	gp.kind = source.kind
+	gp.repository = source.repository
+}
+
+// Reclone deletes the local clone and initializes the repository again, for a
+// clone that ClassifyError found corrupted. Shares of gp keep the deleted clone
+// until UseCloneOf is called on them.
+func (gp *GitPoller) Reclone() error {
+	log.Printf("Deleting the local clone of %s at %s to clone it again\n", gp.repoURL, gp.localPath)
+	metrics.ObserveReclone(gp.repoURL, gp.revision)
+	gp.repository = nil
+	if gp.sparse != nil {
+		gp.sparse.commit = plumbing.ZeroHash // Nothing is checked out anymore
+	}
+	if err := os.RemoveAll(gp.localPath); err != nil {
+		return fmt.Errorf("failed to delete local clone %s: %w", gp.localPath, err)
+	}
+	return gp.InitializeRepo()
+}
+
+// InitializeRepo determines how the revision is interpreted, then clones the
+// repository if it doesn't exist, or opens it if it does. For a branch it also
+// performs an initial checkout of the branch; other revisions are checked out
+// by the first Poll.
 func (gp *GitPoller) InitializeRepo() error {
+	if err := gp.resolveRevisionKind(); err != nil {
+		return err
+	}
+	log.Printf("Revision %s of %s is a %s\n", gp.revision, gp.repoURL, gp.kind)
+
 	// Check if the localPath exists and is a git repository
-	_, err := os.Stat(filepath.Join(gp.localPath, ".git"))
-	if os.IsNotExist(err) {
+	_, err := os.Stat(gp.gitDir())
+	if os.IsNotExist(err) && gp.kind != RevisionBranch {
+		// Only the objects of the revision are fetched later, so start empty
+		log.Printf("Initializing repository for %s in %s\n", gp.repoURL, gp.localPath)
+		r, err := git.PlainInit(gp.localPath, gp.bare)
+		if err != nil {
+			return fmt.Errorf("failed to initialize repository: %w", err)
+		}
+		if _, err := r.CreateRemote(&gogitconfig.RemoteConfig{Name: "origin", URLs: []string{gp.repoURL}}); err != nil {
+			return fmt.Errorf("failed to add remote origin: %w", err)
+		}
+		gp.repository = r
+		return nil
+	} else if os.IsNotExist(err) {
 		// Path does not exist, clone the repository
 		log.Printf("Cloning repository %s into %s\n", gp.repoURL, gp.localPath)
-		r, err := git.PlainClone(gp.localPath, false, &git.CloneOptions{
+		r, err := git.PlainClone(gp.localPath, gp.bare, &git.CloneOptions{
 			URL:           gp.repoURL,
-			ReferenceName: plumbing.NewBranchReferenceName(gp.repoBranch),
+			Auth:          gp.auth,
+			ReferenceName: plumbing.NewBranchReferenceName(gp.revision),
 			SingleBranch:  true,
-			Progress:      os.Stdout, // Optional: for clone progress
+			Depth:         gp.depth,
+			NoCheckout:    !gp.usesWorktree(), // See checkoutObjects
+			Progress:      os.Stdout,          // Optional: for clone progress
 		})
 		if err != nil {
 			return fmt.Errorf("failed to clone repository: %w", err)
@@ -69,6 +172,9 @@ func (gp *GitPoller) InitializeRepo() error {
 		}
 		gp.repository = r
 		log.Println("Repository opened successfully.")
+		if gp.kind != RevisionBranch {
+			return nil
+		}
 		return gp.checkoutBranch()
 	} else {
 		return fmt.Errorf("error checking repository path %s: %w", gp.localPath, err)
@@ -82,18 +188,29 @@ func (gp *GitPoller) checkoutBranch() error {
 		return fmt.Errorf("repository not initialized")
 	}
 
+	branchRefName := plumbing.NewBranchReferenceName(gp.revision)
+	if !gp.usesWorktree() {
+		ref, err := gp.repository.Reference(branchRefName, true)
+		if err == plumbing.ErrReferenceNotFound {
+			ref, err = gp.repository.Reference(plumbing.NewRemoteReferenceName("origin", gp.revision), true)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get reference for branch %s: %w", gp.revision, err)
+		}
+		return gp.checkoutObjects(ref.Hash(), branchRefName)
+	}
+
 	w, err := gp.repository.Worktree()
 	if err != nil {
 		return fmt.Errorf("failed to get worktree: %w", err)
 	}
 
-	branchRefName := plumbing.NewBranchReferenceName(gp.repoBranch)
 	log.Printf("Attempting to checkout branch: %s\n", branchRefName)
 
 	_, err = gp.repository.Reference(branchRefName, true)
 	if err == plumbing.ErrReferenceNotFound {
-		log.Printf("Branch %s not found locally, attempting to create from remote origin/%s\n", gp.repoBranch, gp.repoBranch)
-		remoteBranchRefName := plumbing.NewRemoteReferenceName("origin", gp.repoBranch)
+		log.Printf("Branch %s not found locally, attempting to create from remote origin/%s\n", gp.revision, gp.revision)
+		remoteBranchRefName := plumbing.NewRemoteReferenceName("origin", gp.revision)
 		headRef, err := gp.repository.Reference(remoteBranchRefName, true)
 		if err != nil {
 			return fmt.Errorf("remote branch %s not found: %w", remoteBranchRefName, err)
@@ -105,12 +222,12 @@ func (gp *GitPoller) checkoutBranch() error {
 			Create: true,
 		})
 		if err != nil {
-			return fmt.Errorf("failed to checkout new branch %s from remote: %w", gp.repoBranch, err)
+			return fmt.Errorf("failed to checkout new branch %s from remote: %w", gp.revision, err)
 		}
-		log.Printf("Successfully checked out and created branch %s from %s\n", gp.repoBranch, remoteBranchRefName)
+		log.Printf("Successfully checked out and created branch %s from %s\n", gp.revision, remoteBranchRefName)
 		return nil
 	} else if err != nil {
-		return fmt.Errorf("failed to get reference for branch %s: %w", gp.repoBranch, err)
+		return fmt.Errorf("failed to get reference for branch %s: %w", gp.revision, err)
 	}
 
 	err = w.Checkout(&git.CheckoutOptions{
@@ -118,23 +235,33 @@ func (gp *GitPoller) checkoutBranch() error {
 		Force:  true,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to checkout branch %s: %w", gp.repoBranch, err)
+		return fmt.Errorf("failed to checkout branch %s: %w", gp.revision, err)
 	}
-	log.Printf("Successfully checked out branch %s\n", gp.repoBranch)
+	log.Printf("Successfully checked out branch %s\n", gp.revision)
 	return nil
 }
 
 // FetchLatest fetches the latest changes from the remote for the configured branch
-// and resets the local branch to the fetched remote branch.
+// and resets the local branch to the fetched remote branch. Other revisions are
+// fetched and checked out as a detached HEAD.
 func (gp *GitPoller) FetchLatest() error {
 	if gp.repository == nil {
 		return fmt.Errorf("repository not initialized, call InitializeRepo first")
 	}
 
-	log.Printf("Fetching latest changes for branch %s from remote %s\n", gp.repoBranch, gp.repoURL)
+	start := time.Now()
+	defer func() { metrics.ObserveFetch(gp.repoURL, gp.revision, time.Since(start)) }()
+
+	if gp.kind != RevisionBranch {
+		return gp.fetchDetached()
+	}
+
+	log.Printf("Fetching latest changes for branch %s from remote %s\n", gp.revision, gp.repoURL)
 	err := gp.repository.Fetch(&git.FetchOptions{
 		RemoteName: "origin",
-		RefSpecs:   []gogitconfig.RefSpec{gogitconfig.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", gp.repoBranch, gp.repoBranch))},
+		Auth:       gp.auth,
+		RefSpecs:   []gogitconfig.RefSpec{gogitconfig.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", gp.revision, gp.revision))},
+		Depth:      gp.depth,
 		Progress:   os.Stdout,
 		Force:      true,
 	})
@@ -143,18 +270,21 @@ func (gp *GitPoller) FetchLatest() error {
 	}
 	log.Println("Fetch completed.")
 
-	w, err := gp.repository.Worktree()
+	remoteBranchRef := plumbing.NewRemoteReferenceName("origin", gp.revision)
+	targetRef, err := gp.repository.Reference(remoteBranchRef, true)
 	if err != nil {
-		return fmt.Errorf("failed to get worktree: %w", err)
+		return fmt.Errorf("failed to get reference for remote branch %s: %w", remoteBranchRef, err)
+	}
+	if !gp.usesWorktree() {
+		return gp.checkoutObjects(targetRef.Hash(), plumbing.NewBranchReferenceName(gp.revision))
 	}
 
-	remoteBranchRef := plumbing.NewRemoteReferenceName("origin", gp.repoBranch)
-	targetRef, err := gp.repository.Reference(remoteBranchRef, true)
+	w, err := gp.repository.Worktree()
 	if err != nil {
-		return fmt.Errorf("failed to get reference for remote branch %s: %w", remoteBranchRef, err)
+		return fmt.Errorf("failed to get worktree: %w", err)
 	}
 
-	log.Printf("Resetting local branch %s to %s (%s)\n", gp.repoBranch, remoteBranchRef, targetRef.Hash())
+	log.Printf("Resetting local branch %s to %s (%s)\n", gp.revision, remoteBranchRef, targetRef.Hash())
 	err = w.Reset(&git.ResetOptions{
 		Commit: targetRef.Hash(),
 		Mode:   git.HardReset,
@@ -166,6 +296,65 @@ func (gp *GitPoller) FetchLatest() error {
 	return gp.checkoutBranch()
 }
 
+// fetchDetached fetches the commit a tag, commit or semver revision designates
+// and checks it out as a detached HEAD.
+func (gp *GitPoller) fetchDetached() error {
+	log.Printf("Fetching %s %s from remote %s\n", gp.kind, gp.revision, gp.repoURL)
+	hash, err := gp.fetchRevision()
+	if err != nil {
+		return err
+	}
+	log.Println("Fetch completed.")
+	if !gp.usesWorktree() {
+		return gp.checkoutObjects(hash, "")
+	}
+
+	w, err := gp.repository.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+		return fmt.Errorf("failed to checkout commit %s: %w", hash, err)
+	}
+	return nil
+}
+
+// RepoURL returns the URL of the polled repository.
+func (gp *GitPoller) RepoURL() string {
+	return gp.repoURL
+}
+
+// Revision returns the configured revision: a branch, tag, commit SHA or semver
+// constraint.
+func (gp *GitPoller) Revision() string {
+	return gp.revision
+}
+
+// LocalPath returns the directory of the local clone.
+func (gp *GitPoller) LocalPath() string {
+	return gp.localPath
+}
+
+// Tag returns the tag checked out by the most recent fetch of a tag or semver
+// revision, or an empty string for other revisions.
+func (gp *GitPoller) Tag() string {
+	return gp.tag
+}
+
+// LastCommitHash returns the commit hash seen by the most recent Poll, or an
+// empty string before the first successful poll. The commit is fetched, not
+// necessarily applied; applications track their synced commits themselves.
+func (gp *GitPoller) LastCommitHash() string {
+	return gp.lastCommitHash
+}
+
+// SetLastCommitHash makes the next Poll compare the head of the revision with
+// commitHash, e.g. the commit synced before a restart, instead of reporting it
+// as new. The manifest changes are then relative to commitHash.
+func (gp *GitPoller) SetLastCommitHash(commitHash string) {
+	gp.lastCommitHash = commitHash
+}
+
 // GetCurrentCommitHash retrieves the commit hash of the current HEAD of the local working tree.
 func (gp *GitPoller) GetCurrentCommitHash() (string, error) {
 	if gp.repository == nil {
@@ -180,13 +369,36 @@ func (gp *GitPoller) GetCurrentCommitHash() (string, error) {
 }
 
 // GetManifestFiles scans the configured manifest directory within the local repository
-// and returns a list of .yaml or .yml file paths.
+// and returns a list of .yaml, .yml or .json file paths. If the directory contains a
+// kustomization or is a Helm chart, only the kustomization file or Chart.yaml is
+// returned, since it decides which files are rendered and how. A bare clone
+// lists the files of the HEAD commit instead; see ReadManifestFiles.
 func (gp *GitPoller) GetManifestFiles() ([]string, error) {
 	if gp.repository == nil {
 		return nil, fmt.Errorf("repository not initialized")
 	}
+	if gp.bare {
+		head, err := gp.GetCurrentCommitHash()
+		if err != nil {
+			return nil, err
+		}
+		files, _, err := gp.readManifestTree(head, false)
+		return files, err
+	}
 
 	manifestDir := filepath.Join(gp.localPath, gp.manifestPathInRepo)
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		kustomization := filepath.Join(manifestDir, name)
+		if info, err := os.Stat(kustomization); err == nil && !info.IsDir() {
+			log.Printf("Found kustomization: %s", kustomization)
+			return []string{kustomization}, nil
+		}
+	}
+	chartFile := filepath.Join(manifestDir, chartutil.ChartfileName)
+	if info, err := os.Stat(chartFile); err == nil && !info.IsDir() {
+		log.Printf("Found Helm chart: %s", chartFile)
+		return []string{chartFile}, nil
+	}
 	log.Printf("Scanning for manifest files in: %s", manifestDir)
 
 	var files []string
@@ -199,8 +411,7 @@ func (gp *GitPoller) GetManifestFiles() ([]string, error) {
 			return err
 		}
 		if !d.IsDir() {
-			ext := filepath.Ext(d.Name())
-			if ext == ".yaml" || ext == ".yml" {
+			if isManifestFile(d.Name()) {
 				log.Printf("Found manifest file: %s", path)
 				files = append(files, path)
 			}
@@ -216,17 +427,48 @@ func (gp *GitPoller) GetManifestFiles() ([]string, error) {
 	}
 
 	if len(files) == 0 {
-		log.Printf("No manifest files (.yaml/.yml) found in %s", manifestDir)
+		log.Printf("No manifest files (.yaml/.yml/.json) found in %s", manifestDir)
 	}
 	return files, nil
 }
 
+// isManifestFile reports whether the file name is a plain manifest file: YAML
+// or JSON.
+func isManifestFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// isRendered reports whether manifestFiles, as returned by GetManifestFiles,
+// name a kustomization or Helm chart rather than plain manifest files.
+func isRendered(manifestFiles []string) bool {
+	if len(manifestFiles) != 1 {
+		return false
+	}
+	name := filepath.Base(manifestFiles[0])
+	for _, kustomization := range konfig.RecognizedKustomizationFileNames() {
+		if name == kustomization {
+			return true
+		}
+	}
+	return name == chartutil.ChartfileName
+}
+
 // Poll checks for new commits. If a new commit is found, it fetches the changes,
 // updates the local repository, updates lastCommitHash, retrieves manifest files, and returns true.
+// The files under the manifest directory that the new commit changed are
+// available from Changes afterwards.
 func (gp *GitPoller) Poll() (changed bool, commitHash string, manifestFiles []string, err error) {
 	if gp.repository == nil {
 		return false, "", nil, fmt.Errorf("repository not initialized, call InitializeRepo first")
 	}
+	gp.changes = nil
+
+	start := time.Now()
+	defer func() { metrics.ObservePoll(gp.repoURL, gp.revision, time.Since(start), changed, err) }()
 
 	log.Println("Polling for new commits...")
 
@@ -240,32 +482,130 @@ func (gp *GitPoller) Poll() (changed bool, commitHash string, manifestFiles []st
 		return false, "", nil, fmt.Errorf("failed to get current commit hash: %w", hashErr)
 	}
 
+	if newCommitHash != gp.lastCommitHash {
+		if verifyErr := gp.VerifyCommit(newCommitHash); verifyErr != nil {
+			metrics.ObserveSignatureRejection(gp.repoURL, gp.revision)
+			gp.restoreLastCommit()
+			return false, "", nil, fmt.Errorf("refusing to deploy commit %s: %w", newCommitHash, verifyErr)
+		}
+	}
+
+	// The commit only counts as polled once its manifests could be listed, so
+	// that a commit failing to list is reported as new again by the next Poll.
 	if gp.lastCommitHash == "" { // First poll after initialization
-		log.Printf("Initial commit hash for branch %s: %s\n", gp.repoBranch, newCommitHash)
-		gp.lastCommitHash = newCommitHash
+		log.Printf("Initial commit hash for %s %s: %s\n", gp.kind, gp.revision, newCommitHash)
 
 		files, listErr := gp.GetManifestFiles()
 		if listErr != nil {
 			return false, newCommitHash, nil, fmt.Errorf("failed to list manifest files on initial poll: %w", listErr)
 		}
+		gp.lastCommitHash = newCommitHash
 		return true, newCommitHash, files, nil
 	}
 
 	if newCommitHash != gp.lastCommitHash {
-		log.Printf("New commit detected on branch %s. Old: %s, New: %s\n", gp.repoBranch, gp.lastCommitHash, newCommitHash)
-		gp.lastCommitHash = newCommitHash
+		log.Printf("New commit detected on %s %s. Old: %s, New: %s\n", gp.kind, gp.revision, gp.lastCommitHash, newCommitHash)
+		oldCommitHash := gp.lastCommitHash
 
 		files, listErr := gp.GetManifestFiles()
 		if listErr != nil {
+			gp.restoreLastCommit()
 			return true, newCommitHash, nil, fmt.Errorf("new commit detected, but failed to list manifest files: %w", listErr)
 		}
+		gp.lastCommitHash = newCommitHash
+		changes, diffErr := gp.diffManifests(oldCommitHash, newCommitHash, isRendered(files))
+		if diffErr != nil {
+			log.Printf("Could not determine the changed manifest files: %v\n", diffErr)
+		} else {
+			log.Printf("Manifest files changed since %s: %d added, %d modified, %d deleted\n", oldCommitHash, len(changes.Added), len(changes.Modified), len(changes.Deleted))
+			gp.changes = changes
+		}
 		return true, newCommitHash, files, nil
 	}
 
-	log.Printf("No new commits found on branch %s. Current hash: %s\n", gp.repoBranch, gp.lastCommitHash)
+	log.Printf("No new commits found on %s %s. Current hash: %s\n", gp.kind, gp.revision, gp.lastCommitHash)
 	return false, gp.lastCommitHash, nil, nil
 }
 
+// restoreLastCommit resets the working tree to the last polled commit after
+// the fetched one was rejected or its manifests could not be listed, so that
+// its files are never read.
+func (gp *GitPoller) restoreLastCommit() {
+	if gp.lastCommitHash == "" {
+		return
+	}
+	if _, err := gp.Checkout(gp.lastCommitHash); err != nil {
+		log.Printf("Failed to restore the working tree to commit %s: %v\n", gp.lastCommitHash, err)
+	}
+}
+
+// CommitInfo describes a commit.
+type CommitInfo struct {
+	Hash    string
+	Author  string // "Name <email>"
+	Message string
+	Time    time.Time // Author time
+}
+
+// CommitInfo returns the author and message of the commit hash.
+func (gp *GitPoller) CommitInfo(hash string) (CommitInfo, error) {
+	commit, err := gp.getCommitObject(hash)
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	return CommitInfo{
+		Hash:    commit.Hash.String(),
+		Author:  commit.Author.String(),
+		Message: strings.TrimSpace(commit.Message),
+		Time:    commit.Author.When,
+	}, nil
+}
+
+// ResolveCommit returns the full hash of revision, a full or abbreviated commit
+// hash or another revision understood by git, e.g. "HEAD~1". The commit must be
+// present in the local clone.
+func (gp *GitPoller) ResolveCommit(revision string) (string, error) {
+	if gp.repository == nil {
+		return "", fmt.Errorf("repository not initialized")
+	}
+	if commitSHAPattern.MatchString(revision) {
+		// An older commit may not have been fetched into a shallow clone yet
+		if err := gp.deepen(plumbing.NewHash(revision)); err != nil {
+			return "", err
+		}
+	}
+	hash, err := gp.repository.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve revision %s: %w", revision, err)
+	}
+	if _, err := gp.repository.CommitObject(*hash); err != nil {
+		return "", fmt.Errorf("revision %s is not a commit: %w", revision, err)
+	}
+	return hash.String(), nil
+}
+
+// Checkout resets the working tree to revision (see ResolveCommit), so that
+// GetManifestFiles lists the manifests of that commit, and returns its full
+// hash. The next Poll resets the working tree to the revision again.
+func (gp *GitPoller) Checkout(revision string) (string, error) {
+	commitHash, err := gp.ResolveCommit(revision)
+	if err != nil {
+		return "", err
+	}
+	if !gp.usesWorktree() {
+		// Detached, like a reset of a branch the next Poll resets again
+		return commitHash, gp.checkoutObjects(plumbing.NewHash(commitHash), "")
+	}
+	w, err := gp.repository.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := w.Reset(&git.ResetOptions{Commit: plumbing.NewHash(commitHash), Mode: git.HardReset}); err != nil {
+		return "", fmt.Errorf("failed to reset worktree to %s: %w", commitHash, err)
+	}
+	return commitHash, nil
+}
+
 // Helper function to get the *object.Commit from a hash string (if needed later)
 func (gp *GitPoller) getCommitObject(hash string) (*object.Commit, error) {
 	if gp.repository == nil {
@@ -6,8 +6,10 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-git/v5" // Import go-git
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 func TestNewGitPoller(t *testing.T) {
@@ -66,6 +68,7 @@ func TestGetManifestFiles_Success(t *testing.T) {
 	expectedFiles := []string{
 		filepath.Join(manifestPath, "deployment.yaml"),
 		filepath.Join(manifestPath, "service.yml"),
+		filepath.Join(manifestPath, "namespace.json"),
 	}
 	otherFiles := []string{
 		filepath.Join(manifestPath, "README.md"),
@@ -181,3 +184,117 @@ func TestGetManifestFiles_EmptyManifestDir(t *testing.T) {
 		t.Errorf("Expected empty file list for an empty manifest directory, got %d files: %v", len(files), files)
 	}
 }
+
+func TestGetManifestFiles_Kustomization(t *testing.T) {
+	t.Helper()
+	tempDir := t.TempDir()
+	manifestPath := filepath.Join(tempDir, "deploy")
+	if err := os.MkdirAll(filepath.Join(manifestPath, "base"), 0755); err != nil {
+		t.Fatalf("Failed to create manifests subdir: %v", err)
+	}
+	for _, f := range []string{"kustomization.yaml", "patch.yaml", filepath.Join("base", "deployment.yaml")} {
+		if _, err := os.Create(filepath.Join(manifestPath, f)); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", f, err)
+		}
+	}
+
+	gp := &GitPoller{
+		localPath:          tempDir,
+		manifestPathInRepo: "deploy",
+		repository:         &git.Repository{},
+	}
+
+	files, err := gp.GetManifestFiles()
+	if err != nil {
+		t.Fatalf("GetManifestFiles() returned an error: %v", err)
+	}
+	expectedFiles := []string{filepath.Join(manifestPath, "kustomization.yaml")}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("GetManifestFiles() returned %v, expected only the kustomization %v", files, expectedFiles)
+	}
+}
+
+func TestGetManifestFiles_HelmChart(t *testing.T) {
+	t.Helper()
+	tempDir := t.TempDir()
+	manifestPath := filepath.Join(tempDir, "chart")
+	if err := os.MkdirAll(filepath.Join(manifestPath, "templates"), 0755); err != nil {
+		t.Fatalf("Failed to create templates subdir: %v", err)
+	}
+	for _, f := range []string{"Chart.yaml", "values.yaml", filepath.Join("templates", "deployment.yaml")} {
+		if _, err := os.Create(filepath.Join(manifestPath, f)); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", f, err)
+		}
+	}
+
+	gp := &GitPoller{
+		localPath:          tempDir,
+		manifestPathInRepo: "chart",
+		repository:         &git.Repository{},
+	}
+
+	files, err := gp.GetManifestFiles()
+	if err != nil {
+		t.Fatalf("GetManifestFiles() returned an error: %v", err)
+	}
+	expectedFiles := []string{filepath.Join(manifestPath, "Chart.yaml")}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("GetManifestFiles() returned %v, expected only the chart file %v", files, expectedFiles)
+	}
+}
+
+// commitFile writes content to name in the worktree of r and commits it with message.
+func commitFile(t *testing.T, r *git.Repository, dir, name, content, message string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get worktree: %v", err)
+	}
+	if _, err := w.Add(name); err != nil {
+		t.Fatalf("Failed to add %s: %v", name, err)
+	}
+	hash, err := w.Commit(message, &git.CommitOptions{Author: &object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatalf("Failed to commit %s: %v", name, err)
+	}
+	return hash.String()
+}
+
+func TestCheckout(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	first := commitFile(t, r, dir, "app.yaml", "version: 1\n", "Deploy version 1")
+	second := commitFile(t, r, dir, "app.yaml", "version: 2\n", "Deploy version 2")
+
+	poller := &GitPoller{repository: r, localPath: dir, manifestPathInRepo: "."}
+	info, err := poller.CommitInfo(second)
+	if err != nil {
+		t.Fatalf("CommitInfo() returned an unexpected error: %v", err)
+	}
+	if info.Author != "Jane Doe <jane@example.com>" || info.Message != "Deploy version 2" {
+		t.Errorf("unexpected commit info %+v", info)
+	}
+
+	commitHash, err := poller.Checkout(first[:8])
+	if err != nil {
+		t.Fatalf("Checkout() returned an unexpected error: %v", err)
+	}
+	if commitHash != first {
+		t.Errorf("expected Checkout to resolve %s, got %s", first, commitHash)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "app.yaml"))
+	if err != nil || string(content) != "version: 1\n" {
+		t.Errorf("expected the worktree at the first commit, got %q (%v)", content, err)
+	}
+
+	if _, err := poller.Checkout("0000000000000000000000000000000000000000"); err == nil {
+		t.Error("expected an error for an unknown commit")
+	}
+}
@@ -0,0 +1,235 @@
+package gitpoller
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v5"
+	gogitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// RevisionKind is how a GitPoller interprets its revision.
+type RevisionKind string
+
+const (
+	RevisionBranch RevisionKind = "branch" // Follow the head of a branch
+	RevisionTag    RevisionKind = "tag"    // Follow a tag, which is re-fetched in case it is moved
+	RevisionCommit RevisionKind = "commit" // Stay at a full commit SHA
+	RevisionSemver RevisionKind = "semver" // Follow the highest tag matching a semver constraint, e.g. "~1.4"
+)
+
+// commitSHAPattern matches a full commit SHA. Abbreviated hashes are not
+// accepted, as they cannot be told apart from branch and tag names.
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// peeledSuffix marks the entry of an annotated tag that names its commit in a
+// remote listing.
+const peeledSuffix = "^{}"
+
+// revisionKind determines how revision is interpreted, given the references of
+// the remote: as a full commit SHA, a branch, a tag, or a semver constraint on
+// tags, in this order of precedence.
+func revisionKind(revision string, refs []*plumbing.Reference) (RevisionKind, error) {
+	if commitSHAPattern.MatchString(revision) {
+		return RevisionCommit, nil
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.NewBranchReferenceName(revision) {
+			return RevisionBranch, nil
+		}
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.NewTagReferenceName(revision) {
+			return RevisionTag, nil
+		}
+	}
+	if _, err := semver.NewConstraint(revision); err == nil {
+		return RevisionSemver, nil
+	}
+	return "", fmt.Errorf("revision %s is neither a commit SHA, a branch, a tag nor a semver constraint", revision)
+}
+
+// RevisionMovedBy reports whether an update of the ref refName, e.g. announced
+// by a push webhook, may change the commit revision designates: if revision is
+// the branch or tag updated, or a semver constraint the updated tag satisfies.
+// A commit SHA never moves.
+func RevisionMovedBy(revision, refName string) bool {
+	ref := plumbing.ReferenceName(refName)
+	switch {
+	case commitSHAPattern.MatchString(revision):
+		return false
+	case ref.IsBranch():
+		return ref.Short() == revision
+	case !ref.IsTag():
+		return false
+	case ref.Short() == revision:
+		return true
+	}
+	constraints, err := semver.NewConstraint(revision)
+	if err != nil {
+		return false
+	}
+	version, err := semver.NewVersion(ref.Short())
+	return err == nil && constraints.Check(version)
+}
+
+// latestMatchingTag returns the name of the highest semver tag among refs that
+// satisfies constraint. Tags may carry a "v" prefix.
+func latestMatchingTag(constraint string, refs []*plumbing.Reference) (string, error) {
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid semver constraint %s: %w", constraint, err)
+	}
+	var latest *semver.Version
+	latestTag := ""
+	for _, ref := range refs {
+		if !ref.Name().IsTag() || strings.HasSuffix(ref.Name().String(), peeledSuffix) {
+			continue
+		}
+		tag := ref.Name().Short()
+		version, err := semver.NewVersion(tag)
+		if err != nil || !constraints.Check(version) {
+			continue
+		}
+		if latest == nil || version.GreaterThan(latest) {
+			latest, latestTag = version, tag
+		}
+	}
+	if latestTag == "" {
+		return "", fmt.Errorf("no tag matches semver constraint %s: %w", constraint, errRevisionNotFound)
+	}
+	return latestTag, nil
+}
+
+// listRemoteRefs lists the references of the remote repository, like
+// `git ls-remote`, without fetching any objects.
+func (gp *GitPoller) listRemoteRefs() ([]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gogitconfig.RemoteConfig{Name: "origin", URLs: []string{gp.repoURL}})
+	refs, err := remote.List(&git.ListOptions{Auth: gp.auth})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list references of %s: %w", gp.repoURL, err)
+	}
+	return refs, nil
+}
+
+// RemoteHead returns a function that lists the references of the remote,
+// without fetching any objects, and returns the hash the revision designates
+// there: the head of a branch, or the tag, or highest tag matching a semver
+// constraint, which for an annotated tag is the hash of the tag object. A commit
+// SHA designates itself. Unlike gp, the function is safe for concurrent use, so
+// it can watch the remote while gp is busy.
+func (gp *GitPoller) RemoteHead() func() (string, error) {
+	lister := &GitPoller{repoURL: gp.repoURL, revision: gp.revision, kind: gp.kind, auth: gp.auth}
+	return lister.remoteHead
+}
+
+func (gp *GitPoller) remoteHead() (string, error) {
+	if gp.kind == RevisionCommit {
+		return gp.revision, nil
+	}
+	refs, err := gp.listRemoteRefs()
+	if err != nil {
+		return "", err
+	}
+	var name plumbing.ReferenceName
+	switch gp.kind {
+	case RevisionBranch:
+		name = plumbing.NewBranchReferenceName(gp.revision)
+	case RevisionTag:
+		name = plumbing.NewTagReferenceName(gp.revision)
+	case RevisionSemver:
+		tag, err := latestMatchingTag(gp.revision, refs)
+		if err != nil {
+			return "", err
+		}
+		name = plumbing.NewTagReferenceName(tag)
+	default:
+		return "", fmt.Errorf("unexpected revision kind %q", gp.kind)
+	}
+	for _, ref := range refs {
+		if ref.Name() == name {
+			return ref.Hash().String(), nil
+		}
+	}
+	return "", fmt.Errorf("%s %s does not exist in %s: %w", gp.kind, gp.revision, gp.repoURL, errRevisionNotFound)
+}
+
+// resolveRevisionKind lists the remote references to determine gp.kind.
+func (gp *GitPoller) resolveRevisionKind() error {
+	if gp.kind != "" {
+		return nil
+	}
+	var refs []*plumbing.Reference
+	if !commitSHAPattern.MatchString(gp.revision) {
+		var err error
+		if refs, err = gp.listRemoteRefs(); err != nil {
+			return err
+		}
+	}
+	kind, err := revisionKind(gp.revision, refs)
+	if err != nil {
+		return err
+	}
+	gp.kind = kind
+	return nil
+}
+
+// fetchRevision fetches what the revision of a tag, commit or semver GitPoller
+// currently designates and returns the hash of that commit.
+func (gp *GitPoller) fetchRevision() (plumbing.Hash, error) {
+	var tag string
+	switch gp.kind {
+	case RevisionTag:
+		tag = gp.revision
+	case RevisionSemver:
+		refs, err := gp.listRemoteRefs()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if tag, err = latestMatchingTag(gp.revision, refs); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if tag != gp.tag {
+			log.Printf("Semver constraint %s resolves to tag %s\n", gp.revision, tag)
+		}
+	case RevisionCommit:
+		hash := plumbing.NewHash(gp.revision)
+		if _, err := gp.repository.CommitObject(hash); err == nil {
+			return hash, nil // Already fetched; a commit never changes
+		}
+		err := gp.fetch("+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*")
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if err := gp.deepen(hash); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if _, err := gp.repository.CommitObject(hash); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("commit %s is not reachable from any branch or tag of %s: %w", gp.revision, gp.repoURL, errRevisionNotFound)
+		}
+		return hash, nil
+	default:
+		return plumbing.ZeroHash, fmt.Errorf("unexpected revision kind %q", gp.kind)
+	}
+
+	tagRef := plumbing.NewTagReferenceName(tag)
+	if err := gp.fetch(fmt.Sprintf("+%s:%s", tagRef, tagRef)); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	hash, err := gp.repository.ResolveRevision(plumbing.Revision(tagRef))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to resolve tag %s: %w", tag, err)
+	}
+	gp.tag = tag
+	return *hash, nil
+}
+
+// fetch fetches refSpecs from origin, as deep as the clone is; see WithDepth.
+func (gp *GitPoller) fetch(refSpecs ...string) error {
+	return gp.fetchDepth(gp.depth, refSpecs...)
+}
@@ -0,0 +1,168 @@
+package gitpoller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestRevisionKind(t *testing.T) {
+	t.Helper()
+	commit := plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")
+	refs := []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), commit),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("v2"), commit),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v1.4.0"), commit),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v2"), commit),
+	}
+	tests := []struct {
+		revision string
+		expected RevisionKind
+	}{
+		{"main", RevisionBranch},
+		{"v2", RevisionBranch}, // Branches take precedence over tags
+		{"v1.4.0", RevisionTag},
+		{commit.String(), RevisionCommit},
+		{"~1.4", RevisionSemver},
+		{">= 1.2, < 2", RevisionSemver},
+	}
+	for _, tt := range tests {
+		kind, err := revisionKind(tt.revision, refs)
+		if err != nil {
+			t.Errorf("revisionKind(%q) returned an unexpected error: %v", tt.revision, err)
+			continue
+		}
+		if kind != tt.expected {
+			t.Errorf("revisionKind(%q): expected %s, got %s", tt.revision, tt.expected, kind)
+		}
+	}
+
+	if _, err := revisionKind("feature/unknown", refs); err == nil {
+		t.Error("expected an error for a revision that is neither a ref nor a constraint")
+	}
+}
+
+func TestRevisionMovedBy(t *testing.T) {
+	t.Helper()
+	tests := []struct {
+		revision string
+		ref      string
+		expected bool
+	}{
+		{"main", "refs/heads/main", true},
+		{"main", "refs/heads/feature", false},
+		{"main", "refs/tags/main", true}, // Branch and tag names are ambiguous
+		{"v1.4.0", "refs/tags/v1.4.0", true},
+		{"v1.4.0", "refs/tags/v1.4.1", false},
+		{"~1.4", "refs/tags/v1.4.2", true},
+		{"~1.4", "refs/tags/v1.5.0", false},
+		{"~1.4", "refs/heads/release-1.4", false},
+		{"0123456789abcdef0123456789abcdef01234567", "refs/heads/main", false},
+		{"main", "refs/notes/commits", false},
+	}
+	for _, tt := range tests {
+		if moved := RevisionMovedBy(tt.revision, tt.ref); moved != tt.expected {
+			t.Errorf("RevisionMovedBy(%q, %q) = %t, expected %t", tt.revision, tt.ref, moved, tt.expected)
+		}
+	}
+}
+
+func TestLatestMatchingTag(t *testing.T) {
+	t.Helper()
+	commit := plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")
+	var refs []*plumbing.Reference
+	for _, name := range []string{"v1.3.9", "v1.4.0", "1.4.10", "v1.4.2", "v1.5.0", "v1.4.11-rc.1", "latest"} {
+		refs = append(refs, plumbing.NewHashReference(plumbing.NewTagReferenceName(name), commit))
+	}
+	refs = append(refs,
+		plumbing.NewHashReference(plumbing.ReferenceName("refs/tags/v1.4.99"+peeledSuffix), commit),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("v1.4.50"), commit),
+	)
+
+	tests := []struct {
+		constraint string
+		expected   string
+	}{
+		{"~1.4", "1.4.10"},
+		{"^1", "v1.5.0"},
+		{"1.3.x", "v1.3.9"},
+		{"~1.4.11-0", "v1.4.11-rc.1"},
+	}
+	for _, tt := range tests {
+		tag, err := latestMatchingTag(tt.constraint, refs)
+		if err != nil {
+			t.Errorf("latestMatchingTag(%q) returned an unexpected error: %v", tt.constraint, err)
+			continue
+		}
+		if tag != tt.expected {
+			t.Errorf("latestMatchingTag(%q): expected %s, got %s", tt.constraint, tt.expected, tag)
+		}
+	}
+
+	if _, err := latestMatchingTag("~2.0", refs); err == nil {
+		t.Error("expected an error when no tag matches")
+	}
+}
+
+func TestPoll_SemverRevision(t *testing.T) {
+	t.Helper()
+	remoteDir := t.TempDir()
+	remote, err := git.PlainInit(remoteDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	tag := func(name, hash string) {
+		t.Helper()
+		if _, err := remote.CreateTag(name, plumbing.NewHash(hash), nil); err != nil {
+			t.Fatalf("Failed to create tag %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(remoteDir, "manifests"), 0755); err != nil {
+		t.Fatalf("Failed to create manifest directory: %v", err)
+	}
+	tag("v1.4.0", commitFile(t, remote, remoteDir, "manifests/app.yaml", "version: 1.4.0\n", "Release 1.4.0"))
+	release142 := commitFile(t, remote, remoteDir, "manifests/app.yaml", "version: 1.4.2\n", "Release 1.4.2")
+	tag("v1.4.2", release142)
+	tag("v1.5.0", commitFile(t, remote, remoteDir, "manifests/app.yaml", "version: 1.5.0\n", "Release 1.5.0"))
+
+	localDir := filepath.Join(t.TempDir(), "clone")
+	poller, err := NewGitPoller(remoteDir, "~1.4", localDir, "manifests")
+	if err != nil {
+		t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+	}
+	if err := poller.InitializeRepo(); err != nil {
+		t.Fatalf("InitializeRepo() returned an unexpected error: %v", err)
+	}
+	changed, commitHash, files, err := poller.Poll()
+	if err != nil {
+		t.Fatalf("Poll() returned an unexpected error: %v", err)
+	}
+	if !changed || commitHash != release142 || len(files) != 1 || poller.Tag() != "v1.4.2" {
+		t.Fatalf("expected the first poll to check out v1.4.2 (%s), got changed %t, commit %s, tag %s, files %v", release142, changed, commitHash, poller.Tag(), files)
+	}
+	content, err := os.ReadFile(filepath.Join(localDir, "manifests", "app.yaml"))
+	if err != nil || string(content) != "version: 1.4.2\n" {
+		t.Errorf("expected the worktree at v1.4.2, got %q (%v)", content, err)
+	}
+
+	if changed, _, _, err := poller.Poll(); err != nil || changed {
+		t.Errorf("expected no change without a new tag, got changed %t, error %v", changed, err)
+	}
+	remoteHead := poller.RemoteHead()
+	if head, err := remoteHead(); err != nil || head != release142 {
+		t.Errorf("expected the remote head to be v1.4.2 (%s), got %s (%v)", release142, head, err)
+	}
+
+	release143 := commitFile(t, remote, remoteDir, "manifests/app.yaml", "version: 1.4.3\n", "Release 1.4.3")
+	tag("v1.4.3", release143)
+	if head, err := remoteHead(); err != nil || head != release143 {
+		t.Errorf("expected the remote head to move to v1.4.3 (%s), got %s (%v)", release143, head, err)
+	}
+	changed, commitHash, _, err = poller.Poll()
+	if err != nil || !changed || commitHash != release143 || poller.Tag() != "v1.4.3" {
+		t.Errorf("expected a new matching tag to be detected, got changed %t, commit %s, tag %s, error %v", changed, commitHash, poller.Tag(), err)
+	}
+}
@@ -0,0 +1,95 @@
+package gitpoller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-git/go-git/v5"
+	gogitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// maxDeepenDepth bounds the history fetched to find a commit of a shallow
+// clone, in commits from the head of the revision.
+const maxDeepenDepth = 1 << 20
+
+// WithDepth makes the clone shallow: clones and fetches only get the last
+// depth commits of the revision. Older commits, e.g. the one a diff or a
+// rollback needs, are fetched on demand; see deepen. A depth of 0 fetches the
+// full history.
+func WithDepth(depth int) Option {
+	return func(gp *GitPoller) {
+		gp.depth = depth
+	}
+}
+
+// revisionRefSpecs returns the refspecs fetching what the revision designates.
+func (gp *GitPoller) revisionRefSpecs() []string {
+	switch gp.kind {
+	case RevisionBranch:
+		return []string{fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", gp.revision, gp.revision)}
+	case RevisionTag, RevisionSemver:
+		tagRef := plumbing.NewTagReferenceName(gp.tag)
+		return []string{fmt.Sprintf("+%s:%s", tagRef, tagRef)}
+	default:
+		return []string{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"}
+	}
+}
+
+// deepen fetches more history of a shallow clone until it contains the commit
+// hash, doubling the depth every time. It fails if hash is not found once the
+// full history is fetched. Full clones are left as they are.
+func (gp *GitPoller) deepen(hash plumbing.Hash) error {
+	if gp.depth <= 0 {
+		return nil
+	}
+	for depth := gp.depth * 2; ; depth *= 2 {
+		if _, err := gp.repository.CommitObject(hash); err == nil {
+			return nil
+		}
+		if depth > maxDeepenDepth {
+			return fmt.Errorf("commit %s is not within %d commits of %s %s: %w", hash, maxDeepenDepth, gp.kind, gp.revision, errRevisionNotFound)
+		}
+		before, err := gp.repository.Storer.Shallow()
+		if err != nil {
+			return fmt.Errorf("failed to read shallow commits: %w", err)
+		}
+		log.Printf("Deepening the clone of %s to %d commits to find commit %s\n", gp.repoURL, depth, hash)
+		if err := gp.fetchDepth(depth, gp.revisionRefSpecs()...); err != nil {
+			return err
+		}
+		after, err := gp.repository.Storer.Shallow()
+		if err != nil {
+			return fmt.Errorf("failed to read shallow commits: %w", err)
+		}
+		if len(after) == len(before) {
+			// Nothing older was fetched, so the history is complete.
+			if _, err := gp.repository.CommitObject(hash); err == nil {
+				return nil
+			}
+			return fmt.Errorf("commit %s is not in the history of %s %s: %w", hash, gp.kind, gp.revision, errRevisionNotFound)
+		}
+	}
+}
+
+// fetchDepth fetches refSpecs from origin, limited to the last depth commits
+// of each; a depth of 0 fetches the full history.
+func (gp *GitPoller) fetchDepth(depth int, refSpecs ...string) error {
+	specs := make([]gogitconfig.RefSpec, len(refSpecs))
+	for i, refSpec := range refSpecs {
+		specs[i] = gogitconfig.RefSpec(refSpec)
+	}
+	err := gp.repository.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       gp.auth,
+		RefSpecs:   specs,
+		Depth:      depth,
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch from remote: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package gitpoller
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// initRemote creates a repository with a manifests and an other directory and
+// returns it with the hashes of its commits, oldest first.
+func initRemote(t *testing.T, commits int) (*git.Repository, string, []string) {
+	t.Helper()
+	remoteDir := t.TempDir()
+	remote, err := git.PlainInit(remoteDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	for _, dir := range []string{"manifests", "other"} {
+		if err := os.Mkdir(filepath.Join(remoteDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	commitFile(t, remote, remoteDir, "other/README.md", "Not a manifest\n", "Add README")
+	var hashes []string
+	for i := 1; i <= commits; i++ {
+		hashes = append(hashes, commitFile(t, remote, remoteDir, "manifests/app.yaml", "replicas: "+strconv.Itoa(i)+"\n", "Scale"))
+	}
+	return remote, remoteDir, hashes
+}
+
+func TestPoll_ShallowClone(t *testing.T) {
+	t.Helper()
+	remote, remoteDir, hashes := initRemote(t, 4)
+	head, err := remote.Head()
+	if err != nil {
+		t.Fatalf("Failed to get HEAD: %v", err)
+	}
+
+	poller, err := NewGitPoller(remoteDir, head.Name().Short(), filepath.Join(t.TempDir(), "clone"), "manifests", WithDepth(1))
+	if err != nil {
+		t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+	}
+	if err := poller.InitializeRepo(); err != nil {
+		t.Fatalf("InitializeRepo() returned an unexpected error: %v", err)
+	}
+	if _, commitHash, _, err := poller.Poll(); err != nil || commitHash != hashes[3] {
+		t.Fatalf("expected the first poll to find %s, got %s (%v)", hashes[3], commitHash, err)
+	}
+	if _, err := poller.repository.CommitObject(plumbing.NewHash(hashes[0])); err == nil {
+		t.Fatal("expected older commits to be missing from a shallow clone")
+	}
+
+	if commitHash, err := poller.Checkout(hashes[0]); err != nil || commitHash != hashes[0] {
+		t.Fatalf("expected a checkout of an older commit to deepen the clone, got %s (%v)", commitHash, err)
+	}
+	if _, err := poller.Checkout("0123456789abcdef0123456789abcdef01234567"); err == nil {
+		t.Error("expected an error for a commit that is not in the history")
+	}
+}
+
+func TestPoll_SparseCheckout(t *testing.T) {
+	t.Helper()
+	remote, remoteDir, hashes := initRemote(t, 1)
+	head, err := remote.Head()
+	if err != nil {
+		t.Fatalf("Failed to get HEAD: %v", err)
+	}
+
+	localDir := filepath.Join(t.TempDir(), "clone")
+	poller, err := NewGitPoller(remoteDir, head.Name().Short(), localDir, "manifests", WithSparseCheckout(true))
+	if err != nil {
+		t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+	}
+	if err := poller.InitializeRepo(); err != nil {
+		t.Fatalf("InitializeRepo() returned an unexpected error: %v", err)
+	}
+	share := poller.Share("manifests")
+	_, commitHash, files, err := share.Poll()
+	if err != nil || commitHash != hashes[0] || len(files) != 1 {
+		t.Fatalf("expected the first poll to find %s with one manifest, got %s, %v (%v)", hashes[0], commitHash, files, err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "other")); !os.IsNotExist(err) {
+		t.Errorf("expected directories outside the manifest directory not to be checked out, got %v", err)
+	}
+
+	otherShare := poller.Share("other")
+	next := commitFile(t, remote, remoteDir, "manifests/app.yaml", "replicas: 5\n", "Scale up")
+	if changed, commitHash, _, err := share.Poll(); err != nil || !changed || commitHash != next {
+		t.Fatalf("expected the new commit %s, got changed %t, commit %s (%v)", next, changed, commitHash, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(localDir, "manifests", "app.yaml")); err != nil || string(content) != "replicas: 5\n" {
+		t.Errorf("expected the manifest of the new commit, got %q (%v)", content, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(localDir, "other", "README.md")); err != nil || string(content) != "Not a manifest\n" {
+		t.Errorf("expected the directory of the new share to be checked out, got %q (%v)", content, err)
+	}
+	if current, err := otherShare.GetCurrentCommitHash(); err != nil || current != next {
+		t.Errorf("expected HEAD at %s, got %s (%v)", next, current, err)
+	}
+
+	if _, err := share.Checkout(hashes[0]); err != nil {
+		t.Fatalf("Checkout() returned an unexpected error: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(localDir, "manifests", "app.yaml")); err != nil || string(content) != "replicas: 1\n" {
+		t.Errorf("expected the manifest of the checked out commit, got %q (%v)", content, err)
+	}
+}
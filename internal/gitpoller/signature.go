@@ -0,0 +1,339 @@
+package gitpoller
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUntrustedCommit is wrapped by the errors of commits that are unsigned or
+// not signed by a trusted key.
+var ErrUntrustedCommit = errors.New("commit is not signed by a trusted key")
+
+// Armor lines of the two supported signature formats.
+const (
+	openPGPSignatureHeader = "-----BEGIN PGP SIGNATURE-----"
+	sshSignatureHeader     = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureFooter     = "-----END SSH SIGNATURE-----"
+)
+
+// sshSignatureMagic starts an SSH signature blob and the data it signs, see
+// PROTOCOL.sshsig of OpenSSH.
+const sshSignatureMagic = "SSHSIG"
+
+// sshSignatureNamespace is the namespace git signs commits in.
+const sshSignatureNamespace = "git"
+
+// SignatureVerifier checks that commits carry an OpenPGP or SSH signature of a
+// trusted key.
+type SignatureVerifier struct {
+	openPGPKeys openpgp.EntityList
+	sshSigners  []allowedSigner
+}
+
+// allowedSigner is one entry of an allowed signers file.
+type allowedSigner struct {
+	principals  string
+	key         ssh.PublicKey
+	namespaces  []string  // Namespaces the key may sign in; empty allows all
+	validAfter  time.Time // Zero if unbounded
+	validBefore time.Time // Zero if unbounded
+}
+
+// NewSignatureVerifier builds a verifier trusting openPGPKeys, armored OpenPGP
+// public keys, and allowedSigners, in the allowed signers format of
+// ssh-keygen(1) that git uses for gpg.ssh.allowedSignersFile. The namespaces,
+// valid-after and valid-before options are honored, the latter two at the
+// commit time like git does; keys marked cert-authority are rejected, as
+// signatures made with certificates are not supported. It returns nil and no
+// error when no keys are given, which disables verification.
+func NewSignatureVerifier(openPGPKeys, allowedSigners string) (*SignatureVerifier, error) {
+	if strings.TrimSpace(openPGPKeys) == "" && strings.TrimSpace(allowedSigners) == "" {
+		return nil, nil
+	}
+	v := &SignatureVerifier{}
+	if strings.TrimSpace(openPGPKeys) != "" {
+		keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(openPGPKeys))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted OpenPGP keys: %w", err)
+		}
+		v.openPGPKeys = keys
+	}
+	signers, err := parseAllowedSigners(allowedSigners)
+	if err != nil {
+		return nil, err
+	}
+	v.sshSigners = signers
+	return v, nil
+}
+
+// parseAllowedSigners parses the lines of an allowed signers file:
+// principals, options and a public key in authorized_keys format.
+func parseAllowedSigners(content string) ([]allowedSigner, error) {
+	var signers []allowedSigner
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		signer, err := parseAllowedSigner(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse allowed signers line %d: %w", lineNumber, err)
+		}
+		signers = append(signers, signer)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read allowed signers: %w", err)
+	}
+	return signers, nil
+}
+
+// parseAllowedSigner parses one line of an allowed signers file. Principals
+// may be quoted, and option values may be quoted to contain spaces or commas.
+func parseAllowedSigner(line string) (allowedSigner, error) {
+	var principals, rest string
+	if quoted, ok := strings.CutPrefix(line, `"`); ok {
+		var found bool
+		if principals, rest, found = strings.Cut(quoted, `"`); !found {
+			return allowedSigner{}, errors.New("unterminated quoted principals")
+		}
+	} else if i := strings.IndexAny(line, " \t"); i >= 0 {
+		principals, rest = line[:i], line[i:]
+	} else {
+		principals = line
+	}
+	if principals == "" {
+		return allowedSigner{}, errors.New("missing principals")
+	}
+	// ParseAuthorizedKey splits the options before the key on unquoted commas.
+	key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimLeft(rest, " \t")))
+	if err != nil {
+		return allowedSigner{}, err
+	}
+	signer := allowedSigner{principals: principals, key: key}
+	for _, option := range options {
+		name, value, _ := strings.Cut(option, "=")
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(name) {
+		case "namespaces":
+			signer.namespaces = strings.Split(value, ",")
+		case "valid-after":
+			if signer.validAfter, err = parseSignerTime(value); err != nil {
+				return allowedSigner{}, fmt.Errorf("invalid valid-after: %w", err)
+			}
+		case "valid-before":
+			if signer.validBefore, err = parseSignerTime(value); err != nil {
+				return allowedSigner{}, fmt.Errorf("invalid valid-before: %w", err)
+			}
+		case "cert-authority":
+			return allowedSigner{}, errors.New("cert-authority keys are not supported")
+		default:
+			return allowedSigner{}, fmt.Errorf("unsupported option %q", name)
+		}
+	}
+	return signer, nil
+}
+
+// parseSignerTime parses a time of the valid-after and valid-before options:
+// YYYYMMDD, YYYYMMDDHHMM or YYYYMMDDHHMMSS, in UTC if followed by Z and in
+// local time otherwise.
+func parseSignerTime(value string) (time.Time, error) {
+	location := time.Local
+	if utc, ok := strings.CutSuffix(value, "Z"); ok {
+		value, location = utc, time.UTC
+	}
+	layouts := map[int]string{8: "20060102", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(value)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("%q is not a time in the format YYYYMMDD[HHMM[SS]][Z]", value)
+	}
+	return time.ParseInLocation(layout, value, location)
+}
+
+// validAt reports whether s may sign at time t.
+func (s *allowedSigner) validAt(t time.Time) bool {
+	return (s.validAfter.IsZero() || !t.Before(s.validAfter)) && (s.validBefore.IsZero() || !t.After(s.validBefore))
+}
+
+// Verify checks the signature of commit and returns a description of the
+// trusted key that made it. The error wraps ErrUntrustedCommit if the commit
+// is unsigned or its signature is invalid or made by an unknown key.
+func (v *SignatureVerifier) Verify(commit *object.Commit) (string, error) {
+	if commit.PGPSignature == "" {
+		return "", fmt.Errorf("%w: commit %s is unsigned", ErrUntrustedCommit, commit.Hash)
+	}
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return "", fmt.Errorf("failed to encode commit %s: %w", commit.Hash, err)
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode commit %s: %w", commit.Hash, err)
+	}
+	signed, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode commit %s: %w", commit.Hash, err)
+	}
+
+	var signer string
+	switch signature := strings.TrimSpace(commit.PGPSignature); {
+	case strings.HasPrefix(signature, openPGPSignatureHeader):
+		signer, err = v.verifyOpenPGP(signed, signature)
+	case strings.HasPrefix(signature, sshSignatureHeader):
+		signer, err = v.verifySSH(signed, signature, commit.Committer.When)
+	default:
+		err = errors.New("unsupported signature format")
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: commit %s: %v", ErrUntrustedCommit, commit.Hash, err)
+	}
+	return signer, nil
+}
+
+// verifyOpenPGP checks an armored OpenPGP signature of signed.
+func (v *SignatureVerifier) verifyOpenPGP(signed []byte, signature string) (string, error) {
+	if len(v.openPGPKeys) == 0 {
+		return "", errors.New("OpenPGP signature, but no OpenPGP keys are trusted")
+	}
+	entity, err := openpgp.CheckArmoredDetachedSignature(v.openPGPKeys, bytes.NewReader(signed), strings.NewReader(signature), nil)
+	if err != nil {
+		return "", fmt.Errorf("OpenPGP signature: %w", err)
+	}
+	if identity := entity.PrimaryIdentity(); identity != nil {
+		return fmt.Sprintf("OpenPGP key %s (%s)", entity.PrimaryKey.KeyIdString(), identity.Name), nil
+	}
+	return "OpenPGP key " + entity.PrimaryKey.KeyIdString(), nil
+}
+
+// sshSignature is the blob of an SSH signature after its magic preamble.
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is what an SSH signature signs, after its magic preamble.
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// verifySSH checks an armored SSH signature of signed, as made by
+// `ssh-keygen -Y sign -n git`, by a key allowed at signedAt.
+func (v *SignatureVerifier) verifySSH(signed []byte, signature string, signedAt time.Time) (string, error) {
+	armored := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(signature, sshSignatureHeader), sshSignatureFooter))
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(armored), ""))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode SSH signature: %w", err)
+	}
+	if !bytes.HasPrefix(blob, []byte(sshSignatureMagic)) {
+		return "", errors.New("malformed SSH signature")
+	}
+	var sig sshSignature
+	if err := ssh.Unmarshal(blob[len(sshSignatureMagic):], &sig); err != nil {
+		return "", fmt.Errorf("malformed SSH signature: %w", err)
+	}
+	if sig.Version != 1 {
+		return "", fmt.Errorf("unsupported SSH signature version %d", sig.Version)
+	}
+	if sig.Namespace != sshSignatureNamespace {
+		return "", fmt.Errorf("SSH signature is for namespace %q, not %q", sig.Namespace, sshSignatureNamespace)
+	}
+	publicKey, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the key of the SSH signature: %w", err)
+	}
+	signer := v.sshSigner(publicKey, signedAt)
+	if signer == nil {
+		return "", fmt.Errorf("SSH key %s is not an allowed signer at %s", ssh.FingerprintSHA256(publicKey), signedAt.Format(time.RFC3339))
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported SSH signature hash algorithm %q", sig.HashAlgorithm)
+	}
+	h.Write(signed)
+	data := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...)
+	var wireSignature ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &wireSignature); err != nil {
+		return "", fmt.Errorf("malformed SSH signature: %w", err)
+	}
+	if err := publicKey.Verify(data, &wireSignature); err != nil {
+		return "", fmt.Errorf("SSH signature: %w", err)
+	}
+	return fmt.Sprintf("SSH key %s (%s)", ssh.FingerprintSHA256(publicKey), signer.principals), nil
+}
+
+// sshSigner returns the allowed signer of key for git at time t, or nil.
+func (v *SignatureVerifier) sshSigner(key ssh.PublicKey, t time.Time) *allowedSigner {
+	for i, signer := range v.sshSigners {
+		if !bytes.Equal(signer.key.Marshal(), key.Marshal()) || !signer.validAt(t) {
+			continue
+		}
+		if len(signer.namespaces) == 0 {
+			return &v.sshSigners[i]
+		}
+		for _, namespace := range signer.namespaces {
+			if namespace == sshSignatureNamespace {
+				return &v.sshSigners[i]
+			}
+		}
+	}
+	return nil
+}
+
+// WithSignatureVerifier requires every commit reported by Poll to be signed by
+// a key trusted by verifier. A nil verifier disables verification.
+func WithSignatureVerifier(verifier *SignatureVerifier) Option {
+	return func(gp *GitPoller) {
+		gp.verifier = verifier
+	}
+}
+
+// VerifyCommit checks the signature of the commit hash if signature
+// verification is enabled.
+func (gp *GitPoller) VerifyCommit(hash string) error {
+	if gp.verifier == nil {
+		return nil
+	}
+	commit, err := gp.getCommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	signer, err := gp.verifier.Verify(commit)
+	if err != nil {
+		return err
+	}
+	log.Printf("Commit %s is signed by trusted %s\n", hash, signer)
+	return nil
+}
@@ -0,0 +1,288 @@
+package gitpoller
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+// testCommit returns an unsigned commit and its encoding, the data a signature covers.
+func testCommit(t *testing.T) (*object.Commit, []byte) {
+	t.Helper()
+	signature := object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: time.Unix(1700000000, 0).UTC()}
+	commit := &object.Commit{Author: signature, Committer: signature, Message: "Deploy version 2\n", TreeHash: plumbing.NewHash("4b825dc642cb6eb9a060e54bf8d69288fbee4904")}
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		t.Fatalf("Failed to encode commit: %v", err)
+	}
+	reader, _ := encoded.Reader()
+	content, _ := io.ReadAll(reader)
+	return commit, content
+}
+
+// sshSign signs data for git like `ssh-keygen -Y sign -n git` and returns the armored signature.
+func sshSign(t *testing.T, signer ssh.Signer, namespace string, data []byte) string {
+	t.Helper()
+	hash := sha512.Sum512(data)
+	signedData := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{Namespace: namespace, HashAlgorithm: "sha512", Hash: hash[:]})...)
+	sig, err := signer.Sign(rand.Reader, signedData)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	blob := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignature{
+		Version:       1,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})...)
+	return sshSignatureHeader + "\n" + base64.StdEncoding.EncodeToString(blob) + "\n" + sshSignatureFooter + "\n"
+}
+
+func newSSHSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+// armoredPublicKey returns the armored public key of entity.
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("Failed to armor public key: %v", err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatalf("Failed to serialize public key: %v", err)
+	}
+	w.Close()
+	return buf.String()
+}
+
+func TestNewSignatureVerifier(t *testing.T) {
+	t.Helper()
+	verifier, err := NewSignatureVerifier("", " \n")
+	if err != nil || verifier != nil {
+		t.Errorf("expected verification to be disabled without keys, got %v, %v", verifier, err)
+	}
+	if _, err := NewSignatureVerifier("not a key", ""); err == nil {
+		t.Error("expected an error for an invalid OpenPGP key")
+	}
+	if _, err := NewSignatureVerifier("", "jane@example.com ssh-ed25519 not-base64"); err == nil {
+		t.Error("expected an error for an invalid allowed signers line")
+	}
+
+	key := string(ssh.MarshalAuthorizedKey(newSSHSigner(t).PublicKey()))
+	for _, options := range []string{"cert-authority", "valid-before=\"2024\"", "no-touch-required"} {
+		if _, err := NewSignatureVerifier("", "jane@example.com "+options+" "+key); err == nil {
+			t.Errorf("expected an error for the allowed signers option %s", options)
+		}
+	}
+}
+
+func TestParseAllowedSigners(t *testing.T) {
+	t.Helper()
+	key := string(ssh.MarshalAuthorizedKey(newSSHSigner(t).PublicKey()))
+	signers, err := parseAllowedSigners(`"Jane Doe,jane@example.com"	namespaces="git,file",valid-after="20230101",valid-before="20231231235959Z" ` + key)
+	if err != nil {
+		t.Fatalf("parseAllowedSigners() returned an unexpected error: %v", err)
+	}
+	if len(signers) != 1 {
+		t.Fatalf("expected one signer, got %d", len(signers))
+	}
+	signer := signers[0]
+	if signer.principals != "Jane Doe,jane@example.com" {
+		t.Errorf("expected the quoted principals, got %q", signer.principals)
+	}
+	if len(signer.namespaces) != 2 || signer.namespaces[0] != "git" || signer.namespaces[1] != "file" {
+		t.Errorf("expected namespaces git and file, got %q", signer.namespaces)
+	}
+	if expected := time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC); !signer.validBefore.Equal(expected) {
+		t.Errorf("expected valid-before %s, got %s", expected, signer.validBefore)
+	}
+	if expected := time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local); !signer.validAfter.Equal(expected) {
+		t.Errorf("expected valid-after %s in local time, got %s", expected, signer.validAfter)
+	}
+	for at, valid := range map[time.Time]bool{
+		time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC): false,
+		time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC):   true,
+		time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC):   false,
+	} {
+		if signer.validAt(at) != valid {
+			t.Errorf("expected validAt(%s) to be %v", at, valid)
+		}
+	}
+}
+
+func TestSignatureVerifier_SSH(t *testing.T) {
+	t.Helper()
+	trusted, untrusted, gitOnly, expired := newSSHSigner(t), newSSHSigner(t), newSSHSigner(t), newSSHSigner(t)
+	allowedSigners := "# Release managers\n" +
+		"jane@example.com " + string(ssh.MarshalAuthorizedKey(trusted.PublicKey())) +
+		"ci@example.com namespaces=\"file\" " + string(ssh.MarshalAuthorizedKey(gitOnly.PublicKey())) +
+		"john@example.com valid-before=\"20230101Z\" " + string(ssh.MarshalAuthorizedKey(expired.PublicKey()))
+	verifier, err := NewSignatureVerifier("", allowedSigners)
+	if err != nil {
+		t.Fatalf("NewSignatureVerifier() returned an unexpected error: %v", err)
+	}
+	commit, data := testCommit(t)
+
+	commit.PGPSignature = sshSign(t, trusted, "git", data)
+	signer, err := verifier.Verify(commit)
+	if err != nil {
+		t.Fatalf("Verify() returned an unexpected error for a trusted signature: %v", err)
+	}
+	if !strings.Contains(signer, "jane@example.com") {
+		t.Errorf("expected the signer to name the principal, got %q", signer)
+	}
+
+	tests := map[string]string{
+		"unsigned":            "",
+		"untrusted key":       sshSign(t, untrusted, "git", data),
+		"other namespace":     sshSign(t, trusted, "file", data),
+		"key not for git":     sshSign(t, gitOnly, "git", data),
+		"expired key":         sshSign(t, expired, "git", data),
+		"signature of other":  sshSign(t, trusted, "git", append(data, '!')),
+		"unsupported format":  "-----BEGIN SIGNED MESSAGE-----\n",
+		"no OpenPGP keys":     openPGPSignatureHeader + "\n",
+		"malformed signature": sshSignatureHeader + "\nU1NIU0lH\n" + sshSignatureFooter,
+	}
+	for name, signature := range tests {
+		commit.PGPSignature = signature
+		if _, err := verifier.Verify(commit); !errors.Is(err, ErrUntrustedCommit) {
+			t.Errorf("%s: expected ErrUntrustedCommit, got %v", name, err)
+		}
+	}
+}
+
+func TestSignatureVerifier_OpenPGP(t *testing.T) {
+	t.Helper()
+	trusted, err := openpgp.NewEntity("Jane Doe", "", "jane@example.com", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	untrusted, err := openpgp.NewEntity("Mallory", "", "mallory@example.com", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	verifier, err := NewSignatureVerifier(armoredPublicKey(t, trusted), "")
+	if err != nil {
+		t.Fatalf("NewSignatureVerifier() returned an unexpected error: %v", err)
+	}
+	commit, data := testCommit(t)
+	sign := func(entity *openpgp.Entity) string {
+		var buf bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&buf, entity, bytes.NewReader(data), nil); err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		return buf.String()
+	}
+
+	commit.PGPSignature = sign(trusted)
+	signer, err := verifier.Verify(commit)
+	if err != nil {
+		t.Fatalf("Verify() returned an unexpected error for a trusted signature: %v", err)
+	}
+	if !strings.Contains(signer, "jane@example.com") {
+		t.Errorf("expected the signer to name the key owner, got %q", signer)
+	}
+
+	commit.PGPSignature = sign(untrusted)
+	if _, err := verifier.Verify(commit); !errors.Is(err, ErrUntrustedCommit) {
+		t.Errorf("expected ErrUntrustedCommit for an untrusted key, got %v", err)
+	}
+}
+
+func TestPoll_SignatureVerification(t *testing.T) {
+	t.Helper()
+	key, err := openpgp.NewEntity("Jane Doe", "", "jane@example.com", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	verifier, err := NewSignatureVerifier(armoredPublicKey(t, key), "")
+	if err != nil {
+		t.Fatalf("NewSignatureVerifier() returned an unexpected error: %v", err)
+	}
+
+	remoteDir := t.TempDir()
+	remote, err := git.PlainInit(remoteDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	w, err := remote.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get worktree: %v", err)
+	}
+	commitSigned := func(content string) string {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(remoteDir, "app.yaml"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write app.yaml: %v", err)
+		}
+		if _, err := w.Add("app.yaml"); err != nil {
+			t.Fatalf("Failed to add app.yaml: %v", err)
+		}
+		hash, err := w.Commit("Signed release", &git.CommitOptions{
+			Author:  &object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: time.Now()},
+			SignKey: key,
+		})
+		if err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+		return hash.String()
+	}
+	signed := commitSigned("version: 1\n")
+
+	localDir := filepath.Join(t.TempDir(), "clone")
+	poller, err := NewGitPoller(remoteDir, "master", localDir, ".", WithSignatureVerifier(verifier))
+	if err != nil {
+		t.Fatalf("NewGitPoller() returned an unexpected error: %v", err)
+	}
+	if err := poller.InitializeRepo(); err != nil {
+		t.Fatalf("InitializeRepo() returned an unexpected error: %v", err)
+	}
+	if changed, commitHash, _, err := poller.Poll(); err != nil || !changed || commitHash != signed {
+		t.Fatalf("expected the signed commit %s to be reported, got changed %t, commit %s, error %v", signed, changed, commitHash, err)
+	}
+
+	unsigned := commitFile(t, remote, remoteDir, "app.yaml", "version: 2\n", "Unsigned change")
+	changed, commitHash, _, err := poller.Poll()
+	if !errors.Is(err, ErrUntrustedCommit) || changed || commitHash != "" {
+		t.Fatalf("expected unsigned commit %s to be refused, got changed %t, commit %s, error %v", unsigned, changed, commitHash, err)
+	}
+	if poller.LastCommitHash() != signed {
+		t.Errorf("expected the last commit to stay at %s, got %s", signed, poller.LastCommitHash())
+	}
+	content, err := os.ReadFile(filepath.Join(localDir, "app.yaml"))
+	if err != nil || string(content) != "version: 1\n" {
+		t.Errorf("expected the working tree to be restored to the signed commit, got %q (%v)", content, err)
+	}
+
+	signedAgain := commitSigned("version: 3\n")
+	if changed, commitHash, _, err := poller.Poll(); err != nil || !changed || commitHash != signedAgain {
+		t.Errorf("expected the next signed commit %s to be reported, got changed %t, commit %s, error %v", signedAgain, changed, commitHash, err)
+	}
+}
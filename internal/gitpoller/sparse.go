@@ -0,0 +1,141 @@
+package gitpoller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// sparseCheckout is the set of directories written to the working tree of a
+// sparse clone. It is shared by the GitPollers sharing the clone.
+type sparseCheckout struct {
+	dirs   []string      // Slash-separated paths within the repository; "." is the whole tree
+	commit plumbing.Hash // Commit whose directories were written last; zero after a directory was added
+}
+
+// WithSparseCheckout restricts the working tree to the manifest directory,
+// and the manifest directories of the shares of the GitPoller, if enabled.
+// Everything else is only present in the Git objects, so kustomizations and
+// charts must not refer to files outside their directory.
+func WithSparseCheckout(enabled bool) Option {
+	return func(gp *GitPoller) {
+		if enabled {
+			gp.sparse = &sparseCheckout{}
+			gp.sparse.add(gp.manifestPathInRepo)
+		}
+	}
+}
+
+// add adds dir, a path within the repository, to the directories checked out.
+// It is written by the next checkout.
+func (s *sparseCheckout) add(dir string) {
+	dir = path.Clean(filepath.ToSlash(dir))
+	for _, existing := range s.dirs {
+		if existing == dir {
+			return
+		}
+	}
+	s.dirs = append(s.dirs, dir)
+	s.commit = plumbing.ZeroHash
+}
+
+// checkoutObjects points HEAD at the commit hash, through branch unless it is
+// empty, for clones that do not use the go-git worktree; see usesWorktree. A
+// sparse clone then gets the sparse directories of that commit written to its
+// working tree; files outside them are never written, and the index is left
+// alone. A bare clone has no working tree, so only the references move.
+func (gp *GitPoller) checkoutObjects(hash plumbing.Hash, branch plumbing.ReferenceName) error {
+	head := plumbing.NewHashReference(plumbing.HEAD, hash)
+	if branch != "" {
+		if err := gp.repository.Storer.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+			return fmt.Errorf("failed to update branch %s: %w", branch.Short(), err)
+		}
+		head = plumbing.NewSymbolicReference(plumbing.HEAD, branch)
+	}
+	if err := gp.repository.Storer.SetReference(head); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	if gp.bare || gp.sparse.commit == hash {
+		return nil
+	}
+
+	tree, err := gp.commitTree(hash.String())
+	if err != nil {
+		return err
+	}
+	for _, dir := range gp.sparse.dirs {
+		if err := gp.writeSparseDir(tree, dir); err != nil {
+			return fmt.Errorf("failed to check out %s of commit %s: %w", dir, hash, err)
+		}
+	}
+	gp.sparse.commit = hash
+	return nil
+}
+
+// writeSparseDir replaces the directory dir of the working tree with its
+// content in tree. A directory missing from tree is only removed.
+func (gp *GitPoller) writeSparseDir(tree *object.Tree, dir string) error {
+	if dir == "." {
+		entries, err := os.ReadDir(gp.localPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Name() == ".git" {
+				continue
+			}
+			if err := os.RemoveAll(gp.clonePath(entry.Name())); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := os.RemoveAll(gp.clonePath(dir)); err != nil {
+			return err
+		}
+		subtree, err := tree.Tree(dir)
+		if errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		tree = subtree
+	}
+	return tree.Files().ForEach(func(file *object.File) error {
+		target := gp.clonePath(path.Join(dir, file.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		reader, err := file.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		if file.Mode == filemode.Symlink {
+			link, err := io.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(string(link), target)
+		}
+		perm := os.FileMode(0644)
+		if file.Mode == filemode.Executable {
+			perm = 0755
+		}
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, reader); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,54 @@
+package kubehandler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// crdEstablishTimeout bounds how long ApplyManifests waits for a newly applied
+// CustomResourceDefinition to be served before moving on.
+const crdEstablishTimeout = 60 * time.Second
+
+var crdGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// isCRD reports whether obj is a CustomResourceDefinition.
+func isCRD(obj *unstructured.Unstructured) bool {
+	return obj.GroupVersionKind().GroupKind() == schema.GroupKind{Group: crdGVR.Group, Kind: "CustomResourceDefinition"}
+}
+
+// waitForCRDs waits until every named CustomResourceDefinition reports the
+// Established condition and then drops cached discovery information, so the new
+// custom resource kinds can be resolved. CRDs that do not become established in
+// time are logged; applying their instances will then fail with a discovery error.
+func (kh *KubeHandler) waitForCRDs(names []string) {
+	ctx, cancel := context.WithTimeout(context.TODO(), crdEstablishTimeout)
+	defer cancel()
+
+	for _, name := range names {
+		log.Printf("Waiting for CustomResourceDefinition %s to become established...", name)
+		err := wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+			crd, err := kh.dynamicClient.Resource(crdGVR).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, nil // Retry until the timeout, the CRD may not be visible yet
+			}
+			status, _, _, _ := findCondition(crd, "Established")
+			return status == "True", nil
+		})
+		if err != nil {
+			log.Printf("CustomResourceDefinition %s was not established within %s", name, crdEstablishTimeout)
+			continue
+		}
+		log.Printf("CustomResourceDefinition %s is established", name)
+	}
+
+	if resettable, ok := kh.restMapper.(meta.ResettableRESTMapper); ok {
+		resettable.Reset()
+	}
+}
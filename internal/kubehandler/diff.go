@@ -0,0 +1,303 @@
+package kubehandler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change.
+const diffContextLines = 3
+
+// ResourceDiff is the dry-run comparison of one manifest document with its live object.
+type ResourceDiff struct {
+	Key    ResourceKey
+	New    bool     // The object does not exist in the cluster yet
+	Diff   string   // Unified diff from the live object to the dry-run result; empty when unchanged
+	Fields []string // Dotted paths of the fields that differ, e.g. "spec.replicas"
+}
+
+// Changed reports whether applying the document would modify the cluster.
+func (d ResourceDiff) Changed() bool {
+	return d.New || d.Diff != ""
+}
+
+// DiffManifestFile performs a Server-Side Apply dry run (DryRun: All) for every
+// document in filePath and compares the object returned by the API server with
+// the live object. Nothing is persisted in the cluster. Documents that cannot be
+// decoded or dry-run are reported in the returned error, the others are diffed.
+func (kh *KubeHandler) DiffManifestFile(filePath string) ([]ResourceDiff, error) {
+	log.Printf("Diffing manifest file: %s\n", filePath)
+	docs, diffErrors, err := readManifestDocuments(filePath, nil, LoadOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	diffs, docErrors := kh.DiffManifests(docs)
+	diffErrors = append(diffErrors, docErrors...)
+	if len(diffErrors) > 0 {
+		return diffs, fmt.Errorf("encountered errors during manifest diff:\n - %s", joinManifestErrors(diffErrors))
+	}
+	return diffs, nil
+}
+
+// DiffManifests dry-runs every manifest with Server-Side Apply and compares the
+// result with the live object, as DiffManifestFile does for a single file.
+func (kh *KubeHandler) DiffManifests(manifests []Manifest) ([]ResourceDiff, []ManifestError) {
+	var diffs []ResourceDiff
+	var diffErrors []ManifestError
+	for _, m := range manifests {
+		obj := m.Object
+		gvk := obj.GroupVersionKind()
+
+		key, dr, err := kh.resourceInterface(obj)
+		if err != nil {
+			diffErrors = append(diffErrors, m.errorf("GVK %s: %v", gvk, err))
+			continue
+		}
+
+		live, err := dr.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			live = nil
+		} else if err != nil {
+			diffErrors = append(diffErrors, m.errorf("(%s %s): failed to get live object: %v", obj.GetKind(), obj.GetName(), err))
+			continue
+		}
+
+		merged, err := kh.applyObject(dr, obj, key, true)
+		if err != nil {
+			diffErrors = append(diffErrors, m.errorf("(%s %s): dry-run apply failed: %v", obj.GetKind(), obj.GetName(), err))
+			continue
+		}
+
+		live, merged = normalizeObject(live), normalizeObject(merged)
+		maskSecretValues(live, merged)
+		liveYAML, err := renderYAML(live)
+		if err != nil {
+			diffErrors = append(diffErrors, m.errorf("(%s %s): %v", obj.GetKind(), obj.GetName(), err))
+			continue
+		}
+		mergedYAML, err := renderYAML(merged)
+		if err != nil {
+			diffErrors = append(diffErrors, m.errorf("(%s %s): %v", obj.GetKind(), obj.GetName(), err))
+			continue
+		}
+
+		d := ResourceDiff{
+			Key:  key,
+			New:  live == nil,
+			Diff: unifiedDiff(liveYAML, mergedYAML, "live/"+key.String(), "desired/"+key.String()),
+		}
+		if live != nil {
+			d.Fields = changedFields(live.Object, merged.Object, "")
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, diffErrors
+}
+
+// normalizeObject returns a copy of obj without the server-populated fields that
+// change on every write and would otherwise show up in every diff. A nil obj
+// stays nil.
+func normalizeObject(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj == nil {
+		return nil
+	}
+	obj = obj.DeepCopy()
+	for _, field := range []string{"managedFields", "resourceVersion", "generation", "uid", "creationTimestamp"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(obj.Object, "status")
+	return obj
+}
+
+// Masks shown in diffs instead of the values of Secrets.
+const (
+	secretMask        = "********"
+	secretMaskBefore  = "******** (before)"
+	secretMaskChanged = "******** (changed)"
+)
+
+// maskSecretValues replaces the values in data and stringData of the Secret
+// desired, and of its live version, with masks so diffs never show them. Values
+// that differ between both get different masks, so that the change still
+// shows up. A nil live object is allowed.
+func maskSecretValues(live, desired *unstructured.Unstructured) {
+	if desired == nil || desired.GetKind() != "Secret" || desired.GroupVersionKind().Group != "" {
+		return
+	}
+	for _, field := range []string{"data", "stringData"} {
+		desiredValues, _, _ := unstructured.NestedMap(desired.Object, field)
+		var liveValues map[string]interface{}
+		if live != nil {
+			liveValues, _, _ = unstructured.NestedMap(live.Object, field)
+		}
+		for key, value := range desiredValues {
+			liveValue, ok := liveValues[key]
+			switch {
+			case !ok:
+				desiredValues[key] = secretMask
+			case reflect.DeepEqual(liveValue, value):
+				desiredValues[key], liveValues[key] = secretMask, secretMask
+			default:
+				desiredValues[key], liveValues[key] = secretMaskChanged, secretMaskBefore
+			}
+		}
+		for key := range liveValues {
+			if _, ok := desiredValues[key]; !ok {
+				liveValues[key] = secretMask
+			}
+		}
+		if desiredValues != nil {
+			_ = unstructured.SetNestedMap(desired.Object, desiredValues, field)
+		}
+		if liveValues != nil {
+			_ = unstructured.SetNestedMap(live.Object, liveValues, field)
+		}
+	}
+}
+
+// renderYAML renders obj as YAML; a nil obj renders as an empty document.
+func renderYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	out, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", fmt.Errorf("failed to render object as YAML: %w", err)
+	}
+	return string(out), nil
+}
+
+// changedFields returns the sorted dotted paths at which from and to differ.
+// Maps are compared key by key; any other differing value, including lists, is
+// reported at its own path.
+func changedFields(from, to map[string]interface{}, prefix string) []string {
+	var fields []string
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			fields = append(fields, prefix+key)
+		}
+	}
+	for key, toValue := range to {
+		fromValue, ok := from[key]
+		switch {
+		case !ok:
+			fields = append(fields, prefix+key)
+		case reflect.DeepEqual(fromValue, toValue):
+		default:
+			fromMap, fromIsMap := fromValue.(map[string]interface{})
+			toMap, toIsMap := toValue.(map[string]interface{})
+			if fromIsMap && toIsMap {
+				fields = append(fields, changedFields(fromMap, toMap, prefix+key+".")...)
+			} else {
+				fields = append(fields, prefix+key)
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// diffLine is one line of a line-based diff, prefixed with ' ', '-' or '+'.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns a unified diff turning from into to, or an empty string
+// if both are equal.
+func unifiedDiff(from, to, fromName, toName string) string {
+	if from == to {
+		return ""
+	}
+
+	dmp := diffmatchpatch.New()
+	fromChars, toChars, lineArray := dmp.DiffLinesToChars(from, to)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(fromChars, toChars, false), lineArray)
+
+	var lines []diffLine
+	for _, d := range diffs {
+		op := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			op = '-'
+		case diffmatchpatch.DiffInsert:
+			op = '+'
+		}
+		for _, text := range strings.SplitAfter(d.Text, "\n") {
+			if text != "" {
+				lines = append(lines, diffLine{op: op, text: strings.TrimSuffix(text, "\n")})
+			}
+		}
+	}
+
+	// fromPos/toPos hold the number of from/to lines preceding each diff line.
+	fromPos := make([]int, len(lines)+1)
+	toPos := make([]int, len(lines)+1)
+	for i, l := range lines {
+		fromPos[i+1], toPos[i+1] = fromPos[i], toPos[i]
+		if l.op != '+' {
+			fromPos[i+1]++
+		}
+		if l.op != '-' {
+			toPos[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for i := 0; i < len(lines); {
+		for i < len(lines) && lines[i].op == ' ' {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+
+		// Extend the hunk over changes separated by at most two context windows.
+		end := i
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContextLines {
+				break
+			}
+			end = next
+		}
+
+		start := max(i-diffContextLines, 0)
+		stop := min(end+diffContextLines, len(lines))
+		fromStart, toStart := fromPos[start], toPos[start]
+		fromCount, toCount := fromPos[stop]-fromStart, toPos[stop]-toStart
+		if fromCount > 0 {
+			fromStart++
+		}
+		if toCount > 0 {
+			toStart++
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+		for _, l := range lines[start:stop] {
+			out.WriteByte(l.op)
+			out.WriteString(l.text)
+			out.WriteByte('\n')
+		}
+		i = stop
+	}
+	return out.String()
+}
@@ -0,0 +1,100 @@
+package kubehandler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	t.Helper()
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	to := "a\nb\nc\nd\nE\nf\ng\nh\ni\nj\nk\n"
+
+	got := unifiedDiff(from, to, "live", "desired")
+	expected := strings.Join([]string{
+		"--- live",
+		"+++ desired",
+		"@@ -2,9 +2,10 @@",
+		" b",
+		" c",
+		" d",
+		"-e",
+		"+E",
+		" f",
+		" g",
+		" h",
+		" i",
+		" j",
+		"+k",
+		"",
+	}, "\n")
+	if got != expected {
+		t.Errorf("unifiedDiff() returned:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	if diff := unifiedDiff(from, from, "live", "desired"); diff != "" {
+		t.Errorf("Expected no diff for identical input, got:\n%s", diff)
+	}
+}
+
+func TestUnifiedDiff_NewObject(t *testing.T) {
+	t.Helper()
+	got := unifiedDiff("", "kind: ConfigMap\n", "live", "desired")
+	expected := "--- live\n+++ desired\n@@ -0,0 +1,1 @@\n+kind: ConfigMap\n"
+	if got != expected {
+		t.Errorf("unifiedDiff() returned %q, expected %q", got, expected)
+	}
+}
+
+func TestNormalizeObject_StripsServerFields(t *testing.T) {
+	t.Helper()
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":            "settings",
+			"resourceVersion": "42",
+			"uid":             "1234",
+			"managedFields":   []interface{}{map[string]interface{}{"manager": "go-argo-lite"}},
+		},
+		"data":   map[string]interface{}{"key": "value"},
+		"status": map[string]interface{}{"phase": "Active"},
+	}}
+
+	out, err := renderYAML(normalizeObject(obj))
+	if err != nil {
+		t.Fatalf("renderYAML() returned an unexpected error: %v", err)
+	}
+	for _, unwanted := range []string{"resourceVersion", "uid", "managedFields", "status"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("Expected %q to be stripped, got:\n%s", unwanted, out)
+		}
+	}
+	if !strings.Contains(out, "key: value") || !strings.Contains(out, "name: settings") {
+		t.Errorf("Expected object content to be preserved, got:\n%s", out)
+	}
+	if _, ok := obj.Object["status"]; !ok {
+		t.Errorf("normalizeObject() must not modify its input")
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	t.Helper()
+	live := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web", "labels": map[string]interface{}{"tier": "frontend"}},
+		"spec":     map[string]interface{}{"replicas": int64(5), "paused": true, "ports": []interface{}{int64(80)}},
+	}
+	desired := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web", "labels": map[string]interface{}{"tier": "frontend"}},
+		"spec":     map[string]interface{}{"replicas": int64(3), "ports": []interface{}{int64(80), int64(443)}, "strategy": "Recreate"},
+	}
+
+	got := changedFields(live, desired, "")
+	expected := []string{"spec.paused", "spec.ports", "spec.replicas", "spec.strategy"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("changedFields() = %v, expected %v", got, expected)
+	}
+}
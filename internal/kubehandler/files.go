@@ -0,0 +1,211 @@
+package kubehandler
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// fileContents holds the contents of the files manifests are loaded from,
+// keyed by path, for manifests that are not on disk. A nil fileContents reads
+// the files from disk.
+type fileContents map[string][]byte
+
+// readFile returns the content of the file at filePath.
+func (c fileContents) readFile(filePath string) ([]byte, error) {
+	if c == nil {
+		return os.ReadFile(filePath)
+	}
+	content, ok := c[filepath.Clean(filePath)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: filePath, Err: fs.ErrNotExist}
+	}
+	return content, nil
+}
+
+// within returns the files in dir or its subdirectories, keyed by their
+// slash-separated path relative to dir, and their sorted relative paths.
+func (c fileContents) within(dir string) (map[string][]byte, []string) {
+	files := make(map[string][]byte)
+	var names []string
+	for filePath, content := range c {
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		name := filepath.ToSlash(rel)
+		files[name] = content
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return files, names
+}
+
+// kustomizeFS returns the file system a kustomization in dir is built from and
+// the path of dir in it: the disk below root, or dir itself if root is empty,
+// or an in-memory file system holding the files in dir.
+func (c fileContents) kustomizeFS(dir, root string) (filesys.FileSystem, string, error) {
+	if c == nil {
+		if root == "" {
+			root = dir
+		}
+		fsys, err := newConfinedFS(root)
+		if err != nil {
+			return nil, "", err
+		}
+		return fsys, dir, nil
+	}
+	fsys := filesys.MakeFsInMemory()
+	files, names := c.within(dir)
+	for _, name := range names {
+		if err := fsys.WriteFile("/"+name, files[name]); err != nil {
+			return nil, "", err
+		}
+	}
+	return fsys, "/", nil
+}
+
+// confinedFS is the file system on disk restricted to the directory root:
+// paths outside it, also through symbolic links, cannot be read or written.
+type confinedFS struct {
+	filesys.FileSystem
+	root string // Absolute path without symbolic links
+}
+
+// newConfinedFS returns the file system on disk below root.
+func newConfinedFS(root string) (*confinedFS, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return nil, err
+	}
+	return &confinedFS{FileSystem: filesys.MakeFsOnDisk(), root: abs}, nil
+}
+
+// check returns an error if path is outside the root of fsys.
+func (fsys *confinedFS) check(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	rel, err := filepath.Rel(fsys.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of %s", path, fsys.root)
+	}
+	return nil
+}
+
+func (fsys *confinedFS) Create(path string) (filesys.File, error) {
+	if err := fsys.check(path); err != nil {
+		return nil, err
+	}
+	return fsys.FileSystem.Create(path)
+}
+
+func (fsys *confinedFS) Mkdir(path string) error {
+	if err := fsys.check(path); err != nil {
+		return err
+	}
+	return fsys.FileSystem.Mkdir(path)
+}
+
+func (fsys *confinedFS) MkdirAll(path string) error {
+	if err := fsys.check(path); err != nil {
+		return err
+	}
+	return fsys.FileSystem.MkdirAll(path)
+}
+
+func (fsys *confinedFS) RemoveAll(path string) error {
+	if err := fsys.check(path); err != nil {
+		return err
+	}
+	return fsys.FileSystem.RemoveAll(path)
+}
+
+func (fsys *confinedFS) Open(path string) (filesys.File, error) {
+	if err := fsys.check(path); err != nil {
+		return nil, err
+	}
+	return fsys.FileSystem.Open(path)
+}
+
+func (fsys *confinedFS) IsDir(path string) bool {
+	return fsys.check(path) == nil && fsys.FileSystem.IsDir(path)
+}
+
+func (fsys *confinedFS) ReadDir(path string) ([]string, error) {
+	if err := fsys.check(path); err != nil {
+		return nil, err
+	}
+	return fsys.FileSystem.ReadDir(path)
+}
+
+func (fsys *confinedFS) CleanedAbs(path string) (filesys.ConfirmedDir, string, error) {
+	if err := fsys.check(path); err != nil {
+		return "", "", err
+	}
+	return fsys.FileSystem.CleanedAbs(path)
+}
+
+func (fsys *confinedFS) Exists(path string) bool {
+	return fsys.check(path) == nil && fsys.FileSystem.Exists(path)
+}
+
+func (fsys *confinedFS) Glob(pattern string) ([]string, error) {
+	matches, err := fsys.FileSystem.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var confined []string
+	for _, match := range matches {
+		if fsys.check(match) == nil {
+			confined = append(confined, match)
+		}
+	}
+	return confined, nil
+}
+
+func (fsys *confinedFS) ReadFile(path string) ([]byte, error) {
+	if err := fsys.check(path); err != nil {
+		return nil, err
+	}
+	return fsys.FileSystem.ReadFile(path)
+}
+
+func (fsys *confinedFS) WriteFile(path string, data []byte) error {
+	if err := fsys.check(path); err != nil {
+		return err
+	}
+	return fsys.FileSystem.WriteFile(path, data)
+}
+
+// Walk does not follow symbolic links, so only its start is checked.
+func (fsys *confinedFS) Walk(path string, walkFn filepath.WalkFunc) error {
+	if err := fsys.check(path); err != nil {
+		return err
+	}
+	return fsys.FileSystem.Walk(path, walkFn)
+}
+
+// chartFiles returns the files of the Helm chart in dir for loading from
+// memory; see loader.LoadFiles.
+func (c fileContents) chartFiles(dir string) []*loader.BufferedFile {
+	files, names := c.within(dir)
+	chartFiles := make([]*loader.BufferedFile, len(names))
+	for i, name := range names {
+		chartFiles[i] = &loader.BufferedFile{Name: name, Data: files[name]}
+	}
+	return chartFiles
+}
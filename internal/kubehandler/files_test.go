@@ -0,0 +1,47 @@
+package kubehandler
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadManifestContents(t *testing.T) {
+	t.Helper()
+	dir := filepath.Join("/clone", "manifests")
+	contents := map[string][]byte{
+		filepath.Join(dir, "app.yaml"):   []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n"),
+		filepath.Join(dir, "bad.json"):   []byte("{"),
+		filepath.Join("/elsewhere.yaml"): []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: outside\n"),
+	}
+	files := []string{filepath.Join(dir, "app.yaml"), filepath.Join(dir, "bad.json"), filepath.Join(dir, "missing.yaml")}
+	manifests, loadErrors := LoadManifestContents(files, contents, LoadOptions{Namespace: "prod"})
+	if len(manifests) != 1 || manifests[0].Source != files[0] || manifests[0].Object.GetNamespace() != "prod" {
+		t.Fatalf("expected prod/settings from %s, got %+v", files[0], manifests)
+	}
+	if len(loadErrors) != 2 {
+		t.Errorf("expected errors for the invalid and the missing file, got %v", loadErrors)
+	}
+
+	contents[filepath.Join(dir, "kustomization.yaml")] = []byte("resources:\n- app.yaml\nnamePrefix: prod-\n")
+	kustomization := []string{filepath.Join(dir, "kustomization.yaml")}
+	manifests, loadErrors = LoadManifestContents(kustomization, contents, LoadOptions{})
+	if len(loadErrors) != 0 || len(manifests) != 1 || manifests[0].Object.GetName() != "prod-settings" {
+		t.Errorf("expected the kustomization to render prod-settings, got %+v (%v)", manifests, loadErrors)
+	}
+
+	chartDir := filepath.Join("/clone", "chart")
+	contents = map[string][]byte{
+		filepath.Join(chartDir, "Chart.yaml"):               []byte("apiVersion: v2\nname: web\nversion: 0.1.0\n"),
+		filepath.Join(chartDir, "values.yaml"):              []byte("mode: base\n"),
+		filepath.Join(chartDir, "prod.yaml"):                []byte("mode: prod\n"),
+		filepath.Join(chartDir, "templates", "config.yaml"): []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  mode: {{ .Values.mode }}\n"),
+	}
+	opts := LoadOptions{Helm: HelmOptions{ReleaseName: "shop", ValuesFiles: []string{"prod.yaml"}}}
+	manifests, loadErrors = LoadManifestContents([]string{filepath.Join(chartDir, "Chart.yaml")}, contents, opts)
+	if len(loadErrors) != 0 || len(manifests) != 1 || manifests[0].Object.GetName() != "shop" {
+		t.Fatalf("expected the chart to render shop, got %+v (%v)", manifests, loadErrors)
+	}
+	if mode := manifests[0].Object.Object["data"].(map[string]interface{})["mode"]; mode != "prod" {
+		t.Errorf("expected the values file to set mode to prod, got %v", mode)
+	}
+}
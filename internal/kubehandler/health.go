@@ -0,0 +1,311 @@
+package kubehandler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// healthPollInterval is how often WaitForHealthy re-reads the watched objects.
+const healthPollInterval = 2 * time.Second
+
+// HealthStatus classifies the state of a live object.
+type HealthStatus string
+
+const (
+	HealthHealthy     HealthStatus = "Healthy"
+	HealthProgressing HealthStatus = "Progressing"
+	HealthDegraded    HealthStatus = "Degraded"
+	HealthMissing     HealthStatus = "Missing"
+)
+
+// healthSeverity orders statuses from best to worst for aggregation.
+var healthSeverity = map[HealthStatus]int{
+	HealthHealthy:     0,
+	HealthProgressing: 1,
+	HealthMissing:     2,
+	HealthDegraded:    3,
+}
+
+// ResourceHealth is the assessed health of one object.
+type ResourceHealth struct {
+	Key     ResourceKey
+	Status  HealthStatus
+	Message string
+}
+
+// AggregateHealth returns the worst status among resources, or Healthy if there are none.
+func AggregateHealth(resources []ResourceHealth) HealthStatus {
+	worst := HealthHealthy
+	for _, r := range resources {
+		if healthSeverity[r.Status] > healthSeverity[worst] {
+			worst = r.Status
+		}
+	}
+	return worst
+}
+
+// WaitForHealthy polls the objects identified by keys until none of them is
+// Progressing or Missing, until timeout expires or until ctx is canceled, and
+// returns the last assessment of each object.
+func (kh *KubeHandler) WaitForHealthy(ctx context.Context, keys []ResourceKey, timeout time.Duration) []ResourceHealth {
+	results := make([]ResourceHealth, len(keys))
+	for i, key := range keys {
+		results[i] = ResourceHealth{Key: key, Status: HealthProgressing, Message: "not assessed yet"}
+	}
+
+	err := wait.PollUntilContextTimeout(ctx, healthPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		settled := true
+		for i, key := range keys {
+			if results[i].Status == HealthHealthy {
+				continue
+			}
+			results[i] = kh.resourceHealth(ctx, key)
+			if results[i].Status == HealthProgressing || results[i].Status == HealthMissing {
+				settled = false
+			}
+		}
+		return settled, nil
+	})
+	switch {
+	case ctx.Err() != nil:
+		log.Printf("Stopped waiting for resources to become healthy: %v", ctx.Err())
+	case err != nil:
+		log.Printf("Timed out after %s waiting for resources to become healthy", timeout)
+	}
+
+	for _, r := range results {
+		if r.Status != HealthHealthy {
+			log.Printf("%s is %s: %s", r.Key, r.Status, r.Message)
+		}
+	}
+	return results
+}
+
+// resourceHealth fetches the live object for key and assesses it.
+func (kh *KubeHandler) resourceHealth(ctx context.Context, key ResourceKey) ResourceHealth {
+	mapping, err := kh.restMapper.RESTMapping(schema.GroupKind{Group: key.Group, Kind: key.Kind})
+	if err != nil {
+		return ResourceHealth{Key: key, Status: HealthProgressing, Message: fmt.Sprintf("failed to resolve resource: %v", err)}
+	}
+
+	live, err := kh.dynamicClient.Resource(mapping.Resource).Namespace(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ResourceHealth{Key: key, Status: HealthMissing, Message: "object not found"}
+	}
+	if err != nil {
+		return ResourceHealth{Key: key, Status: HealthProgressing, Message: fmt.Sprintf("failed to get object: %v", err)}
+	}
+
+	status, message := AssessHealth(live)
+	return ResourceHealth{Key: key, Status: status, Message: message}
+}
+
+// AssessHealth classifies a live object using kind-specific rules, falling back
+// to its Ready or Available condition. Objects without any status information
+// are considered Healthy as soon as they exist.
+func AssessHealth(obj *unstructured.Unstructured) (HealthStatus, string) {
+	if obj.GetDeletionTimestamp() != nil {
+		return HealthProgressing, "object is being deleted"
+	}
+
+	gk := obj.GroupVersionKind().GroupKind()
+	switch gk {
+	case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
+		return deploymentHealth(obj)
+	case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+		return statefulSetHealth(obj)
+	case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}:
+		return daemonSetHealth(obj)
+	case schema.GroupKind{Group: "batch", Kind: "Job"}:
+		return jobHealth(obj)
+	case schema.GroupKind{Kind: "Pod"}:
+		return podHealth(obj)
+	case schema.GroupKind{Kind: "PersistentVolumeClaim"}:
+		return pvcHealth(obj)
+	case schema.GroupKind{Kind: "Service"}:
+		return serviceHealth(obj)
+	}
+	return conditionHealth(obj)
+}
+
+// generationObserved reports whether the controller has seen the latest spec.
+func generationObserved(obj *unstructured.Unstructured) bool {
+	observed, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	return found && observed >= obj.GetGeneration()
+}
+
+// statusInt returns an integer field from .status, or 0 when it is absent.
+func statusInt(obj *unstructured.Unstructured, field string) int64 {
+	value, _, _ := unstructured.NestedInt64(obj.Object, "status", field)
+	return value
+}
+
+// specReplicas returns .spec.replicas, defaulting to 1 like the API server does.
+func specReplicas(obj *unstructured.Unstructured) int64 {
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		return 1
+	}
+	return replicas
+}
+
+// findCondition returns the status, reason and message of the condition of the given type.
+func findCondition(obj *unstructured.Unstructured, conditionType string) (status, reason, message string, found bool) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			continue
+		}
+		status, _ = condition["status"].(string)
+		reason, _ = condition["reason"].(string)
+		message, _ = condition["message"].(string)
+		return status, reason, message, true
+	}
+	return "", "", "", false
+}
+
+func deploymentHealth(obj *unstructured.Unstructured) (HealthStatus, string) {
+	if !generationObserved(obj) {
+		return HealthProgressing, "waiting for rollout to be observed"
+	}
+	if _, reason, message, _ := findCondition(obj, "Progressing"); reason == "ProgressDeadlineExceeded" {
+		return HealthDegraded, message
+	}
+
+	replicas := specReplicas(obj)
+	updated := statusInt(obj, "updatedReplicas")
+	switch {
+	case updated < replicas:
+		return HealthProgressing, fmt.Sprintf("%d of %d updated replicas are rolled out", updated, replicas)
+	case statusInt(obj, "replicas") > updated:
+		return HealthProgressing, fmt.Sprintf("%d old replicas are pending termination", statusInt(obj, "replicas")-updated)
+	case statusInt(obj, "availableReplicas") < updated:
+		return HealthProgressing, fmt.Sprintf("%d of %d updated replicas are available", statusInt(obj, "availableReplicas"), updated)
+	}
+	return HealthHealthy, "rollout complete"
+}
+
+func statefulSetHealth(obj *unstructured.Unstructured) (HealthStatus, string) {
+	if !generationObserved(obj) {
+		return HealthProgressing, "waiting for rollout to be observed"
+	}
+	if strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type"); strategy == "OnDelete" {
+		return HealthHealthy, "update strategy is OnDelete, rollout is not tracked"
+	}
+
+	replicas := specReplicas(obj)
+	if ready := statusInt(obj, "readyReplicas"); ready < replicas {
+		return HealthProgressing, fmt.Sprintf("%d of %d replicas are ready", ready, replicas)
+	}
+	if partition, found, _ := unstructured.NestedInt64(obj.Object, "spec", "updateStrategy", "rollingUpdate", "partition"); found && partition > 0 {
+		if updated := statusInt(obj, "updatedReplicas"); updated < replicas-partition {
+			return HealthProgressing, fmt.Sprintf("%d of %d replicas above the partition are updated", updated, replicas-partition)
+		}
+		return HealthHealthy, "partitioned rollout complete"
+	}
+	current, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+	update, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+	if current != update {
+		return HealthProgressing, fmt.Sprintf("waiting for revision %s to replace %s", update, current)
+	}
+	return HealthHealthy, "rollout complete"
+}
+
+func daemonSetHealth(obj *unstructured.Unstructured) (HealthStatus, string) {
+	if !generationObserved(obj) {
+		return HealthProgressing, "waiting for rollout to be observed"
+	}
+	if strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type"); strategy == "OnDelete" {
+		return HealthHealthy, "update strategy is OnDelete, rollout is not tracked"
+	}
+
+	desired := statusInt(obj, "desiredNumberScheduled")
+	if updated := statusInt(obj, "updatedNumberScheduled"); updated < desired {
+		return HealthProgressing, fmt.Sprintf("%d of %d pods are updated", updated, desired)
+	}
+	if available := statusInt(obj, "numberAvailable"); available < desired {
+		return HealthProgressing, fmt.Sprintf("%d of %d updated pods are available", available, desired)
+	}
+	return HealthHealthy, "rollout complete"
+}
+
+func jobHealth(obj *unstructured.Unstructured) (HealthStatus, string) {
+	if status, _, message, _ := findCondition(obj, "Failed"); status == "True" {
+		return HealthDegraded, message
+	}
+	if status, _, _, _ := findCondition(obj, "Complete"); status == "True" {
+		return HealthHealthy, "job completed"
+	}
+	return HealthProgressing, fmt.Sprintf("%d pod(s) active, %d succeeded", statusInt(obj, "active"), statusInt(obj, "succeeded"))
+}
+
+func podHealth(obj *unstructured.Unstructured) (HealthStatus, string) {
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	message, _, _ := unstructured.NestedString(obj.Object, "status", "message")
+	switch phase {
+	case "Succeeded":
+		return HealthHealthy, "pod completed"
+	case "Failed":
+		return HealthDegraded, message
+	case "Running":
+		if status, _, _, _ := findCondition(obj, "Ready"); status == "True" {
+			return HealthHealthy, "pod is running and ready"
+		}
+		return HealthProgressing, "pod is running but not ready"
+	}
+	return HealthProgressing, fmt.Sprintf("pod is %s", phase)
+}
+
+func pvcHealth(obj *unstructured.Unstructured) (HealthStatus, string) {
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	switch phase {
+	case "Bound":
+		return HealthHealthy, "claim is bound"
+	case "Lost":
+		return HealthDegraded, "claim lost its underlying volume"
+	}
+	return HealthProgressing, "waiting for claim to be bound"
+}
+
+func serviceHealth(obj *unstructured.Unstructured) (HealthStatus, string) {
+	if serviceType, _, _ := unstructured.NestedString(obj.Object, "spec", "type"); serviceType != "LoadBalancer" {
+		return HealthHealthy, ""
+	}
+	ingress, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+	if len(ingress) == 0 {
+		return HealthProgressing, "waiting for load balancer ingress"
+	}
+	return HealthHealthy, "load balancer is provisioned"
+}
+
+// conditionHealth is the generic rule for kinds without dedicated handling,
+// including custom resources that follow the status.conditions convention.
+func conditionHealth(obj *unstructured.Unstructured) (HealthStatus, string) {
+	for _, conditionType := range []string{"Ready", "Available"} {
+		status, reason, message, found := findCondition(obj, conditionType)
+		if !found {
+			continue
+		}
+		if message == "" {
+			message = reason
+		}
+		switch status {
+		case "True":
+			return HealthHealthy, message
+		case "False":
+			return HealthDegraded, message
+		default:
+			return HealthProgressing, message
+		}
+	}
+	return HealthHealthy, ""
+}
@@ -0,0 +1,187 @@
+package kubehandler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestAssessHealth(t *testing.T) {
+	t.Helper()
+	testCases := []struct {
+		name     string
+		object   map[string]interface{}
+		expected HealthStatus
+	}{
+		{
+			name: "DeploymentRolledOut",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "Deployment",
+				"metadata": map[string]interface{}{"name": "web", "generation": int64(2)},
+				"spec":     map[string]interface{}{"replicas": int64(3)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(2), "replicas": int64(3), "updatedReplicas": int64(3), "availableReplicas": int64(3),
+				},
+			},
+			expected: HealthHealthy,
+		},
+		{
+			name: "DeploymentRollingOut",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "Deployment",
+				"metadata": map[string]interface{}{"name": "web", "generation": int64(2)},
+				"spec":     map[string]interface{}{"replicas": int64(3)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(2), "replicas": int64(4), "updatedReplicas": int64(1), "availableReplicas": int64(3),
+				},
+			},
+			expected: HealthProgressing,
+		},
+		{
+			name: "DeploymentGenerationNotObserved",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "Deployment",
+				"metadata": map[string]interface{}{"name": "web", "generation": int64(3)},
+				"status":   map[string]interface{}{"observedGeneration": int64(2)},
+			},
+			expected: HealthProgressing,
+		},
+		{
+			name: "DeploymentDeadlineExceeded",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "Deployment",
+				"metadata": map[string]interface{}{"name": "web", "generation": int64(1)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(1),
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded"},
+					},
+				},
+			},
+			expected: HealthDegraded,
+		},
+		{
+			name: "StatefulSetRevisionPending",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "StatefulSet",
+				"metadata": map[string]interface{}{"name": "db", "generation": int64(1)},
+				"spec":     map[string]interface{}{"replicas": int64(1)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(1), "readyReplicas": int64(1), "currentRevision": "db-1", "updateRevision": "db-2",
+				},
+			},
+			expected: HealthProgressing,
+		},
+		{
+			name: "DaemonSetAvailable",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "DaemonSet",
+				"metadata": map[string]interface{}{"name": "agent", "generation": int64(1)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(1), "desiredNumberScheduled": int64(2), "updatedNumberScheduled": int64(2), "numberAvailable": int64(2),
+				},
+			},
+			expected: HealthHealthy,
+		},
+		{
+			name: "JobFailed",
+			object: map[string]interface{}{
+				"apiVersion": "batch/v1", "kind": "Job",
+				"metadata": map[string]interface{}{"name": "migrate"},
+				"status": map[string]interface{}{
+					"conditions": []interface{}{map[string]interface{}{"type": "Failed", "status": "True", "message": "BackoffLimitExceeded"}},
+				},
+			},
+			expected: HealthDegraded,
+		},
+		{
+			name: "PVCPending",
+			object: map[string]interface{}{
+				"apiVersion": "v1", "kind": "PersistentVolumeClaim",
+				"metadata": map[string]interface{}{"name": "data"},
+				"status":   map[string]interface{}{"phase": "Pending"},
+			},
+			expected: HealthProgressing,
+		},
+		{
+			name: "LoadBalancerWithoutIngress",
+			object: map[string]interface{}{
+				"apiVersion": "v1", "kind": "Service",
+				"metadata": map[string]interface{}{"name": "web"},
+				"spec":     map[string]interface{}{"type": "LoadBalancer"},
+			},
+			expected: HealthProgressing,
+		},
+		{
+			name: "ClusterIPService",
+			object: map[string]interface{}{
+				"apiVersion": "v1", "kind": "Service",
+				"metadata": map[string]interface{}{"name": "web"},
+				"spec":     map[string]interface{}{"type": "ClusterIP"},
+			},
+			expected: HealthHealthy,
+		},
+		{
+			name: "CustomResourceNotReady",
+			object: map[string]interface{}{
+				"apiVersion": "example.com/v1", "kind": "Database",
+				"metadata": map[string]interface{}{"name": "orders"},
+				"status": map[string]interface{}{
+					"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "False", "reason": "ProvisioningFailed"}},
+				},
+			},
+			expected: HealthDegraded,
+		},
+		{
+			name: "ConfigMapWithoutStatus",
+			object: map[string]interface{}{
+				"apiVersion": "v1", "kind": "ConfigMap",
+				"metadata": map[string]interface{}{"name": "settings"},
+			},
+			expected: HealthHealthy,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, message := AssessHealth(&unstructured.Unstructured{Object: tc.object})
+			if status != tc.expected {
+				t.Errorf("AssessHealth() = %s (%s), expected %s", status, message, tc.expected)
+			}
+		})
+	}
+}
+
+func TestAggregateHealth(t *testing.T) {
+	t.Helper()
+	if got := AggregateHealth(nil); got != HealthHealthy {
+		t.Errorf("AggregateHealth(nil) = %s, expected %s", got, HealthHealthy)
+	}
+	resources := []ResourceHealth{{Status: HealthHealthy}, {Status: HealthDegraded}, {Status: HealthProgressing}}
+	if got := AggregateHealth(resources); got != HealthDegraded {
+		t.Errorf("AggregateHealth() = %s, expected %s", got, HealthDegraded)
+	}
+}
+
+func TestWaitForHealthy_Canceled(t *testing.T) {
+	t.Helper()
+	kh := newPruneTestHandler(t)
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{{Version: "v1"}})
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	kh.restMapper = mapper
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	started := time.Now()
+	results := kh.WaitForHealthy(ctx, []ResourceKey{{Kind: "ConfigMap", Namespace: "default", Name: "missing"}}, time.Minute)
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("expected the wait to end when its context was canceled, it took %s", elapsed)
+	}
+	if len(results) != 1 || results[0].Status != HealthMissing {
+		t.Errorf("expected the missing ConfigMap to be reported as %s, got %+v", HealthMissing, results)
+	}
+}
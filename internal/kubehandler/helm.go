@@ -0,0 +1,170 @@
+package kubehandler
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// HelmOptions configures how a Helm chart is rendered.
+type HelmOptions struct {
+	ReleaseName string   // Defaults to the chart name
+	Namespace   string   // Release namespace, set on rendered namespaced objects without one; defaults to "default"
+	ValuesFiles []string // Values files relative to the chart directory, later files taking precedence
+	Set         string   // Inline overrides in `helm --set` syntax, e.g. "image.tag=v2,replicas=3"
+}
+
+// IsHelmChart reports whether filePath names the Chart.yaml of a Helm chart.
+func IsHelmChart(filePath string) bool {
+	return filepath.Base(filePath) == chartutil.ChartfileName
+}
+
+// renderHelmChart renders the chart in the directory of chartPath in-process,
+// the way `helm template` would, and returns the rendered objects numbered in
+// output order: the chart's CRDs first, then its templates sorted by name.
+// Nothing is installed in the cluster and no release is recorded; Helm hooks
+// are rendered and applied like any other template.
+func renderHelmChart(chartPath string, files fileContents, opts HelmOptions) ([]Manifest, []ManifestError, error) {
+	dir := filepath.Dir(chartPath)
+	log.Printf("Rendering Helm chart in %s", dir)
+
+	var chrt *chart.Chart
+	var err error
+	if files == nil {
+		chrt, err = loader.Load(dir)
+	} else {
+		// Unlike a chart on disk, .helmignore is not applied.
+		chrt, err = loader.LoadFiles(files.chartFiles(dir))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load Helm chart %s: %w", dir, err)
+	}
+	if err := checkChartDependencies(chrt); err != nil {
+		return nil, nil, fmt.Errorf("invalid Helm chart %s: %w", dir, err)
+	}
+
+	values, err := helmValues(dir, files, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := chartutil.ProcessDependenciesWithMerge(chrt, values); err != nil {
+		return nil, nil, fmt.Errorf("failed to process dependencies of Helm chart %s: %w", dir, err)
+	}
+
+	release := chartutil.ReleaseOptions{
+		Name:      opts.ReleaseName,
+		Namespace: opts.Namespace,
+		Revision:  1,
+		IsInstall: true,
+	}
+	if release.Name == "" {
+		release.Name = chrt.Name()
+	}
+	if release.Namespace == "" {
+		release.Namespace = "default"
+	}
+	renderValues, err := chartutil.ToRenderValues(chrt, values, release, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compute values of Helm chart %s: %w", dir, err)
+	}
+	rendered, err := engine.Render(chrt, renderValues)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to render Helm chart %s: %w", dir, err)
+	}
+
+	var stream []string
+	for _, crd := range chrt.CRDObjects() {
+		stream = append(stream, string(crd.File.Data))
+	}
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		if strings.HasSuffix(name, "NOTES.txt") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stream = append(stream, rendered[name])
+	}
+
+	docs, docErrors := decodeManifests(chartPath, strings.NewReader(strings.Join(stream, "\n---\n")))
+	// Lines of the rendered stream would not match any file of the chart.
+	for i := range docErrors {
+		docErrors[i].Line = 0
+	}
+	for i, m := range docs {
+		docs[i].Line = 0
+		if m.Object.GetNamespace() == "" {
+			// Removed again from cluster-scoped objects; see resourceInterface.
+			m.Object.SetNamespace(release.Namespace)
+		}
+	}
+	log.Printf("Helm chart %s rendered %d object(s) for release %s in namespace %s.", chartPath, len(docs), release.Name, release.Namespace)
+	return docs, docErrors, nil
+}
+
+// helmValues merges the values files of opts, read relative to the chart
+// directory dir from files, and then the inline overrides of opts.Set. The
+// chart's own values.yaml is not included; Helm coalesces it while rendering.
+func helmValues(dir string, files fileContents, opts HelmOptions) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, name := range opts.ValuesFiles {
+		content, err := files.readFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Helm values file %s: %w", name, err)
+		}
+		fileValues, err := chartutil.ReadValues(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Helm values file %s: %w", name, err)
+		}
+		values = mergeValues(values, fileValues.AsMap())
+	}
+	if opts.Set != "" {
+		if err := strvals.ParseInto(opts.Set, values); err != nil {
+			return nil, fmt.Errorf("failed to parse Helm value overrides: %w", err)
+		}
+	}
+	return values, nil
+}
+
+// mergeValues merges override into base recursively, override taking precedence.
+func mergeValues(base, override map[string]interface{}) map[string]interface{} {
+	for key, value := range override {
+		if overrideTable, ok := value.(map[string]interface{}); ok {
+			if baseTable, ok := base[key].(map[string]interface{}); ok {
+				base[key] = mergeValues(baseTable, overrideTable)
+				continue
+			}
+		}
+		base[key] = value
+	}
+	return base
+}
+
+// checkChartDependencies returns an error if a dependency declared in
+// Chart.yaml is missing from the charts/ directory.
+func checkChartDependencies(chrt *chart.Chart) error {
+	loaded := make(map[string]bool, len(chrt.Dependencies()))
+	for _, dep := range chrt.Dependencies() {
+		loaded[dep.Name()] = true
+	}
+	var missing []string
+	for _, dep := range chrt.Metadata.Dependencies {
+		if !loaded[dep.Name] {
+			missing = append(missing, dep.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("dependencies missing from the charts/ directory: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package kubehandler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// writeFiles writes files, keyed by path relative to dir, creating parent directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestLoadManifests_HelmChart(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: web\nversion: 0.1.0\n",
+		"values.yaml": "mode: base\nreplicas: 1\nimage:\n  repository: nginx\n  tag: \"1.0\"\n",
+		"prod.yaml":   "mode: prod\nimage:\n  tag: \"2.0\"\n",
+		"templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}-settings\n" +
+			"data:\n  mode: {{ .Values.mode }}\n  replicas: {{ .Values.replicas | quote }}\n  image: {{ .Values.image.repository }}:{{ .Values.image.tag }}\n",
+		"templates/namespace.yaml": "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: {{ .Release.Namespace }}\n",
+		"templates/NOTES.txt":      "Installed {{ .Release.Name }}.\n",
+		"templates/_helpers.tpl":   "{{- define \"web.name\" -}}web{{- end -}}\n",
+	})
+
+	chartFile := filepath.Join(dir, "Chart.yaml")
+	opts := LoadOptions{Helm: HelmOptions{
+		ReleaseName: "shop",
+		Namespace:   "prod",
+		ValuesFiles: []string{"prod.yaml"},
+		Set:         "replicas=3",
+	}}
+	manifests, loadErrors := LoadManifests([]string{chartFile}, opts)
+	if len(loadErrors) != 0 {
+		t.Fatalf("LoadManifests() returned unexpected errors: %v", loadErrors)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("expected 2 rendered manifests, got %d", len(manifests))
+	}
+
+	cm := manifests[0].Object
+	if manifests[0].Source != chartFile || cm.GetName() != "shop-settings" || cm.GetNamespace() != "prod" {
+		t.Errorf("expected prod/shop-settings from %s, got %s/%s from %s", chartFile, cm.GetNamespace(), cm.GetName(), manifests[0].Source)
+	}
+	data := cm.Object["data"].(map[string]interface{})
+	expected := map[string]interface{}{"mode": "prod", "replicas": "3", "image": "nginx:2.0"}
+	for key, value := range expected {
+		if data[key] != value {
+			t.Errorf("expected data.%s to be %v, got %v", key, value, data[key])
+		}
+	}
+	if ns := manifests[1].Object; ns.GetKind() != "Namespace" || ns.GetName() != "prod" {
+		t.Errorf("expected Namespace prod as second manifest, got %s %s", ns.GetKind(), ns.GetName())
+	}
+
+	kh := newPruneTestHandler(t)
+	discovery := kh.discoveryClient.(preferredFakeDiscovery)
+	discovery.Resources[0].APIResources = append(discovery.Resources[0].APIResources, metav1.APIResource{Name: "namespaces", Kind: "Namespace", Namespaced: false})
+	for _, m := range manifests {
+		if _, _, err := kh.resourceInterface(m.Object); err != nil {
+			t.Fatalf("resourceInterface() returned an unexpected error for %s: %v", m.Object.GetKind(), err)
+		}
+	}
+	if ns := manifests[1].Object.GetNamespace(); ns != "" {
+		t.Errorf("expected the release namespace to be removed from the cluster-scoped Namespace, got %q", ns)
+	}
+	if ns := cm.GetNamespace(); ns != "prod" {
+		t.Errorf("expected the ConfigMap to keep the release namespace, got %q", ns)
+	}
+
+	opts.Helm.ValuesFiles = []string{"missing.yaml"}
+	if _, loadErrors = LoadManifests([]string{chartFile}, opts); len(loadErrors) != 1 {
+		t.Errorf("expected one error for a missing values file, got %v", loadErrors)
+	}
+}
+
+func TestLoadManifests_HelmChartMissingDependency(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: web\nversion: 0.1.0\ndependencies:\n- name: redis\n  version: 1.0.0\n",
+	})
+
+	_, loadErrors := LoadManifests([]string{filepath.Join(dir, "Chart.yaml")}, LoadOptions{})
+	if len(loadErrors) != 1 {
+		t.Fatalf("expected one error for a missing chart dependency, got %v", loadErrors)
+	}
+}
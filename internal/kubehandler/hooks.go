@@ -0,0 +1,119 @@
+package kubehandler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+// RunHooks runs the hooks of one phase one after another, waiting up to timeout
+// for each to complete. It stops at the first hook that fails and returns its error;
+// hooks of the phase after the failing one are not run.
+func (kh *KubeHandler) RunHooks(phase HookPhase, hooks []Manifest, timeout time.Duration) []ManifestError {
+	for _, m := range hooks {
+		log.Printf("Running %s hook %s %s from %s", phase, m.Object.GetKind(), m.Object.GetName(), m.Source)
+		if err := kh.runHook(m, timeout); err != nil {
+			log.Printf("%s hook %s %s failed: %v", phase, m.Object.GetKind(), m.Object.GetName(), err)
+			return []ManifestError{m.errorf("%s hook (%s %s) failed: %v", phase, m.Object.GetKind(), m.Object.GetName(), err)}
+		}
+		log.Printf("%s hook %s %s succeeded", phase, m.Object.GetKind(), m.Object.GetName())
+	}
+	return nil
+}
+
+// runHook creates the hook object, waits for it to finish and applies its delete policy.
+// Hooks are not stamped with the tracking label, so Prune never touches them.
+func (kh *KubeHandler) runHook(m Manifest, timeout time.Duration) error {
+	policies, err := hookDeletePolicies(m)
+	if err != nil {
+		return err
+	}
+	key, dr, err := kh.resourceInterface(m.Object)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+
+	if policies[HookDeleteBeforeCreation] {
+		if err := deleteAndWait(ctx, dr, key.Name); err != nil {
+			return fmt.Errorf("failed to delete previous run: %w", err)
+		}
+	}
+
+	if _, err := kh.patchObject(dr, m.Object, false); err != nil {
+		return fmt.Errorf("create failed: %w", err)
+	}
+
+	var hookErr error
+	err = wait.PollUntilContextCancel(ctx, healthPollInterval, true, func(ctx context.Context) (bool, error) {
+		live, err := dr.Get(ctx, key.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil // Keep waiting, the hook may not be visible yet
+		}
+		done, failure := hookCompletion(live)
+		if done && failure != "" {
+			hookErr = fmt.Errorf("%s", failure)
+		}
+		return done, nil
+	})
+	if err != nil {
+		hookErr = fmt.Errorf("did not complete within %s", timeout)
+	}
+
+	if (hookErr == nil && policies[HookDeleteSucceeded]) || (hookErr != nil && policies[HookDeleteFailed]) {
+		propagation := metav1.DeletePropagationBackground
+		if err := dr.Delete(context.TODO(), key.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Failed to delete hook %s: %v", key, err)
+		}
+	}
+	return hookErr
+}
+
+// hookCompletion reports whether a hook Job or Pod has finished and, if it failed, why.
+func hookCompletion(obj *unstructured.Unstructured) (done bool, failure string) {
+	if obj.GetKind() == "Pod" {
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+		switch phase {
+		case "Succeeded":
+			return true, ""
+		case "Failed":
+			message, _, _ := unstructured.NestedString(obj.Object, "status", "message")
+			return true, fmt.Sprintf("pod failed: %s", message)
+		}
+		return false, ""
+	}
+
+	status, message := jobHealth(obj)
+	switch status {
+	case HealthHealthy:
+		return true, ""
+	case HealthDegraded:
+		return true, fmt.Sprintf("job failed: %s", message)
+	}
+	return false, ""
+}
+
+// deleteAndWait deletes the named object in the foreground and waits until it is gone.
+func deleteAndWait(ctx context.Context, dr dynamic.ResourceInterface, name string) error {
+	propagation := metav1.DeletePropagationForeground
+	err := dr.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := dr.Get(ctx, name, metav1.GetOptions{})
+		return apierrors.IsNotFound(err), nil
+	})
+}
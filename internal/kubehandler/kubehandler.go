@@ -4,34 +4,46 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
-	"strings"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/utils/pointer" // For pointer.Bool()
-	"sigs.k8s.io/yaml"     // For YAML to JSON conversion
+
+	"github.com/user/go-argo-lite/internal/metrics"
 )
 
+// FieldManager is the Server-Side Apply field manager used for every object we apply.
+const FieldManager = "go-argo-lite"
+
 // KubeHandler provides methods to interact with a Kubernetes cluster.
 type KubeHandler struct {
 	clientset       kubernetes.Interface
 	dynamicClient   dynamic.Interface
 	discoveryClient discovery.DiscoveryInterface
+	restMapper      meta.RESTMapper // Maps kinds back to resources, e.g. for health checks
+	application     string          // Owner recorded in the tracking annotation; see ForApplication
+	adoptUnscoped   bool            // Prune also owns objects applied without an application; see AdoptUnscoped
+	// namespace, if set, is the only namespace of namespaced objects kh may
+	// apply and prune; clusterKinds are the cluster-scoped kinds it then may
+	// manage. See Restrict.
+	namespace    string
+	clusterKinds map[schema.GroupKind]bool
 	// namespace    string // Default namespace, can be added later if needed
 }
 
-// NewKubeHandler creates a new KubeHandler instance.
-// It initializes connections to the Kubernetes cluster using either kubeconfigPath
-// (if provided) or in-cluster configuration.
-func NewKubeHandler(kubeconfigPath string) (*KubeHandler, error) {
+// RESTConfig returns the client configuration read from kubeconfigPath, or the
+// in-cluster configuration if kubeconfigPath is empty.
+func RESTConfig(kubeconfigPath string) (*rest.Config, error) {
 	var config *rest.Config
 	var err error
 
@@ -45,7 +57,22 @@ func NewKubeHandler(kubeconfigPath string) (*KubeHandler, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Kubernetes config: %w", err)
 	}
+	return config, nil
+}
 
+// NewKubeHandler creates a new KubeHandler instance.
+// It initializes connections to the Kubernetes cluster using either kubeconfigPath
+// (if provided) or in-cluster configuration.
+func NewKubeHandler(kubeconfigPath string) (*KubeHandler, error) {
+	config, err := RESTConfig(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewKubeHandlerForConfig(config)
+}
+
+// NewKubeHandlerForConfig creates a new KubeHandler instance connected with config.
+func NewKubeHandlerForConfig(config *rest.Config) (*KubeHandler, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
@@ -65,98 +92,212 @@ func NewKubeHandler(kubeconfigPath string) (*KubeHandler, error) {
 		clientset:       clientset,
 		dynamicClient:   dynamicClient,
 		discoveryClient: discoveryClient,
+		restMapper:      restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
 	}, nil
 }
 
+// ForApplication returns a KubeHandler that shares the clients of kh but records
+// application as the owner of every object it applies, so that Prune only
+// deletes objects of that application. Use it whenever several applications
+// deploy to the same cluster.
+func (kh *KubeHandler) ForApplication(application string) *KubeHandler {
+	scoped := *kh
+	scoped.application = application
+	return &scoped
+}
+
+// AdoptUnscoped returns a KubeHandler like kh, which must be scoped to an
+// application, whose Prune also deletes objects applied by a KubeHandler not
+// scoped to any application, e.g. before the environment configuration was
+// replaced by an apps file. Objects that are applied again get the tracking
+// annotation of the application anyway.
+func (kh *KubeHandler) AdoptUnscoped() *KubeHandler {
+	adopting := *kh
+	adopting.adoptUnscoped = true
+	return &adopting
+}
+
+// Restrict returns a KubeHandler like kh that only applies, diffs, runs and
+// prunes namespaced objects in namespace and cluster-scoped objects of
+// clusterKinds, given as Kind or Kind.group, e.g. "ClusterRole.rbac.authorization.k8s.io".
+// Other objects fail to apply and are never pruned.
+func (kh *KubeHandler) Restrict(namespace string, clusterKinds []string) *KubeHandler {
+	restricted := *kh
+	restricted.namespace = namespace
+	restricted.clusterKinds = make(map[schema.GroupKind]bool, len(clusterKinds))
+	for _, kind := range clusterKinds {
+		restricted.clusterKinds[schema.ParseGroupKind(kind)] = true
+	}
+	return &restricted
+}
+
+// checkScope returns an error if kh is restricted and may not manage the
+// object key, which is namespaced or cluster-scoped.
+func (kh *KubeHandler) checkScope(key ResourceKey, namespaced bool) error {
+	switch {
+	case kh.namespace == "":
+		return nil
+	case namespaced && key.Namespace != kh.namespace:
+		return fmt.Errorf("%s is outside of namespace %s", key, kh.namespace)
+	case !namespaced && !kh.clusterKinds[schema.GroupKind{Group: key.Group, Kind: key.Kind}]:
+		return fmt.Errorf("%s is cluster-scoped and its kind is not allowed", key)
+	}
+	return nil
+}
+
 // ApplyManifestFile reads a YAML manifest file, splits it into individual documents,
 // and applies each document to the Kubernetes cluster using Server-Side Apply.
+// Every applied object is stamped with the tracking label and annotation so it can
+// later be found by Prune.
 func (kh *KubeHandler) ApplyManifestFile(filePath string) error {
 	log.Printf("Applying manifest file: %s\n", filePath)
-	content, err := os.ReadFile(filePath)
+	docs, docErrors, err := readManifestDocuments(filePath, nil, LoadOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to read manifest file %s: %w", filePath, err)
+		return err
+	}
+
+	applyErrors := append(docErrors, kh.ApplyManifests(docs)...)
+	if len(applyErrors) > 0 {
+		return fmt.Errorf("encountered errors during manifest application:\n - %s", joinManifestErrors(applyErrors))
 	}
 
-	// Split multi-document YAML. A simple split by "---" works for many cases.
-	// More robust parsing might be needed for complex YAML structures or comments around "---".
-	yamlDocs := strings.Split(string(content), "---")
-	var applyErrors []string
+	return nil
+}
+
+// ApplyManifests applies manifests using Server-Side Apply in dependency order
+// (see SortManifests). CustomResourceDefinitions are waited on until they are
+// Established, and discovery is refreshed, before any later manifest is applied,
+// so custom resources can ship in the same commit as their definition.
+// It returns one ManifestError per document that could not be applied.
+func (kh *KubeHandler) ApplyManifests(manifests []Manifest) []ManifestError {
+	var applyErrors []ManifestError
+	var pendingCRDs []string
+
+	for _, m := range SortManifests(manifests) {
+		obj := m.Object
+		gvk := obj.GroupVersionKind()
 
-	for i, doc := range yamlDocs {
-		doc = strings.TrimSpace(doc)
-		if doc == "" {
-			continue // Skip empty documents (e.g., after a trailing ---)
+		if len(pendingCRDs) > 0 && !isCRD(obj) {
+			kh.waitForCRDs(pendingCRDs)
+			pendingCRDs = nil
 		}
 
-		log.Printf("Applying document #%d from %s\n", i+1, filePath)
+		log.Printf("Applying document #%d from %s\n", m.Index, m.Source)
+		log.Printf("Processing GVK: %s, Name: %s, Namespace: %s\n", gvk, obj.GetName(), obj.GetNamespace())
 
-		// 1. Convert YAML to JSON
-		jsonData, err := yaml.YAMLToJSON([]byte(doc))
+		// 3. Discover the APIResource for this GVK and get the dynamic resource interface
+		key, dr, err := kh.resourceInterface(obj)
 		if err != nil {
-			log.Printf("Error converting YAML doc #%d to JSON: %v. Skipping.\n", i+1, err)
-			applyErrors = append(applyErrors, fmt.Sprintf("doc #%d: YAML to JSON conversion failed: %v", i+1, err))
+			log.Printf("Error finding API resource for GVK %s (doc #%d): %v. Skipping.\n", gvk, m.Index, err)
+			applyErrors = append(applyErrors, m.errorf("GVK %s: %v", gvk, err))
+			metrics.ObserveApply(obj.GetKind(), err)
 			continue
 		}
 
-		// 2. Decode JSON into an Unstructured object
-		obj := &unstructured.Unstructured{}
-		if err := obj.UnmarshalJSON(jsonData); err != nil {
-			log.Printf("Error unmarshalling JSON for doc #%d: %v. Skipping.\n", i+1, err)
-			applyErrors = append(applyErrors, fmt.Sprintf("doc #%d: JSON unmarshalling failed: %v", i+1, err))
+		// 4. Apply using Server-Side Apply
+		log.Printf("Applying %s %s (namespace: %s) with Server-Side Apply...\n", obj.GetKind(), obj.GetName(), key.Namespace)
+		_, err = kh.applyObject(dr, obj, key, false)
+		metrics.ObserveApply(obj.GetKind(), err)
+		if err != nil {
+			log.Printf("Error applying doc #%d (%s %s): %v\n", m.Index, obj.GetKind(), obj.GetName(), err)
+			applyErrors = append(applyErrors, m.errorf("(%s %s): apply failed: %v", obj.GetKind(), obj.GetName(), err))
 			continue
 		}
-
-		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
-			log.Printf("Doc #%d (%s) is missing kind or apiVersion, skipping.\n", i+1, obj.GetName())
-			applyErrors = append(applyErrors, fmt.Sprintf("doc #%d (%s): missing kind or apiVersion", i+1, obj.GetName()))
-			continue
+		log.Printf("Successfully applied/configured doc #%d (%s %s)\n", m.Index, obj.GetKind(), obj.GetName())
+		if isCRD(obj) {
+			pendingCRDs = append(pendingCRDs, obj.GetName())
 		}
+	}
 
-		gvk := obj.GroupVersionKind()
-		log.Printf("Processing GVK: %s, Name: %s, Namespace: %s\n", gvk, obj.GetName(), obj.GetNamespace())
+	if len(pendingCRDs) > 0 {
+		kh.waitForCRDs(pendingCRDs)
+	}
+	return applyErrors
+}
 
-		// 3. Discover the APIResource for this GVK
-		apiResource, err := kh.findAPIResource(gvk)
+// ResourceKeys returns the identity of every object in manifests without applying
+// anything. Objects whose API resource cannot be discovered are reported as errors.
+func (kh *KubeHandler) ResourceKeys(manifests []Manifest) ([]ResourceKey, []ManifestError) {
+	keys := make([]ResourceKey, 0, len(manifests))
+	var keyErrors []ManifestError
+	for _, m := range manifests {
+		key, _, err := kh.resourceKey(m.Object)
 		if err != nil {
-			log.Printf("Error finding API resource for GVK %s (doc #%d): %v. Skipping.\n", gvk, i+1, err)
-			applyErrors = append(applyErrors, fmt.Sprintf("doc #%d GVK %s: API discovery failed: %v", i+1, gvk, err))
+			keyErrors = append(keyErrors, m.errorf("GVK %s: API discovery failed: %v", m.Object.GroupVersionKind(), err))
 			continue
 		}
+		keys = append(keys, key)
+	}
+	return keys, keyErrors
+}
 
-		// 4. Get the dynamic resource interface
-		gvr := schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: apiResource.Name}
-		var dr dynamic.ResourceInterface
-		if apiResource.Namespaced {
-			namespace := obj.GetNamespace()
-			if namespace == "" {
-				namespace = "default" // Or use kh.namespace if defined and no namespace in manifest
-				log.Printf("No namespace found for %s %s, defaulting to '%s'", gvk.Kind, obj.GetName(), namespace)
-			}
-			dr = kh.dynamicClient.Resource(gvr).Namespace(namespace)
-		} else {
-			dr = kh.dynamicClient.Resource(gvr)
-		}
+// resourceInterface resolves the identity of obj and the dynamic client used to
+// manage it, failing for objects kh may not manage; see Restrict. The namespace
+// of a cluster-scoped obj, e.g. the default namespace of LoadOptions or a Helm
+// release, is removed.
+func (kh *KubeHandler) resourceInterface(obj *unstructured.Unstructured) (ResourceKey, dynamic.ResourceInterface, error) {
+	key, apiResource, err := kh.resourceKey(obj)
+	if err != nil {
+		return ResourceKey{}, nil, fmt.Errorf("API discovery failed: %w", err)
+	}
+	if err := kh.checkScope(key, apiResource.Namespaced); err != nil {
+		return ResourceKey{}, nil, err
+	}
 
-		// 5. Apply using Server-Side Apply
-		log.Printf("Applying %s %s (namespace: %s) with Server-Side Apply...\n", obj.GetKind(), obj.GetName(), obj.GetNamespace())
-		_, err = dr.Patch(context.TODO(), obj.GetName(), types.ApplyPatchType, jsonData, metav1.PatchOptions{
-			FieldManager: "go-argo-lite",     // Replace with your application's name
-			Force:        pointer.Bool(true), // Optional: Force ownership conflicts
-		})
+	gvk := obj.GroupVersionKind()
+	gvr := schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: apiResource.Name}
+	if !apiResource.Namespaced {
+		obj.SetNamespace("")
+		return key, kh.dynamicClient.Resource(gvr), nil
+	}
+	if obj.GetNamespace() == "" {
+		log.Printf("No namespace found for %s %s, defaulting to '%s'", gvk.Kind, obj.GetName(), key.Namespace)
+	}
+	return key, kh.dynamicClient.Resource(gvr).Namespace(key.Namespace), nil
+}
 
-		if err != nil {
-			log.Printf("Error applying doc #%d (%s %s): %v\n", i+1, obj.GetKind(), obj.GetName(), err)
-			applyErrors = append(applyErrors, fmt.Sprintf("doc #%d (%s %s): apply failed: %v", i+1, obj.GetKind(), obj.GetName(), err))
-		} else {
-			log.Printf("Successfully applied/configured doc #%d (%s %s)\n", i+1, obj.GetKind(), obj.GetName())
-		}
+// applyObject stamps tracking metadata on obj and applies it through dr with
+// Server-Side Apply. When dryRun is set the API server runs the full apply,
+// including admission, but does not persist the result.
+func (kh *KubeHandler) applyObject(dr dynamic.ResourceInterface, obj *unstructured.Unstructured, key ResourceKey, dryRun bool) (*unstructured.Unstructured, error) {
+	setTrackingMetadata(obj, kh.application, key)
+	return kh.patchObject(dr, obj, dryRun)
+}
+
+// patchObject applies obj as-is through dr with Server-Side Apply.
+func (kh *KubeHandler) patchObject(dr dynamic.ResourceInterface, obj *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	jsonData, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal object to JSON: %w", err)
 	}
 
-	if len(applyErrors) > 0 {
-		return fmt.Errorf("encountered errors during manifest application:\n - %s", strings.Join(applyErrors, "\n - "))
+	opts := metav1.PatchOptions{
+		FieldManager: FieldManager,
+		Force:        pointer.Bool(true), // Optional: Force ownership conflicts
 	}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	return dr.Patch(context.TODO(), obj.GetName(), types.ApplyPatchType, jsonData, opts)
+}
 
-	return nil
+// resourceKey discovers the API resource backing obj and returns the object's
+// identity, defaulting the namespace of namespaced objects to "default".
+func (kh *KubeHandler) resourceKey(obj *unstructured.Unstructured) (ResourceKey, *metav1.APIResource, error) {
+	gvk := obj.GroupVersionKind()
+	apiResource, err := kh.findAPIResource(gvk)
+	if err != nil {
+		return ResourceKey{}, nil, err
+	}
+
+	key := ResourceKey{Group: gvk.Group, Kind: gvk.Kind, Name: obj.GetName()}
+	if apiResource.Namespaced {
+		key.Namespace = obj.GetNamespace()
+		if key.Namespace == "" {
+			key.Namespace = "default"
+		}
+	}
+	return key, apiResource, nil
 }
 
 // findAPIResource discovers the metav1.APIResource for a given GroupVersionKind.
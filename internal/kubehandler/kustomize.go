@@ -0,0 +1,146 @@
+package kubehandler
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/loader"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// IsKustomization reports whether filePath names a kustomization file.
+func IsKustomization(filePath string) bool {
+	base := filepath.Base(filePath)
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+// renderKustomization builds the kustomization in the directory of
+// kustomizationPath in-process, the way `kustomize build` would, and returns the
+// rendered objects numbered in output order. Files are only read below root,
+// e.g. the repository checkout, or below the directory of the kustomization if
+// root is empty or the files are not on disk. Remote bases and files are
+// rejected, and plugins are disabled.
+func renderKustomization(kustomizationPath, root string, files fileContents) ([]Manifest, []ManifestError, error) {
+	dir := filepath.Dir(kustomizationPath)
+	log.Printf("Rendering kustomization in %s", dir)
+
+	fsys, kustomizationDir, err := files.kustomizeFS(dir, root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load kustomization %s: %w", kustomizationPath, err)
+	}
+	if err := rejectRemote(fsys, kustomizationDir, map[string]bool{}); err != nil {
+		return nil, nil, fmt.Errorf("failed to load kustomization %s: %w", kustomizationPath, err)
+	}
+	resMap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fsys, kustomizationDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to render kustomization %s: %w", kustomizationPath, err)
+	}
+
+	var docs []Manifest
+	var docErrors []ManifestError
+	for i, res := range resMap.Resources() {
+		m := Manifest{Source: kustomizationPath, Index: i + 1}
+		content, err := res.Map()
+		if err != nil {
+			docErrors = append(docErrors, m.errorf("(%s): failed to convert rendered resource: %v", res.CurId(), err))
+			continue
+		}
+		m.Object = &unstructured.Unstructured{Object: content}
+		docs = append(docs, m)
+	}
+	log.Printf("Kustomization %s rendered %d object(s).", kustomizationPath, len(docs))
+	return docs, docErrors, nil
+}
+
+// rejectRemote returns an error if the kustomization in dir, or one it refers
+// to, loads a remote Git repository or a file over HTTP, which krusty would
+// fetch. Resources, components and plugin configurations that are not inline
+// must exist in fsys, as krusty clones those that do not but look like Git
+// URLs. Invalid kustomizations are left to krusty to report.
+func rejectRemote(fsys filesys.FileSystem, dir string, visited map[string]bool) error {
+	if visited[dir] {
+		return nil
+	}
+	visited[dir] = true
+
+	var k types.Kustomization
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		content, err := fsys.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if err := k.Unmarshal(content); err != nil {
+			return nil
+		}
+		break
+	}
+	k.FixKustomization()
+
+	var refs []string
+	refs = append(refs, k.Resources...)
+	refs = append(refs, k.Components...)
+	refs = append(refs, k.Generators...)
+	refs = append(refs, k.Transformers...)
+	refs = append(refs, k.Validators...)
+	for _, ref := range refs {
+		if strings.Contains(ref, "\n") {
+			continue // Inline plugin configuration
+		}
+		path := filepath.Join(dir, ref)
+		if loader.IsRemoteFile(ref) || strings.Contains(ref, "://") || strings.Contains(ref, "?ref=") || !fsys.Exists(path) {
+			return fmt.Errorf("%s refers to %s, which is not a file or directory in the repository", dir, ref)
+		}
+		if fsys.IsDir(path) {
+			if err := rejectRemote(fsys, path, visited); err != nil {
+				return err
+			}
+		}
+	}
+
+	files := append(append([]string(nil), k.Crds...), k.Configurations...)
+	for _, patch := range k.PatchesStrategicMerge {
+		files = append(files, string(patch))
+	}
+	for _, patch := range append(append([]types.Patch(nil), k.Patches...), k.PatchesJson6902...) {
+		files = append(files, patch.Path)
+	}
+	for _, replacement := range k.Replacements {
+		files = append(files, replacement.Path)
+	}
+	for _, path := range k.OpenAPI {
+		files = append(files, path)
+	}
+	var sources []types.KvPairSources
+	for _, generator := range k.ConfigMapGenerator {
+		sources = append(sources, generator.KvPairSources)
+	}
+	for _, generator := range k.SecretGenerator {
+		sources = append(sources, generator.KvPairSources)
+	}
+	for _, source := range sources {
+		for _, file := range source.FileSources {
+			if _, path, found := strings.Cut(file, "="); found {
+				file = path // key=path
+			}
+			files = append(files, file)
+		}
+		files = append(files, source.EnvSources...)
+	}
+	for _, file := range files {
+		if loader.IsRemoteFile(file) {
+			return fmt.Errorf("%s refers to the remote file %s", dir, file)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package kubehandler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadManifests_Kustomization(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"base/kustomization.yaml": "resources:\n- configmap.yaml\n",
+		"base/configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  mode: base\n",
+		"overlay/kustomization.yaml": "resources:\n- ../base\nnamePrefix: prod-\nnamespace: prod\n" +
+			"patches:\n- patch: |-\n    apiVersion: v1\n    kind: ConfigMap\n    metadata:\n      name: settings\n    data:\n      mode: prod\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	kustomization := filepath.Join(dir, "overlay", "kustomization.yaml")
+	manifests, loadErrors := LoadManifests([]string{kustomization}, LoadOptions{Root: dir})
+	if len(loadErrors) != 0 {
+		t.Fatalf("LoadManifests() returned unexpected errors: %v", loadErrors)
+	}
+	if len(manifests) != 1 {
+		t.Fatalf("expected 1 rendered manifest, got %d", len(manifests))
+	}
+
+	m := manifests[0]
+	if m.Source != kustomization || m.Index != 1 {
+		t.Errorf("expected source %s doc #1, got %s doc #%d", kustomization, m.Source, m.Index)
+	}
+	if m.Object.GetName() != "prod-settings" || m.Object.GetNamespace() != "prod" {
+		t.Errorf("expected prod/prod-settings, got %s/%s", m.Object.GetNamespace(), m.Object.GetName())
+	}
+	if mode := m.Object.Object["data"].(map[string]interface{})["mode"]; mode != "prod" {
+		t.Errorf("expected the overlay patch to set mode to prod, got %v", mode)
+	}
+
+	if _, loadErrors = LoadManifests([]string{filepath.Join(dir, "missing", "kustomization.yaml")}, LoadOptions{Root: dir}); len(loadErrors) != 1 {
+		t.Errorf("expected one error for a missing kustomization, got %v", loadErrors)
+	}
+	if _, loadErrors = LoadManifests([]string{kustomization}, LoadOptions{}); len(loadErrors) != 1 {
+		t.Errorf("expected one error for a base outside the directory of the kustomization without a root, got %v", loadErrors)
+	}
+}
+
+func TestLoadManifests_KustomizationConfined(t *testing.T) {
+	t.Helper()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "kustomization.yaml"), []byte("resources:\n- secret.yaml\n"), 0644); err != nil {
+		t.Fatalf("Failed to write kustomization: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.yaml"), []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: host\n"), 0644); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+
+	root := t.TempDir()
+	relOutside, err := filepath.Rel(filepath.Join(root, "app"), outside)
+	if err != nil {
+		t.Fatalf("Failed to compute relative path: %v", err)
+	}
+	for name, resource := range map[string]string{
+		"parent":      relOutside,
+		"symlink":     "linked",
+		"remote-base": "https://github.com/example/manifests//base?ref=v1",
+		"git-base":    "github.com/example/manifests/base",
+		"remote-file": "https://example.com/secret.yaml",
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := filepath.Join(root, "app")
+			if err := os.RemoveAll(dir); err != nil {
+				t.Fatalf("Failed to clean up: %v", err)
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := os.Symlink(outside, filepath.Join(dir, "linked")); err != nil {
+				t.Fatalf("Failed to create symbolic link: %v", err)
+			}
+			kustomization := filepath.Join(dir, "kustomization.yaml")
+			if err := os.WriteFile(kustomization, []byte("resources:\n- "+resource+"\n"), 0644); err != nil {
+				t.Fatalf("Failed to write kustomization: %v", err)
+			}
+
+			manifests, loadErrors := LoadManifests([]string{kustomization}, LoadOptions{Root: root})
+			if len(manifests) != 0 || len(loadErrors) != 1 {
+				t.Errorf("expected the kustomization referring to %s to be rejected, got %d manifest(s) and errors %v", resource, len(manifests), loadErrors)
+			}
+		})
+	}
+
+	t.Run("RemotePatch", func(t *testing.T) {
+		dir := filepath.Join(root, "patched")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		kustomization := filepath.Join(dir, "kustomization.yaml")
+		if err := os.WriteFile(kustomization, []byte("patches:\n- path: https://example.com/patch.yaml\n"), 0644); err != nil {
+			t.Fatalf("Failed to write kustomization: %v", err)
+		}
+		if _, loadErrors := LoadManifests([]string{kustomization}, LoadOptions{Root: root}); len(loadErrors) != 1 {
+			t.Errorf("expected a remote patch to be rejected, got %v", loadErrors)
+		}
+	})
+}
+
+func TestLoadManifests_KustomizationEncrypted(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"kustomization.yaml": "resources:\n- configmap.yaml\n- secret.yaml\n",
+		"configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n",
+		"secret.yaml": "apiVersion: v1\nkind: Secret\nmetadata:\n  name: credentials\n" +
+			"stringData:\n  password: ENC[AES256_GCM,data:abc,iv:def,tag:ghi,type:str]\n" +
+			"sops:\n  mac: ENC[AES256_GCM,data:abc,iv:def,tag:ghi,type:str]\n  version: 3.8.1\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	manifests, loadErrors := LoadManifests([]string{filepath.Join(dir, "kustomization.yaml")}, LoadOptions{Root: dir})
+	if len(manifests) != 1 || manifests[0].Object.GetKind() != "ConfigMap" {
+		t.Errorf("expected only the ConfigMap to be rendered, got %v", manifests)
+	}
+	if len(loadErrors) != 1 {
+		t.Errorf("expected one error for the encrypted Secret, got %v", loadErrors)
+	}
+}
+
+func TestIsKustomization(t *testing.T) {
+	t.Helper()
+	for path, expected := range map[string]bool{
+		"app/kustomization.yaml": true,
+		"app/kustomization.yml":  true,
+		"app/Kustomization":      true,
+		"app/deployment.yaml":    false,
+	} {
+		if got := IsKustomization(path); got != expected {
+			t.Errorf("IsKustomization(%q) = %t, expected %t", path, got, expected)
+		}
+	}
+}
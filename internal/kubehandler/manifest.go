@@ -0,0 +1,373 @@
+package kubehandler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// Manifest is a single decoded object together with where it came from.
+type Manifest struct {
+	Source string // Path of the file the document was read from
+	Index  int    // 1-based position of the document within Source
+	Line   int    // Line of Source where the document starts; 0 if unknown
+	Object *unstructured.Unstructured
+}
+
+// ManifestError describes a manifest document, or a whole file when Index is 0,
+// that could not be decoded or applied.
+type ManifestError struct {
+	Source  string
+	Index   int
+	Line    int // Line of Source where the document starts; 0 if unknown
+	Message string
+}
+
+func (e ManifestError) Error() string {
+	switch {
+	case e.Index == 0:
+		return fmt.Sprintf("%s: %s", e.Source, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("%s doc #%d (line %d): %s", e.Source, e.Index, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s doc #%d: %s", e.Source, e.Index, e.Message)
+}
+
+// errorf returns a ManifestError for m.
+func (m Manifest) errorf(format string, args ...interface{}) ManifestError {
+	return ManifestError{Source: m.Source, Index: m.Index, Line: m.Line, Message: fmt.Sprintf(format, args...)}
+}
+
+// joinManifestErrors formats errs as an indented list, one error per line.
+func joinManifestErrors(errs []ManifestError) string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n - ")
+}
+
+// LoadOptions configures how manifests are loaded and how sources that need
+// rendering are rendered.
+type LoadOptions struct {
+	// Namespace is set on loaded objects that do not declare one. It is
+	// removed again from cluster-scoped objects when they are applied.
+	Namespace string
+	// Root is the directory kustomizations on disk may read files from,
+	// typically the repository checkout. If it is empty, each kustomization
+	// may only read files in its own directory.
+	Root string
+	Helm HelmOptions
+	// Decryptor decrypts files encrypted with SOPS. Without it such files
+	// fail to load, so that encrypted values are never applied.
+	Decryptor *Decryptor
+}
+
+// LoadManifests decodes every document of every file in filePaths. Files that
+// cannot be read and documents that cannot be decoded are skipped and reported
+// in the returned errors.
+func LoadManifests(filePaths []string, opts LoadOptions) ([]Manifest, []ManifestError) {
+	return loadManifests(filePaths, nil, opts)
+}
+
+// LoadManifestContents is LoadManifests for files that are not on disk, e.g.
+// read from Git objects: contents holds the content of every file in
+// filePaths, keyed by path, and for a kustomization or Helm chart also the
+// contents of the files in its directory that rendering reads. Files outside
+// that directory cannot be referred to.
+func LoadManifestContents(filePaths []string, contents map[string][]byte, opts LoadOptions) ([]Manifest, []ManifestError) {
+	if contents == nil {
+		contents = map[string][]byte{}
+	}
+	return loadManifests(filePaths, contents, opts)
+}
+
+// loadManifests loads filePaths from files, or from disk if files is nil.
+func loadManifests(filePaths []string, files fileContents, opts LoadOptions) ([]Manifest, []ManifestError) {
+	var manifests []Manifest
+	var loadErrors []ManifestError
+	for _, filePath := range filePaths {
+		docs, docErrors, err := readManifestDocuments(filePath, files, opts)
+		if err != nil {
+			loadErrors = append(loadErrors, ManifestError{Source: filePath, Message: err.Error()})
+			continue
+		}
+		if opts.Namespace != "" {
+			for _, m := range docs {
+				if m.Object.GetNamespace() == "" {
+					m.Object.SetNamespace(opts.Namespace)
+				}
+			}
+		}
+		manifests = append(manifests, docs...)
+		loadErrors = append(loadErrors, docErrors...)
+	}
+	return manifests, loadErrors
+}
+
+// readManifestDocuments reads filePath from files, decrypts it if it is
+// encrypted with SOPS, and decodes each YAML document or JSON object into an
+// unstructured object, or renders it if it is a kustomization file or the
+// Chart.yaml of a Helm chart. Documents that fail to decode are skipped and
+// described in the returned list of document errors; the error return is
+// reserved for failures affecting the whole file.
+func readManifestDocuments(filePath string, files fileContents, opts LoadOptions) ([]Manifest, []ManifestError, error) {
+	if IsKustomization(filePath) {
+		return rejectEncrypted(renderKustomization(filePath, opts.Root, files))
+	}
+	if IsHelmChart(filePath) {
+		return rejectEncrypted(renderHelmChart(filePath, files, opts.Helm))
+	}
+
+	content, err := files.readFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read manifest file %s: %w", filePath, err)
+	}
+	if isSOPSFile(content) {
+		if opts.Decryptor == nil {
+			return nil, nil, fmt.Errorf("manifest file %s is encrypted with SOPS, but no decryption keys are configured", filePath)
+		}
+		// Decryption returns YAML, also for JSON files, and its line numbers
+		// are those of the decrypted documents.
+		if content, err = opts.Decryptor.decryptSOPS(filePath, content); err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt manifest file %s: %w", filePath, err)
+		}
+	} else if filepath.Ext(filePath) == ".json" {
+		docs, docErrors := decodeJSONManifests(filePath, content)
+		return docs, docErrors, nil
+	}
+	docs, docErrors := decodeManifests(filePath, bytes.NewReader(content))
+	return docs, docErrors, nil
+}
+
+// rejectEncrypted turns rendered documents that still carry SOPS metadata into
+// document errors: rendering does not decrypt them, and they must not be
+// applied with their encrypted values.
+func rejectEncrypted(docs []Manifest, docErrors []ManifestError, err error) ([]Manifest, []ManifestError, error) {
+	if err != nil {
+		return docs, docErrors, err
+	}
+	kept := docs[:0]
+	for _, m := range docs {
+		if _, ok := m.Object.Object[sopsMetadataKey]; ok {
+			docErrors = append(docErrors, m.errorf("(%s %s): rendered document is encrypted with SOPS, which is only supported for plain manifest files", m.Object.GetKind(), m.Object.GetName()))
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept, docErrors, nil
+}
+
+// decodeManifests decodes each YAML document of the stream r, which was read
+// from source, into an unstructured object; see decodeObject. The stream is
+// split like kubectl does, so values containing dashes, such as PEM
+// certificates, stay intact. Documents that fail to decode are skipped and
+// described in the returned errors, whose YAML line numbers count from the
+// start of the document.
+func decodeManifests(source string, r io.Reader) ([]Manifest, []ManifestError) {
+	var docs []Manifest
+	var docErrors []ManifestError
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	line := 0 // Lines of r before the current document
+	for i := 1; ; i++ {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		m := Manifest{Source: source, Index: i, Line: line + 1}
+		if err != nil {
+			docErrors = append(docErrors, m.errorf("failed to read YAML: %v", err))
+			break
+		}
+		// The reader drops the separator line after each document, but keeps
+		// those before its first line.
+		line += bytes.Count(doc, []byte("\n")) + 1
+		content, skipped := trimLeadingSeparators(doc)
+		if len(bytes.TrimSpace(content)) == 0 {
+			continue // Skip empty documents (e.g., after a trailing ---)
+		}
+		m.Line += skipped
+
+		// 1. Convert YAML to JSON
+		jsonData, err := yaml.YAMLToJSON(content)
+		if err != nil {
+			log.Printf("Error converting YAML doc #%d of %s to JSON: %v. Skipping.\n", i, source, err)
+			docErrors = append(docErrors, m.errorf("YAML to JSON conversion failed: %v", err))
+			continue
+		}
+
+		// 2. Decode JSON into an Unstructured object
+		docs, docErrors = decodeObject(docs, docErrors, m, jsonData)
+	}
+	return docs, docErrors
+}
+
+// trimLeadingSeparators returns doc without its leading blank and "---"
+// separator lines, and how many lines it removed.
+func trimLeadingSeparators(doc []byte) ([]byte, int) {
+	skipped := 0
+	for len(doc) > 0 {
+		first, rest, _ := bytes.Cut(doc, []byte("\n"))
+		marker, isSeparator := bytes.CutPrefix(first, []byte("---"))
+		marker = bytes.TrimSpace(marker)
+		if len(bytes.TrimSpace(first)) != 0 && (!isSeparator || (len(marker) > 0 && marker[0] != '#')) {
+			break
+		}
+		doc = rest
+		skipped++
+	}
+	return doc, skipped
+}
+
+// decodeJSONManifests decodes each JSON value of content, which was read from
+// source, into an unstructured object; see decodeObject. Like kubectl, it
+// accepts a stream of several concatenated objects. Objects that fail to
+// decode are skipped and described in the returned errors.
+func decodeJSONManifests(source string, content []byte) ([]Manifest, []ManifestError) {
+	var docs []Manifest
+	var docErrors []ManifestError
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for i := 1; ; i++ {
+		offset := decoder.InputOffset()
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		// The offset before decoding may point at whitespace before the value.
+		start := offset + int64(len(content[offset:])-len(bytes.TrimLeft(content[offset:], " \t\r\n")))
+		m := Manifest{Source: source, Index: i, Line: lineAt(content, start)}
+		if err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				m.Line = lineAt(content, syntaxErr.Offset)
+			}
+			// The decoder cannot resume after invalid JSON.
+			docErrors = append(docErrors, m.errorf("invalid JSON: %v", err))
+			break
+		}
+		docs, docErrors = decodeObject(docs, docErrors, m, raw)
+	}
+	return docs, docErrors
+}
+
+// decodeObject decodes the JSON object data of the document m and appends it to
+// docs. A List, such as the output of `kubectl get -o yaml`, is expanded into
+// its items, which keep the position of the list in its source. Objects that
+// cannot be decoded or lack kind or apiVersion are appended to docErrors.
+func decodeObject(docs []Manifest, docErrors []ManifestError, m Manifest, data []byte) ([]Manifest, []ManifestError) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		log.Printf("Error unmarshalling JSON for doc #%d of %s: %v. Skipping.\n", m.Index, m.Source, err)
+		return docs, append(docErrors, m.errorf("JSON unmarshalling failed: %v", err))
+	}
+
+	objects := []*unstructured.Unstructured{obj}
+	if strings.HasSuffix(obj.GetKind(), "List") && obj.IsList() {
+		list, err := obj.ToList()
+		if err != nil {
+			return docs, append(docErrors, m.errorf("(%s): failed to read list items: %v", obj.GetKind(), err))
+		}
+		objects = objects[:0]
+		for i := range list.Items {
+			objects = append(objects, &list.Items[i])
+		}
+	}
+	for _, obj := range objects {
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			log.Printf("Doc #%d (%s) of %s is missing kind or apiVersion, skipping.\n", m.Index, obj.GetName(), m.Source)
+			docErrors = append(docErrors, m.errorf("(%s): missing kind or apiVersion", obj.GetName()))
+			continue
+		}
+		item := m
+		item.Object = obj
+		docs = append(docs, item)
+	}
+	return docs, docErrors
+}
+
+// lineAt returns the 1-based line of content containing offset.
+func lineAt(content []byte, offset int64) int {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return 1 + bytes.Count(content[:offset], []byte("\n"))
+}
+
+// kindApplyOrder lists kinds in the order they must be applied so that each
+// object's dependencies already exist: namespaces and CRDs first, then identity
+// and RBAC, configuration, storage, networking and finally workloads.
+// Kinds not listed here, including custom resources, are applied last.
+var kindApplyOrder = []string{
+	"Namespace",
+	"CustomResourceDefinition",
+	"PriorityClass",
+	"ResourceQuota",
+	"LimitRange",
+	"NetworkPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"IngressClass",
+	"Ingress",
+	"APIService",
+	"MutatingWebhookConfiguration",
+	"ValidatingWebhookConfiguration",
+}
+
+// kindRank maps each kind in kindApplyOrder to its position.
+var kindRank = func() map[string]int {
+	ranks := make(map[string]int, len(kindApplyOrder))
+	for i, kind := range kindApplyOrder {
+		ranks[kind] = i
+	}
+	return ranks
+}()
+
+// applyRank returns the position of obj's kind in kindApplyOrder.
+func applyRank(obj *unstructured.Unstructured) int {
+	if rank, ok := kindRank[obj.GetKind()]; ok {
+		return rank
+	}
+	return len(kindApplyOrder)
+}
+
+// SortManifests returns a copy of manifests ordered by kindApplyOrder. Manifests
+// of the same rank keep their original relative order.
+func SortManifests(manifests []Manifest) []Manifest {
+	sorted := make([]Manifest, len(manifests))
+	copy(sorted, manifests)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return applyRank(sorted[i].Object) < applyRank(sorted[j].Object)
+	})
+	return sorted
+}
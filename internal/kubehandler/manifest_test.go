@@ -0,0 +1,164 @@
+package kubehandler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// newManifest builds a Manifest for an object of the given kind and name.
+func newManifest(kind, name string) Manifest {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind(kind)
+	obj.SetName(name)
+	return Manifest{Source: "test.yaml", Index: 1, Object: obj}
+}
+
+func TestSortManifests(t *testing.T) {
+	t.Helper()
+	manifests := []Manifest{
+		newManifest("Deployment", "web"),
+		newManifest("Widget", "custom"),
+		newManifest("ConfigMap", "settings"),
+		newManifest("Deployment", "worker"),
+		newManifest("CustomResourceDefinition", "widgets.example.com"),
+		newManifest("ServiceAccount", "web"),
+		newManifest("Namespace", "prod"),
+	}
+
+	var order []string
+	for _, m := range SortManifests(manifests) {
+		order = append(order, m.Object.GetKind()+"/"+m.Object.GetName())
+	}
+	expected := []string{
+		"Namespace/prod",
+		"CustomResourceDefinition/widgets.example.com",
+		"ServiceAccount/web",
+		"ConfigMap/settings",
+		"Deployment/web",
+		"Deployment/worker",
+		"Widget/custom",
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("SortManifests() order = %v, expected %v", order, expected)
+	}
+	if manifests[0].Object.GetKind() != "Deployment" {
+		t.Errorf("SortManifests() must not reorder its input")
+	}
+}
+
+func TestLoadManifests(t *testing.T) {
+	t.Helper()
+	tempDir := t.TempDir()
+	validFile := filepath.Join(tempDir, "app.yaml")
+	content := "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: prod\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n"
+	if err := os.WriteFile(validFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write manifest file: %v", err)
+	}
+	missingFile := filepath.Join(tempDir, "missing.yaml")
+
+	manifests, loadErrors := LoadManifests([]string{validFile, missingFile}, LoadOptions{})
+	if len(manifests) != 2 {
+		t.Fatalf("Expected 2 manifests, got %d", len(manifests))
+	}
+	if manifests[1].Source != validFile || manifests[1].Index != 2 || manifests[1].Object.GetName() != "settings" {
+		t.Errorf("Unexpected second manifest: %+v", manifests[1])
+	}
+	if len(loadErrors) != 1 || loadErrors[0].Source != missingFile || loadErrors[0].Index != 0 {
+		t.Errorf("Expected a single file-level error for %s, got %v", missingFile, loadErrors)
+	}
+}
+
+func TestDecodeManifests(t *testing.T) {
+	t.Helper()
+	content := `# Leading comment
+apiVersion: v1
+kind: Secret
+metadata:
+  name: tls
+stringData:
+  tls.crt: |
+    -----BEGIN CERTIFICATE-----
+    MIIB
+    -----END CERTIFICATE-----
+--- # The settings
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  notes: "a---b"
+---
+
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ServiceAccount
+  metadata:
+    name: web
+- apiVersion: v1
+  kind: ServiceAccount
+  metadata:
+    name: worker
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: broken
+...
+`
+	docs, docErrors := decodeManifests("app.yaml", strings.NewReader(content))
+
+	var got []string
+	for _, m := range docs {
+		got = append(got, fmt.Sprintf("#%d@%d %s/%s", m.Index, m.Line, m.Object.GetKind(), m.Object.GetName()))
+	}
+	expected := []string{"#1@1 Secret/tls", "#2@12 ConfigMap/settings", "#3@20 ServiceAccount/web", "#3@20 ServiceAccount/worker"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("decodeManifests() = %v, expected %v", got, expected)
+	}
+	if crt, _, _ := unstructured.NestedString(docs[0].Object.Object, "stringData", "tls.crt"); !strings.Contains(crt, "-----END CERTIFICATE-----") {
+		t.Errorf("expected the certificate to stay intact, got %q", crt)
+	}
+
+	if len(docErrors) != 1 || docErrors[0].Index != 4 || docErrors[0].Line != 32 {
+		t.Fatalf("expected an error for doc #4 at line 32, got %v", docErrors)
+	}
+	if message := docErrors[0].Error(); !strings.Contains(message, "app.yaml doc #4 (line 32)") || !strings.Contains(message, "yaml: line 3:") {
+		t.Errorf("expected the error to name the document and the failing line within it, got %q", message)
+	}
+}
+
+func TestLoadManifests_JSON(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "app.json")
+	content := `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "prod"}}
+{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings"}}]
+}
+`
+	if err := os.WriteFile(valid, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write manifest file: %v", err)
+	}
+	invalid := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(invalid, []byte("{\"apiVersion\": \"v1\",\n  \"kind\": }\n"), 0644); err != nil {
+		t.Fatalf("Failed to write manifest file: %v", err)
+	}
+
+	manifests, loadErrors := LoadManifests([]string{valid, invalid}, LoadOptions{})
+	if len(manifests) != 2 || manifests[1].Object.GetName() != "settings" || manifests[1].Index != 2 || manifests[1].Line != 2 {
+		t.Fatalf("expected the namespace and the item of the list, got %+v", manifests)
+	}
+	if len(loadErrors) != 1 || loadErrors[0].Source != invalid || loadErrors[0].Line != 2 {
+		t.Errorf("expected an error at line 2 of %s, got %v", invalid, loadErrors)
+	}
+}
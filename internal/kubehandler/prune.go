@@ -0,0 +1,165 @@
+package kubehandler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+const (
+	// TrackingLabel is stamped on every applied object. Its value is the field manager,
+	// which lets Prune find everything go-argo-lite has created with a label selector.
+	TrackingLabel = "go-argo-lite/managed-by"
+	// TrackingAnnotation records the identity of the manifest an object was applied from,
+	// prefixed with "<application>:" when the KubeHandler is scoped to an application.
+	// Objects whose annotation does not match their own identity (e.g. copies made with
+	// kubectl that kept our labels, or objects of another application) are never pruned.
+	TrackingAnnotation = "go-argo-lite/tracking-id"
+	// PruneAnnotation set to "false" on a live object protects it from being pruned.
+	PruneAnnotation = "go-argo-lite/prune"
+)
+
+// ResourceKey identifies a Kubernetes object independently of its API version.
+type ResourceKey struct {
+	Group     string
+	Kind      string
+	Namespace string // Empty for cluster-scoped objects
+	Name      string
+}
+
+// String returns the key in the "group/Kind/namespace/name" form used for the tracking annotation.
+func (k ResourceKey) String() string {
+	return fmt.Sprintf("%s/%s/%s/%s", k.Group, k.Kind, k.Namespace, k.Name)
+}
+
+// trackingID returns the tracking annotation value of key for application,
+// which is empty for a KubeHandler that is not scoped to an application.
+func trackingID(application string, key ResourceKey) string {
+	if application == "" {
+		return key.String()
+	}
+	return application + ":" + key.String()
+}
+
+// setTrackingMetadata adds the tracking label and annotation for key, owned by
+// application, to obj.
+func setTrackingMetadata(obj *unstructured.Unstructured, application string, key ResourceKey) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[TrackingLabel] = FieldManager
+	obj.SetLabels(labels)
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[TrackingAnnotation] = trackingID(application, key)
+	obj.SetAnnotations(annotations)
+}
+
+// pruneProtected reports whether the live object opted out of pruning.
+func pruneProtected(obj *unstructured.Unstructured) bool {
+	return strings.EqualFold(obj.GetAnnotations()[PruneAnnotation], "false")
+}
+
+// Prune deletes every live object carrying the tracking label whose identity is not
+// in desired. Only objects applied by the same application, or by no application
+// for a KubeHandler that adopts them (see AdoptUnscoped), are considered;
+// objects annotated with PruneAnnotation "false", objects already being deleted,
+// and objects whose tracking annotation does not match their identity are left
+// alone. It returns the keys of the deleted objects; deletion failures are collected
+// and returned together so that one stuck object does not block the rest.
+func (kh *KubeHandler) Prune(desired []ResourceKey) ([]ResourceKey, error) {
+	keep := make(map[ResourceKey]bool, len(desired))
+	for _, key := range desired {
+		keep[key] = true
+	}
+
+	resourceLists, err := kh.discoveryClient.ServerPreferredResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to discover server resources for pruning: %w", err)
+		}
+		// Some aggregated APIs are unavailable; prune what we can still see.
+		log.Printf("Partial API discovery while pruning: %v", err)
+	}
+	resourceLists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "delete"}}, resourceLists)
+
+	selector := fmt.Sprintf("%s=%s", TrackingLabel, FieldManager)
+	var pruned []ResourceKey
+	var pruneErrors []string
+
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			log.Printf("Skipping unparsable group version %q while pruning: %v", resourceList.GroupVersion, err)
+			continue
+		}
+
+		for _, apiResource := range resourceList.APIResources {
+			if strings.Contains(apiResource.Name, "/") {
+				continue // Subresources cannot be listed on their own
+			}
+			gvr := gv.WithResource(apiResource.Name)
+			live, err := kh.dynamicClient.Resource(gvr).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				pruneErrors = append(pruneErrors, fmt.Sprintf("list %s: %v", gvr, err))
+				continue
+			}
+
+			for i := range live.Items {
+				obj := &live.Items[i]
+				key := ResourceKey{Group: gv.Group, Kind: apiResource.Kind, Name: obj.GetName()}
+				if apiResource.Namespaced {
+					key.Namespace = obj.GetNamespace()
+				}
+
+				if keep[key] || obj.GetDeletionTimestamp() != nil {
+					continue
+				}
+				if err := kh.checkScope(key, apiResource.Namespaced); err != nil {
+					log.Printf("Not pruning %s: %v", key, err)
+					continue
+				}
+				if id := obj.GetAnnotations()[TrackingAnnotation]; id != trackingID(kh.application, key) && !(kh.adoptUnscoped && id == trackingID("", key)) {
+					log.Printf("Not pruning %s: tracking annotation %q does not match", key, obj.GetAnnotations()[TrackingAnnotation])
+					continue
+				}
+				if pruneProtected(obj) {
+					log.Printf("Not pruning %s: annotated with %s=false", key, PruneAnnotation)
+					continue
+				}
+
+				log.Printf("Pruning %s (no longer present in Git)", key)
+				dr := kh.dynamicClient.Resource(gvr)
+				propagation := metav1.DeletePropagationBackground
+				opts := metav1.DeleteOptions{PropagationPolicy: &propagation}
+				if apiResource.Namespaced {
+					err = dr.Namespace(key.Namespace).Delete(context.TODO(), key.Name, opts)
+				} else {
+					err = dr.Delete(context.TODO(), key.Name, opts)
+				}
+				if err != nil {
+					log.Printf("Error pruning %s: %v", key, err)
+					pruneErrors = append(pruneErrors, fmt.Sprintf("%s: delete failed: %v", key, err))
+					continue
+				}
+				log.Printf("Successfully pruned %s", key)
+				pruned = append(pruned, key)
+			}
+		}
+	}
+
+	if len(pruneErrors) > 0 {
+		return pruned, fmt.Errorf("encountered errors during pruning:\n - %s", strings.Join(pruneErrors, "\n - "))
+	}
+	return pruned, nil
+}
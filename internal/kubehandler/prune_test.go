@@ -0,0 +1,208 @@
+package kubehandler
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// newTrackedConfigMap builds a ConfigMap as it would look after being applied by go-argo-lite.
+func newTrackedConfigMap(namespace, name string, extraAnnotations map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	setTrackingMetadata(obj, "", ResourceKey{Kind: "ConfigMap", Namespace: namespace, Name: name})
+	annotations := obj.GetAnnotations()
+	for k, v := range extraAnnotations {
+		annotations[k] = v
+	}
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+// preferredFakeDiscovery serves its fake resources as the preferred ones too; the
+// upstream fake returns nothing from ServerPreferredResources.
+type preferredFakeDiscovery struct {
+	*discoveryfake.FakeDiscovery
+}
+
+func (d preferredFakeDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return d.Resources, nil
+}
+
+// newPruneTestHandler returns a KubeHandler backed by fake clients that know about ConfigMaps.
+func newPruneTestHandler(t *testing.T, objects ...runtime.Object) *KubeHandler {
+	t.Helper()
+	fakeDiscovery := &discoveryfake.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	fakeDiscovery.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: metav1.Verbs{"list", "delete", "patch"}},
+			},
+		},
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{configMapGVR: "ConfigMapList"}, objects...)
+	return &KubeHandler{dynamicClient: dynamicClient, discoveryClient: preferredFakeDiscovery{fakeDiscovery}}
+}
+
+func TestPrune_DeletesOnlyUndeclaredTrackedObjects(t *testing.T) {
+	t.Helper()
+	untracked := newTrackedConfigMap("default", "untracked", nil)
+	untracked.SetLabels(nil)
+	copied := newTrackedConfigMap("default", "copied", nil)
+	copied.SetAnnotations(map[string]string{TrackingAnnotation: "/ConfigMap/default/original"})
+
+	kh := newPruneTestHandler(t,
+		newTrackedConfigMap("default", "kept", nil),
+		newTrackedConfigMap("default", "removed", nil),
+		newTrackedConfigMap("default", "protected", map[string]string{PruneAnnotation: "false"}),
+		untracked,
+		copied,
+	)
+
+	pruned, err := kh.Prune([]ResourceKey{{Kind: "ConfigMap", Namespace: "default", Name: "kept"}})
+	if err != nil {
+		t.Fatalf("Prune() returned an unexpected error: %v", err)
+	}
+
+	expected := ResourceKey{Kind: "ConfigMap", Namespace: "default", Name: "removed"}
+	if len(pruned) != 1 || pruned[0] != expected {
+		t.Fatalf("Prune() pruned %v, expected only %v", pruned, expected)
+	}
+
+	remaining, err := kh.dynamicClient.Resource(configMapGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list remaining ConfigMaps: %v", err)
+	}
+	names := map[string]bool{}
+	for _, item := range remaining.Items {
+		names[item.GetName()] = true
+	}
+	for _, name := range []string{"kept", "protected", "untracked", "copied"} {
+		if !names[name] {
+			t.Errorf("Expected ConfigMap %q to survive pruning, remaining: %v", name, names)
+		}
+	}
+	if names["removed"] {
+		t.Errorf("Expected ConfigMap \"removed\" to be pruned")
+	}
+}
+
+func TestSetTrackingMetadata_PreservesExistingMetadata(t *testing.T) {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	obj.SetLabels(map[string]string{"app": "web"})
+	obj.SetAnnotations(map[string]string{"note": "keep"})
+
+	key := ResourceKey{Group: "apps", Kind: "Deployment", Namespace: "prod", Name: "web"}
+	setTrackingMetadata(obj, "", key)
+
+	if obj.GetLabels()["app"] != "web" || obj.GetLabels()[TrackingLabel] != FieldManager {
+		t.Errorf("Unexpected labels after stamping: %v", obj.GetLabels())
+	}
+	if obj.GetAnnotations()["note"] != "keep" || obj.GetAnnotations()[TrackingAnnotation] != "apps/Deployment/prod/web" {
+		t.Errorf("Unexpected annotations after stamping: %v", obj.GetAnnotations())
+	}
+}
+
+func TestPrune_ScopedToApplication(t *testing.T) {
+	t.Helper()
+	other := newTrackedConfigMap("default", "other", nil)
+	setTrackingMetadata(other, "api", ResourceKey{Kind: "ConfigMap", Namespace: "default", Name: "other"})
+	removed := newTrackedConfigMap("default", "removed", nil)
+	setTrackingMetadata(removed, "web", ResourceKey{Kind: "ConfigMap", Namespace: "default", Name: "removed"})
+
+	kh := newPruneTestHandler(t, other, removed).ForApplication("web")
+	pruned, err := kh.Prune(nil)
+	if err != nil {
+		t.Fatalf("Prune() returned an unexpected error: %v", err)
+	}
+	expected := ResourceKey{Kind: "ConfigMap", Namespace: "default", Name: "removed"}
+	if len(pruned) != 1 || pruned[0] != expected {
+		t.Errorf("Prune() pruned %v, expected only the object of application web %v", pruned, expected)
+	}
+}
+
+func TestPrune_AdoptUnscoped(t *testing.T) {
+	t.Helper()
+	unscoped := newTrackedConfigMap("default", "unscoped", nil)
+	other := newTrackedConfigMap("default", "other", nil)
+	setTrackingMetadata(other, "api", ResourceKey{Kind: "ConfigMap", Namespace: "default", Name: "other"})
+
+	kh := newPruneTestHandler(t, unscoped, other).ForApplication("web")
+	if pruned, err := kh.Prune(nil); err != nil || len(pruned) != 0 {
+		t.Errorf("expected no objects of other owners to be pruned, got %v (%v)", pruned, err)
+	}
+
+	pruned, err := kh.AdoptUnscoped().Prune(nil)
+	if err != nil {
+		t.Fatalf("Prune() returned an unexpected error: %v", err)
+	}
+	expected := ResourceKey{Kind: "ConfigMap", Namespace: "default", Name: "unscoped"}
+	if len(pruned) != 1 || pruned[0] != expected {
+		t.Errorf("Prune() pruned %v, expected only the unscoped object %v", pruned, expected)
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	t.Helper()
+	kh := newPruneTestHandler(t)
+	discovery := kh.discoveryClient.(preferredFakeDiscovery)
+	discovery.Resources[0].APIResources = append(discovery.Resources[0].APIResources, metav1.APIResource{Name: "namespaces", Kind: "Namespace", Namespaced: false})
+	newObject := func(kind, namespace string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind(kind)
+		obj.SetNamespace(namespace)
+		obj.SetName("web")
+		return obj
+	}
+
+	restricted := kh.Restrict("team-a", nil)
+	if _, _, err := restricted.resourceInterface(newObject("ConfigMap", "team-a")); err != nil {
+		t.Errorf("expected an object in the namespace to be allowed, got %v", err)
+	}
+	if _, _, err := restricted.resourceInterface(newObject("ConfigMap", "kube-system")); err == nil {
+		t.Error("expected an error for an object in another namespace")
+	}
+	if _, _, err := restricted.resourceInterface(newObject("Namespace", "")); err == nil {
+		t.Error("expected an error for a cluster-scoped kind that is not allowed")
+	}
+	if _, _, err := kh.Restrict("team-a", []string{"Namespace"}).resourceInterface(newObject("Namespace", "")); err != nil {
+		t.Errorf("expected an allowed cluster-scoped kind to be accepted, got %v", err)
+	}
+	if _, _, err := kh.resourceInterface(newObject("ConfigMap", "kube-system")); err != nil {
+		t.Errorf("expected an unrestricted handler to accept any namespace, got %v", err)
+	}
+}
+
+func TestPrune_Restricted(t *testing.T) {
+	t.Helper()
+	inside := newTrackedConfigMap("team-a", "inside", nil)
+	setTrackingMetadata(inside, "team-a/web", ResourceKey{Kind: "ConfigMap", Namespace: "team-a", Name: "inside"})
+	outside := newTrackedConfigMap("kube-system", "outside", nil)
+	setTrackingMetadata(outside, "team-a/web", ResourceKey{Kind: "ConfigMap", Namespace: "kube-system", Name: "outside"})
+
+	kh := newPruneTestHandler(t, inside, outside).ForApplication("team-a/web").Restrict("team-a", nil)
+	pruned, err := kh.Prune(nil)
+	if err != nil {
+		t.Fatalf("Prune() returned an unexpected error: %v", err)
+	}
+	expected := ResourceKey{Kind: "ConfigMap", Namespace: "team-a", Name: "inside"}
+	if len(pruned) != 1 || pruned[0] != expected {
+		t.Errorf("Prune() pruned %v, expected only the object in namespace team-a %v", pruned, expected)
+	}
+}
@@ -0,0 +1,176 @@
+package kubehandler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/getsops/sops/v3"
+	sopsaes "github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/stores"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"google.golang.org/grpc"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// sopsMetadataKey is the top-level key under which SOPS stores the metadata of
+// an encrypted file.
+const sopsMetadataKey = "sops"
+
+// Decryptor decrypts manifest files encrypted with SOPS, using age identities
+// and OpenPGP private keys. Only data keys encrypted for age or PGP can be
+// recovered; cloud KMS and Vault keys are not supported.
+type Decryptor struct {
+	ageIdentities []age.Identity
+	pgpKeys       openpgp.EntityList
+}
+
+// NewDecryptor parses ageKeys, age identities as in a SOPS_AGE_KEY_FILE, and
+// pgpKeys, armored OpenPGP private keys without a passphrase. It returns nil
+// and no error when no keys are given; SOPS files then fail to load.
+func NewDecryptor(ageKeys, pgpKeys string) (*Decryptor, error) {
+	if strings.TrimSpace(ageKeys) == "" && strings.TrimSpace(pgpKeys) == "" {
+		return nil, nil
+	}
+	d := &Decryptor{}
+	if strings.TrimSpace(ageKeys) != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(ageKeys))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age identities: %w", err)
+		}
+		d.ageIdentities = identities
+	}
+	if strings.TrimSpace(pgpKeys) != "" {
+		keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(pgpKeys))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PGP private keys: %w", err)
+		}
+		for _, key := range keys {
+			if key.PrivateKey == nil {
+				return nil, fmt.Errorf("PGP key %s has no private key", key.PrimaryKey.KeyIdString())
+			}
+			if key.PrivateKey.Encrypted {
+				return nil, fmt.Errorf("PGP private key %s is protected by a passphrase", key.PrimaryKey.KeyIdString())
+			}
+		}
+		d.pgpKeys = keys
+	}
+	return d, nil
+}
+
+// isSOPSFile reports whether content is a YAML or JSON file encrypted with
+// SOPS: its first document has a top-level sops key holding a MAC.
+func isSOPSFile(content []byte) bool {
+	if !bytes.Contains(content, []byte(sopsMetadataKey)) {
+		return false
+	}
+	var holder stores.SopsFile
+	err := yamlv3.NewDecoder(bytes.NewReader(content)).Decode(&holder)
+	return err == nil && holder.Metadata != nil && holder.Metadata.MessageAuthenticationCode != ""
+}
+
+// decryptSOPS decrypts the SOPS file filePath with content the way the decrypt
+// package of SOPS does, checking its MAC, but recovers the data key with the
+// configured keys rather than those of the environment. It returns the plain
+// YAML documents without the SOPS metadata, also for a JSON file. Errors never
+// contain decrypted values.
+func (d *Decryptor) decryptSOPS(filePath string, content []byte) ([]byte, error) {
+	var store sops.EncryptedFileLoader = &sopsyaml.Store{}
+	if filepath.Ext(filePath) == ".json" {
+		store = &sopsjson.Store{}
+	}
+	tree, err := store.LoadEncryptedFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SOPS file: %w", err)
+	}
+	dataKey, err := tree.Metadata.GetDataKeyWithKeyServices([]keyservice.KeyServiceClient{sopsKeyService{d}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover the SOPS data key: %w", err)
+	}
+
+	cipher := sopsaes.NewCipher()
+	mac, err := tree.Decrypt(dataKey, cipher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SOPS values: %w", err)
+	}
+	originalMAC, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the SOPS MAC: %w", err)
+	}
+	if originalMAC != mac {
+		return nil, errors.New("SOPS MAC mismatch: the file was modified after it was encrypted")
+	}
+
+	plain, err := (&sopsyaml.Store{}).EmitPlainFile(tree.Branches)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode decrypted YAML: %w", err)
+	}
+	return plain, nil
+}
+
+// sopsKeyService is a SOPS key service that decrypts the parts of data keys
+// encrypted for the age identities and PGP keys of a Decryptor. SOPS combines
+// the parts of key groups itself.
+type sopsKeyService struct {
+	d *Decryptor
+}
+
+// Encrypt is not supported: manifests are only decrypted.
+func (s sopsKeyService) Encrypt(context.Context, *keyservice.EncryptRequest, ...grpc.CallOption) (*keyservice.EncryptResponse, error) {
+	return nil, errors.New("encryption is not supported")
+}
+
+// Decrypt decrypts the armored age or PGP message req.Ciphertext.
+func (s sopsKeyService) Decrypt(_ context.Context, req *keyservice.DecryptRequest, _ ...grpc.CallOption) (*keyservice.DecryptResponse, error) {
+	var plaintext []byte
+	var err error
+	switch {
+	case req.Key.GetAgeKey() != nil:
+		plaintext, err = s.d.decryptAge(req.Ciphertext)
+	case req.Key.GetPgpKey() != nil:
+		plaintext, err = s.d.decryptPGP(req.Ciphertext)
+	default:
+		return nil, errors.New("only age and PGP keys are supported")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+func (d *Decryptor) decryptAge(ciphertext []byte) ([]byte, error) {
+	if len(d.ageIdentities) == 0 {
+		return nil, errors.New("no age identities are configured")
+	}
+	reader, err := age.Decrypt(agearmor.NewReader(bytes.NewReader(ciphertext)), d.ageIdentities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt with age: %w", err)
+	}
+	return io.ReadAll(reader)
+}
+
+func (d *Decryptor) decryptPGP(ciphertext []byte) ([]byte, error) {
+	if len(d.pgpKeys) == 0 {
+		return nil, errors.New("no PGP keys are configured")
+	}
+	block, err := pgparmor.Decode(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PGP message: %w", err)
+	}
+	message, err := openpgp.ReadMessage(block.Body, d.pgpKeys, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt with PGP: %w", err)
+	}
+	return io.ReadAll(message.UnverifiedBody)
+}
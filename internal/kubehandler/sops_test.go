@@ -0,0 +1,316 @@
+package kubehandler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/getsops/sops/v3"
+	sopsaes "github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/pgp"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"github.com/getsops/sops/v3/version"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// pgpTestKeyService encrypts data keys for PGP keys with entity, since the
+// local key service of SOPS only finds keys in a GnuPG keyring.
+type pgpTestKeyService struct {
+	entity *openpgp.Entity
+}
+
+func (s pgpTestKeyService) Encrypt(_ context.Context, req *keyservice.EncryptRequest, _ ...grpc.CallOption) (*keyservice.EncryptResponse, error) {
+	if req.Key.GetPgpKey() == nil {
+		return nil, errors.New("not a PGP key")
+	}
+	var out bytes.Buffer
+	armored, err := pgparmor.Encode(&out, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+	w, err := openpgp.Encrypt(armored, openpgp.EntityList{s.entity}, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	w.Write(req.Plaintext)
+	w.Close()
+	armored.Close()
+	return &keyservice.EncryptResponse{Ciphertext: out.Bytes()}, nil
+}
+
+func (s pgpTestKeyService) Decrypt(context.Context, *keyservice.DecryptRequest, ...grpc.CallOption) (*keyservice.DecryptResponse, error) {
+	return nil, errors.New("not supported")
+}
+
+// sopsEncrypt encrypts plain for keyGroups the way `sops --encrypt
+// --encrypted-regex` does with encryptedRegex, using the packages of SOPS. The
+// data key is encrypted with svcs or the local key service of SOPS.
+func sopsEncrypt(t *testing.T, store sops.Store, plain string, keyGroups []sops.KeyGroup, encryptedRegex string, svcs ...keyservice.KeyServiceClient) string {
+	t.Helper()
+	branches, err := store.LoadPlainFile([]byte(plain))
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %v", plain, err)
+	}
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{KeyGroups: keyGroups, EncryptedRegex: encryptedRegex, Version: version.Version},
+	}
+	dataKey, errs := tree.GenerateDataKeyWithKeyServices(append(svcs, keyservice.NewLocalClient()))
+	if len(errs) != 0 {
+		t.Fatalf("Failed to encrypt the data key: %v", errs)
+	}
+	if err := common.EncryptTree(common.EncryptTreeOpts{Tree: &tree, Cipher: sopsaes.NewCipher(), DataKey: dataKey}); err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	encrypted, err := store.EmitEncryptedFile(tree)
+	if err != nil {
+		t.Fatalf("Failed to encode the encrypted file: %v", err)
+	}
+	return string(encrypted)
+}
+
+// ageKeyGroup returns a key group holding the age recipient of identity.
+func ageKeyGroup(t *testing.T, identity *age.X25519Identity) sops.KeyGroup {
+	t.Helper()
+	key, err := sopsage.MasterKeyFromRecipient(identity.Recipient().String())
+	if err != nil {
+		t.Fatalf("Failed to parse age recipient: %v", err)
+	}
+	return sops.KeyGroup{key}
+}
+
+const sopsTestManifest = `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: hunter2
+  port: 5432
+  tls: true
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: production
+`
+
+func writeSOPSFile(t *testing.T, name, content string) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", filePath, err)
+	}
+	return filePath
+}
+
+func TestLoadManifests_SOPS(t *testing.T) {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+	keyGroups := []sops.KeyGroup{ageKeyGroup(t, identity)}
+	encrypted := sopsEncrypt(t, &sopsyaml.Store{}, sopsTestManifest, keyGroups, "^(data|stringData)$")
+	if strings.Contains(encrypted, "hunter2") || !isSOPSFile([]byte(encrypted)) {
+		t.Fatalf("expected an encrypted SOPS file, got:\n%s", encrypted)
+	}
+	filePath := writeSOPSFile(t, "secret.enc.yaml", encrypted)
+
+	decryptor, err := NewDecryptor("# created: 2024-01-01T00:00:00Z\n"+identity.String()+"\n", "")
+	if err != nil {
+		t.Fatalf("NewDecryptor() returned an unexpected error: %v", err)
+	}
+	manifests, loadErrors := LoadManifests([]string{filePath}, LoadOptions{Decryptor: decryptor})
+	if len(loadErrors) != 0 {
+		t.Fatalf("LoadManifests() returned unexpected errors: %v", loadErrors)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("expected 2 manifests, got %d", len(manifests))
+	}
+	secret := manifests[0].Object
+	if _, ok := secret.Object[sopsMetadataKey]; ok {
+		t.Error("expected the SOPS metadata to be removed")
+	}
+	stringData, _, _ := unstructured.NestedMap(secret.Object, "stringData")
+	if stringData["password"] != "hunter2" || stringData["port"] != int64(5432) || stringData["tls"] != true {
+		t.Errorf("expected decrypted values with their types, got %#v", stringData)
+	}
+	if mode, _, _ := unstructured.NestedString(manifests[1].Object.Object, "data", "mode"); mode != "production" {
+		t.Errorf("expected the second document to be decrypted, got mode %q", mode)
+	}
+
+	plainJSON := `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "api"}, "stringData": {"token": "s3cret", "retries": 3}}`
+	encrypted = sopsEncrypt(t, &sopsjson.Store{}, plainJSON, keyGroups, "^stringData$")
+	manifests, loadErrors = LoadManifests([]string{writeSOPSFile(t, "secret.enc.json", encrypted)}, LoadOptions{Decryptor: decryptor})
+	if len(loadErrors) != 0 || len(manifests) != 1 {
+		t.Fatalf("expected the JSON file to be decrypted, got %d manifests (%v)", len(manifests), loadErrors)
+	}
+	stringData, _, _ = unstructured.NestedMap(manifests[0].Object.Object, "stringData")
+	if stringData["token"] != "s3cret" || stringData["retries"] != int64(3) {
+		t.Errorf("expected decrypted JSON values with their types, got %#v", stringData)
+	}
+}
+
+// pgpTestKey generates an OpenPGP key and returns its armored private key, a
+// key group holding it, and a key service encrypting for it.
+func pgpTestKey(t *testing.T) (string, sops.KeyGroup, keyservice.KeyServiceClient) {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Deployer", "", "deployer@example.com", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate PGP key: %v", err)
+	}
+	var armoredKey bytes.Buffer
+	w, err := pgparmor.Encode(&armoredKey, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("Failed to armor PGP key: %v", err)
+	}
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("Failed to serialize PGP key: %v", err)
+	}
+	w.Close()
+	key := pgp.NewMasterKeyFromFingerprint(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint))
+	return armoredKey.String(), sops.KeyGroup{key}, pgpTestKeyService{entity}
+}
+
+func TestLoadManifests_SOPSKeyGroups(t *testing.T) {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+	pgpKey, pgpGroup, pgpService := pgpTestKey(t)
+	// Two key groups with the default Shamir threshold: both parts are needed.
+	keyGroups := []sops.KeyGroup{ageKeyGroup(t, identity), pgpGroup}
+	encrypted := sopsEncrypt(t, &sopsyaml.Store{}, sopsTestManifest, keyGroups, "^(data|stringData)$", pgpService)
+	filePath := writeSOPSFile(t, "secret.enc.yaml", encrypted)
+
+	both, err := NewDecryptor(identity.String(), pgpKey)
+	if err != nil {
+		t.Fatalf("NewDecryptor() returned an unexpected error: %v", err)
+	}
+	manifests, loadErrors := LoadManifests([]string{filePath}, LoadOptions{Decryptor: both})
+	if len(loadErrors) != 0 || len(manifests) != 2 {
+		t.Fatalf("expected both key groups to recover the data key, got %d manifests (%v)", len(manifests), loadErrors)
+	}
+	if password, _, _ := unstructured.NestedString(manifests[0].Object.Object, "stringData", "password"); password != "hunter2" {
+		t.Errorf("expected the password to be decrypted, got %q", password)
+	}
+
+	ageOnly, err := NewDecryptor(identity.String(), "")
+	if err != nil {
+		t.Fatalf("NewDecryptor() returned an unexpected error: %v", err)
+	}
+	if manifests, loadErrors := LoadManifests([]string{filePath}, LoadOptions{Decryptor: ageOnly}); len(manifests) != 0 || len(loadErrors) != 1 {
+		t.Errorf("expected one key group not to recover the data key, got %d manifests (%v)", len(manifests), loadErrors)
+	}
+}
+
+func TestLoadManifests_SOPSFailures(t *testing.T) {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+	encrypted := sopsEncrypt(t, &sopsyaml.Store{}, sopsTestManifest, []sops.KeyGroup{ageKeyGroup(t, identity)}, "^(data|stringData)$")
+	decryptor, err := NewDecryptor(identity.String(), "")
+	if err != nil {
+		t.Fatalf("NewDecryptor() returned an unexpected error: %v", err)
+	}
+	otherDecryptor, err := NewDecryptor(other.String(), "")
+	if err != nil {
+		t.Fatalf("NewDecryptor() returned an unexpected error: %v", err)
+	}
+	tampered := strings.Replace(encrypted, "name: settings", "name: other", 1)
+
+	tests := map[string]struct {
+		content   string
+		decryptor *Decryptor
+	}{
+		"no keys":      {encrypted, nil},
+		"wrong key":    {encrypted, otherDecryptor},
+		"tampered MAC": {tampered, decryptor},
+	}
+	for name, tc := range tests {
+		manifests, loadErrors := LoadManifests([]string{writeSOPSFile(t, "secret.enc.yaml", tc.content)}, LoadOptions{Decryptor: tc.decryptor})
+		if len(manifests) != 0 || len(loadErrors) != 1 || loadErrors[0].Index != 0 {
+			t.Errorf("%s: expected the file to fail as a whole, got %d manifests and errors %v", name, len(manifests), loadErrors)
+			continue
+		}
+		if strings.Contains(loadErrors[0].Error(), "hunter2") {
+			t.Errorf("%s: expected the error not to contain plain text, got %v", name, loadErrors[0])
+		}
+	}
+}
+
+func TestNewDecryptor(t *testing.T) {
+	t.Helper()
+	decryptor, err := NewDecryptor("", "\n")
+	if err != nil || decryptor != nil {
+		t.Errorf("expected no decryptor without keys, got %v, %v", decryptor, err)
+	}
+	if _, err := NewDecryptor("AGE-SECRET-KEY-INVALID", ""); err == nil {
+		t.Error("expected an error for an invalid age identity")
+	}
+	if _, err := NewDecryptor("", "not a key"); err == nil {
+		t.Error("expected an error for an invalid PGP key")
+	}
+}
+
+func TestMaskSecretValues(t *testing.T) {
+	t.Helper()
+	secret := func(data map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "db"},
+			"data":       data,
+		}}
+	}
+	live := secret(map[string]interface{}{"user": "YWRtaW4=", "password": "b2xk", "removed": "eA=="})
+	desired := secret(map[string]interface{}{"user": "YWRtaW4=", "password": "bmV3", "added": "eQ=="})
+	maskSecretValues(live, desired)
+
+	liveData, _, _ := unstructured.NestedStringMap(live.Object, "data")
+	desiredData, _, _ := unstructured.NestedStringMap(desired.Object, "data")
+	if liveData["user"] != secretMask || desiredData["user"] != secretMask {
+		t.Errorf("expected equal values to get the same mask, got %q and %q", liveData["user"], desiredData["user"])
+	}
+	if liveData["password"] == desiredData["password"] {
+		t.Errorf("expected changed values to get different masks, got %q", liveData["password"])
+	}
+	for _, data := range []map[string]string{liveData, desiredData} {
+		for key, value := range data {
+			if !strings.HasPrefix(value, secretMask) {
+				t.Errorf("expected %s to be masked, got %q", key, value)
+			}
+		}
+	}
+	if fields := changedFields(live.Object, desired.Object, ""); strings.Join(fields, ",") != "data.added,data.password,data.removed" {
+		t.Errorf("expected the changed keys to still be reported, got %v", fields)
+	}
+
+	created := secret(map[string]interface{}{"token": "dG9rZW4="})
+	maskSecretValues(nil, created)
+	if token, _, _ := unstructured.NestedString(created.Object, "data", "token"); token != secretMask {
+		t.Errorf("expected the values of a new Secret to be masked, got %q", token)
+	}
+}
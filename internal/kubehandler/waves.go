@@ -0,0 +1,165 @@
+package kubehandler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SyncWaveAnnotation assigns a document to a sync wave (an integer, default 0).
+	// Waves are applied in ascending order, each after the previous one is healthy.
+	SyncWaveAnnotation = "go-argo-lite/sync-wave"
+	// HookAnnotation marks a Job or Pod as a hook instead of a regular resource.
+	// Its value is a comma-separated list of HookPhase values.
+	HookAnnotation = "go-argo-lite/hook"
+	// HookDeletePolicyAnnotation is a comma-separated list of HookDeletePolicy
+	// values. Hooks without it use HookDeleteBeforeCreation.
+	HookDeletePolicyAnnotation = "go-argo-lite/hook-delete-policy"
+)
+
+// HookPhase is the point of a sync at which a hook runs.
+type HookPhase string
+
+const (
+	HookPreSync  HookPhase = "PreSync"  // Before any regular resource is applied
+	HookPostSync HookPhase = "PostSync" // After all waves applied and became healthy
+	HookSyncFail HookPhase = "SyncFail" // After any step of the sync failed
+)
+
+// HookDeletePolicy decides when a hook object is deleted.
+type HookDeletePolicy string
+
+const (
+	HookDeleteSucceeded      HookDeletePolicy = "HookSucceeded"      // Delete once the hook succeeded
+	HookDeleteFailed         HookDeletePolicy = "HookFailed"         // Delete once the hook failed
+	HookDeleteBeforeCreation HookDeletePolicy = "BeforeHookCreation" // Delete the previous run before creating the hook
+)
+
+// SyncWave is a group of regular manifests applied together.
+type SyncWave struct {
+	Wave      int
+	Manifests []Manifest
+}
+
+// SyncPlan arranges the manifests of a commit for syncing.
+type SyncPlan struct {
+	Waves []SyncWave               // Regular manifests in ascending wave order
+	Hooks map[HookPhase][]Manifest // Hooks of each phase in ascending wave order
+}
+
+// Resources returns the regular manifests of all waves, excluding hooks.
+func (p *SyncPlan) Resources() []Manifest {
+	var manifests []Manifest
+	for _, wave := range p.Waves {
+		manifests = append(manifests, wave.Manifests...)
+	}
+	return manifests
+}
+
+// PlanSync splits manifests into hooks and sync waves according to their
+// annotations. Documents with invalid annotations are left out of the plan and
+// reported in the returned errors.
+func PlanSync(manifests []Manifest) (*SyncPlan, []ManifestError) {
+	plan := &SyncPlan{Hooks: map[HookPhase][]Manifest{}}
+	var planErrors []ManifestError
+	waves := map[int][]Manifest{}
+
+	for _, m := range manifests {
+		annotations := m.Object.GetAnnotations()
+
+		wave := 0
+		if value, ok := annotations[SyncWaveAnnotation]; ok {
+			parsed, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				planErrors = append(planErrors, m.errorf("invalid %s annotation %q: must be an integer", SyncWaveAnnotation, value))
+				continue
+			}
+			wave = parsed
+		}
+
+		hookValue, isHook := annotations[HookAnnotation]
+		if !isHook {
+			waves[wave] = append(waves[wave], m)
+			continue
+		}
+
+		phases, err := parseHookPhases(hookValue)
+		if err == nil && !isHookKind(m) {
+			err = fmt.Errorf("only Jobs and Pods can be hooks, got %s", m.Object.GetKind())
+		}
+		if err == nil && m.Object.GetName() == "" {
+			err = fmt.Errorf("hooks must have a metadata.name")
+		}
+		if err == nil {
+			_, err = hookDeletePolicies(m)
+		}
+		if err != nil {
+			planErrors = append(planErrors, m.errorf("%v", err))
+			continue
+		}
+		for _, phase := range phases {
+			plan.Hooks[phase] = append(plan.Hooks[phase], m)
+		}
+	}
+
+	for _, hooks := range plan.Hooks {
+		sort.SliceStable(hooks, func(i, j int) bool {
+			return hookWave(hooks[i]) < hookWave(hooks[j])
+		})
+	}
+
+	for wave, waveManifests := range waves {
+		plan.Waves = append(plan.Waves, SyncWave{Wave: wave, Manifests: waveManifests})
+	}
+	sort.Slice(plan.Waves, func(i, j int) bool { return plan.Waves[i].Wave < plan.Waves[j].Wave })
+	return plan, planErrors
+}
+
+// hookWave returns the sync wave of an already validated hook.
+func hookWave(m Manifest) int {
+	wave, _ := strconv.Atoi(strings.TrimSpace(m.Object.GetAnnotations()[SyncWaveAnnotation]))
+	return wave
+}
+
+// isHookKind reports whether m is a Job or a Pod.
+func isHookKind(m Manifest) bool {
+	gvk := m.Object.GroupVersionKind()
+	return (gvk.Group == "batch" && gvk.Kind == "Job") || (gvk.Group == "" && gvk.Kind == "Pod")
+}
+
+// parseHookPhases parses the value of HookAnnotation.
+func parseHookPhases(value string) ([]HookPhase, error) {
+	var phases []HookPhase
+	for _, part := range strings.Split(value, ",") {
+		phase := HookPhase(strings.TrimSpace(part))
+		switch phase {
+		case HookPreSync, HookPostSync, HookSyncFail:
+			phases = append(phases, phase)
+		default:
+			return nil, fmt.Errorf("invalid %s annotation %q: unknown phase %q", HookAnnotation, value, phase)
+		}
+	}
+	return phases, nil
+}
+
+// hookDeletePolicies parses the HookDeletePolicyAnnotation of m, defaulting to
+// HookDeleteBeforeCreation. Unknown policies are rejected.
+func hookDeletePolicies(m Manifest) (map[HookDeletePolicy]bool, error) {
+	value, ok := m.Object.GetAnnotations()[HookDeletePolicyAnnotation]
+	if !ok {
+		return map[HookDeletePolicy]bool{HookDeleteBeforeCreation: true}, nil
+	}
+	policies := map[HookDeletePolicy]bool{}
+	for _, part := range strings.Split(value, ",") {
+		policy := HookDeletePolicy(strings.TrimSpace(part))
+		switch policy {
+		case HookDeleteSucceeded, HookDeleteFailed, HookDeleteBeforeCreation:
+			policies[policy] = true
+		default:
+			return nil, fmt.Errorf("invalid %s annotation %q: unknown policy %q", HookDeletePolicyAnnotation, value, policy)
+		}
+	}
+	return policies, nil
+}
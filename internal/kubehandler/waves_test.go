@@ -0,0 +1,72 @@
+package kubehandler
+
+import (
+	"testing"
+)
+
+// withAnnotations returns m with the given annotations set on its object.
+func withAnnotations(m Manifest, annotations map[string]string) Manifest {
+	m.Object.SetAnnotations(annotations)
+	return m
+}
+
+// newJobManifest builds a Manifest for a batch/v1 Job.
+func newJobManifest(name string, annotations map[string]string) Manifest {
+	m := newManifest("Job", name)
+	m.Object.SetAPIVersion("batch/v1")
+	return withAnnotations(m, annotations)
+}
+
+func TestPlanSync(t *testing.T) {
+	t.Helper()
+	manifests := []Manifest{
+		withAnnotations(newManifest("ConfigMap", "late"), map[string]string{SyncWaveAnnotation: "5"}),
+		newManifest("ConfigMap", "default-wave"),
+		withAnnotations(newManifest("ConfigMap", "early"), map[string]string{SyncWaveAnnotation: "-1"}),
+		newJobManifest("migrate", map[string]string{HookAnnotation: "PreSync", SyncWaveAnnotation: "2"}),
+		newJobManifest("seed", map[string]string{HookAnnotation: "PreSync"}),
+		newJobManifest("notify", map[string]string{HookAnnotation: "PostSync, SyncFail"}),
+	}
+
+	plan, planErrors := PlanSync(manifests)
+	if len(planErrors) != 0 {
+		t.Fatalf("PlanSync() returned unexpected errors: %v", planErrors)
+	}
+
+	var waves []int
+	for _, wave := range plan.Waves {
+		waves = append(waves, wave.Wave)
+	}
+	if len(waves) != 3 || waves[0] != -1 || waves[1] != 0 || waves[2] != 5 {
+		t.Errorf("Expected waves [-1 0 5], got %v", waves)
+	}
+	if len(plan.Resources()) != 3 {
+		t.Errorf("Expected hooks to be excluded from resources, got %d resources", len(plan.Resources()))
+	}
+
+	preSync := plan.Hooks[HookPreSync]
+	if len(preSync) != 2 || preSync[0].Object.GetName() != "seed" || preSync[1].Object.GetName() != "migrate" {
+		t.Errorf("Expected PreSync hooks [seed migrate] in wave order, got %v", preSync)
+	}
+	if len(plan.Hooks[HookPostSync]) != 1 || len(plan.Hooks[HookSyncFail]) != 1 {
+		t.Errorf("Expected notify to run as PostSync and SyncFail hook, got %v", plan.Hooks)
+	}
+}
+
+func TestPlanSync_InvalidAnnotations(t *testing.T) {
+	t.Helper()
+	manifests := []Manifest{
+		withAnnotations(newManifest("ConfigMap", "bad-wave"), map[string]string{SyncWaveAnnotation: "first"}),
+		withAnnotations(newManifest("ConfigMap", "not-a-job"), map[string]string{HookAnnotation: "PreSync"}),
+		newJobManifest("bad-phase", map[string]string{HookAnnotation: "PreDeploy"}),
+		newJobManifest("bad-policy", map[string]string{HookAnnotation: "PreSync", HookDeletePolicyAnnotation: "Never"}),
+	}
+
+	plan, planErrors := PlanSync(manifests)
+	if len(planErrors) != len(manifests) {
+		t.Errorf("Expected %d plan errors, got %d: %v", len(manifests), len(planErrors), planErrors)
+	}
+	if len(plan.Resources()) != 0 || len(plan.Hooks) != 0 {
+		t.Errorf("Expected invalid documents to be left out of the plan, got %+v", plan)
+	}
+}
@@ -0,0 +1,212 @@
+// Package metrics defines the Prometheus metrics of go-argo-lite and the
+// helpers that record them. All metrics are registered with the default
+// registry and served by Handler.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/user/go-argo-lite/pkg/utils"
+)
+
+// namespace prefixes the name of every metric.
+const namespace = "go_argo_lite"
+
+// durationBuckets cover Git and sync operations from 100ms to about 7 minutes.
+var durationBuckets = prometheus.ExponentialBuckets(0.1, 2, 13)
+
+var (
+	polls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "git_polls_total",
+		Help:      "Number of polls of a repository branch.",
+	}, []string{"repo", "branch"})
+	pollErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "git_poll_errors_total",
+		Help:      "Number of polls of a repository branch that failed.",
+	}, []string{"repo", "branch"})
+	pollDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "git_poll_duration_seconds",
+		Help:      "Duration of polls of a repository branch, including the fetch.",
+		Buckets:   durationBuckets,
+	}, []string{"repo", "branch"})
+	fetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "git_fetch_duration_seconds",
+		Help:      "Duration of fetches of a repository branch.",
+		Buckets:   durationBuckets,
+	}, []string{"repo", "branch"})
+	commitsDetected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "git_commits_detected_total",
+		Help:      "Number of new commits found by polls, including the first commit seen after start-up.",
+	}, []string{"repo", "branch"})
+	signatureRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "git_signature_rejections_total",
+		Help:      "Number of polls that refused a new commit because it is unsigned or not signed by a trusted key.",
+	}, []string{"repo", "branch"})
+	reclones = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "git_reclones_total",
+		Help:      "Number of times a corrupted local clone was deleted and cloned again.",
+	}, []string{"repo", "branch"})
+
+	documentsApplied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "documents_applied_total",
+		Help:      "Number of manifest documents applied successfully.",
+	}, []string{"kind"})
+	documentsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "documents_failed_total",
+		Help:      "Number of manifest documents that failed to apply.",
+	}, []string{"kind"})
+
+	syncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of syncs by outcome.",
+		Buckets:   durationBuckets,
+	}, []string{"application", "result"})
+	deployedCommit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deployed_commit_info",
+		Help:      "Always 1; the commit label is the commit of the last successful sync.",
+	}, []string{"application", "commit"})
+
+	lastSuccess = newSyncAgeCollector()
+)
+
+func init() {
+	prometheus.MustRegister(lastSuccess)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObservePoll records a poll of branch in repoURL that took duration and found
+// a new commit if changed.
+func ObservePoll(repoURL, branch string, duration time.Duration, changed bool, err error) {
+	repo := repoLabel(repoURL)
+	polls.WithLabelValues(repo, branch).Inc()
+	pollDuration.WithLabelValues(repo, branch).Observe(duration.Seconds())
+	if err != nil {
+		pollErrors.WithLabelValues(repo, branch).Inc()
+	}
+	if changed {
+		commitsDetected.WithLabelValues(repo, branch).Inc()
+	}
+}
+
+// ObserveFetch records a fetch of branch from repoURL that took duration.
+func ObserveFetch(repoURL, branch string, duration time.Duration) {
+	fetchDuration.WithLabelValues(repoLabel(repoURL), branch).Observe(duration.Seconds())
+}
+
+// ObserveSignatureRejection records that a poll of branch in repoURL refused
+// a commit because of its signature.
+func ObserveSignatureRejection(repoURL, branch string) {
+	signatureRejections.WithLabelValues(repoLabel(repoURL), branch).Inc()
+}
+
+// ObserveReclone records that the local clone of branch in repoURL was
+// deleted to clone it again.
+func ObserveReclone(repoURL, branch string) {
+	reclones.WithLabelValues(repoLabel(repoURL), branch).Inc()
+}
+
+// ObserveApply records the outcome of applying one document of kind.
+func ObserveApply(kind string, err error) {
+	if err != nil {
+		documentsFailed.WithLabelValues(kind).Inc()
+		return
+	}
+	documentsApplied.WithLabelValues(kind).Inc()
+}
+
+// ObserveSync records a sync of commit by application that took duration. A
+// successful sync makes commit the deployed commit of application.
+func ObserveSync(application, commit string, duration time.Duration, succeeded bool) {
+	result := "failed"
+	if succeeded {
+		result = "succeeded"
+	}
+	syncDuration.WithLabelValues(application, result).Observe(duration.Seconds())
+	if !succeeded {
+		return
+	}
+	deployedCommit.DeletePartialMatch(prometheus.Labels{"application": application})
+	deployedCommit.WithLabelValues(application, commit).Set(1)
+	lastSuccess.set(application, time.Now())
+}
+
+// ForgetApplication removes the per-application metrics of application, e.g.
+// once it is deleted.
+func ForgetApplication(application string) {
+	syncDuration.DeletePartialMatch(prometheus.Labels{"application": application})
+	deployedCommit.DeletePartialMatch(prometheus.Labels{"application": application})
+	lastSuccess.delete(application)
+}
+
+// repoLabel returns repoURL without credentials, so that they do not leak
+// into metric labels.
+func repoLabel(repoURL string) string {
+	return utils.RedactURL(repoURL)
+}
+
+// syncAgeCollector reports the time since the last successful sync of every
+// application, computed when the metrics are scraped.
+type syncAgeCollector struct {
+	desc *prometheus.Desc
+
+	mu          sync.Mutex
+	lastSuccess map[string]time.Time // Keyed by application
+}
+
+func newSyncAgeCollector() *syncAgeCollector {
+	return &syncAgeCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "seconds_since_last_successful_sync"),
+			"Seconds since the last successful sync.",
+			[]string{"application"}, nil,
+		),
+		lastSuccess: make(map[string]time.Time),
+	}
+}
+
+func (c *syncAgeCollector) set(application string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSuccess[application] = at
+}
+
+func (c *syncAgeCollector) delete(application string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.lastSuccess, application)
+}
+
+// Describe implements prometheus.Collector.
+func (c *syncAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *syncAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for application, at := range c.lastSuccess {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(at).Seconds(), application)
+	}
+}
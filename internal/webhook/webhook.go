@@ -0,0 +1,284 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxPayloadBytes caps the size of an accepted webhook body. GitHub, the most
+// generous of the supported providers, never sends more than 25 MB.
+const maxPayloadBytes = 25 << 20
+
+// Provider identifies the Git hosting service that sent a webhook.
+type Provider string
+
+const (
+	GitHub    Provider = "github"
+	GitLab    Provider = "gitlab"
+	Gitea     Provider = "gitea"
+	Bitbucket Provider = "bitbucket"
+)
+
+// Push is a ref update announced by a push webhook.
+type Push struct {
+	// Repositories are the clone and web URLs of the pushed repository found
+	// in the payload; see MatchesRepository.
+	Repositories []string
+	Ref          string // Full name of the updated ref, e.g. "refs/heads/main" or "refs/tags/v1.2.0"
+}
+
+// MatchesRepository reports whether the push was made to the repository
+// cloned from repoURL. URLs are compared by host and path only, so that the
+// HTTPS, SSH and web URLs of a repository match each other. A push whose
+// payload names no repository matches none.
+func (p Push) MatchesRepository(repoURL string) bool {
+	want := normalizeRepoURL(repoURL)
+	for _, candidate := range p.Repositories {
+		if candidate != "" && normalizeRepoURL(candidate) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRepoURL reduces a repository URL, including the scp-like syntax
+// "git@host:owner/repo", to its lower-cased host and path, without scheme,
+// credentials, port, trailing slash and ".git" suffix.
+func normalizeRepoURL(repoURL string) string {
+	s := strings.TrimSpace(repoURL)
+	if _, rest, ok := strings.Cut(s, "://"); ok {
+		s = rest
+	} else if host, path, ok := strings.Cut(s, ":"); ok && !strings.Contains(host, "/") {
+		s = host + "/" + path
+	}
+	host, path, _ := strings.Cut(s, "/")
+	if _, afterUser, ok := strings.Cut(host, "@"); ok {
+		host = afterUser
+	}
+	host, _, _ = strings.Cut(host, ":")
+	path = strings.TrimSuffix(strings.TrimSuffix(path, "/"), ".git")
+	return strings.ToLower(host + "/" + path)
+}
+
+// Handler receives push webhooks and calls trigger for every pushed ref;
+// trigger reports whether the repository and ref are watched. Every request
+// must be authenticated with the shared secret: GitHub, Gitea and Bitbucket
+// sign the body with HMAC-SHA256, GitLab sends the secret as a token.
+type Handler struct {
+	secret  []byte
+	trigger func(push Push) bool
+}
+
+// NewHandler creates a Handler for pushes authenticated with secret.
+func NewHandler(secret string, trigger func(push Push) bool) *Handler {
+	return &Handler{secret: []byte(secret), trigger: trigger}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadBytes))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	provider, event := detectProvider(r.Header)
+	if provider == "" {
+		http.Error(w, "unrecognized webhook provider", http.StatusBadRequest)
+		return
+	}
+	if err := h.verify(provider, r.Header, body); err != nil {
+		log.Printf("Rejected %s webhook: %v", provider, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	if !isPushEvent(provider, event) {
+		log.Printf("Ignoring %s webhook event %q", provider, event)
+		fmt.Fprintf(w, "ignored event %q\n", event)
+		return
+	}
+
+	pushes, err := pushedRefs(provider, body)
+	if err != nil {
+		log.Printf("Rejected %s push webhook: %v", provider, err)
+		http.Error(w, "invalid push payload", http.StatusBadRequest)
+		return
+	}
+	triggered := false
+	var refs []string
+	for _, push := range pushes {
+		refs = append(refs, push.Ref)
+		if h.trigger(push) {
+			log.Printf("Received %s push webhook for %s, triggered a poll.", provider, push.Ref)
+			triggered = true
+		}
+	}
+	if triggered {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "poll triggered")
+		return
+	}
+	log.Printf("Ignoring %s push webhook for unwatched refs %v", provider, refs)
+	fmt.Fprintf(w, "ignored push to %v\n", refs)
+}
+
+// detectProvider identifies the sender from its event header and returns the event name.
+// Gitea also sends GitHub-style headers, so it is checked first.
+func detectProvider(header http.Header) (Provider, string) {
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		return Gitea, header.Get("X-Gitea-Event")
+	case header.Get("X-GitHub-Event") != "":
+		return GitHub, header.Get("X-GitHub-Event")
+	case header.Get("X-Gitlab-Event") != "":
+		return GitLab, header.Get("X-Gitlab-Event")
+	case header.Get("X-Event-Key") != "":
+		return Bitbucket, header.Get("X-Event-Key")
+	}
+	return "", ""
+}
+
+// verify checks the request against the shared secret in the way provider signs it.
+func (h *Handler) verify(provider Provider, header http.Header, body []byte) error {
+	switch provider {
+	case GitLab:
+		token := header.Get("X-Gitlab-Token")
+		if token == "" {
+			return fmt.Errorf("missing X-Gitlab-Token header")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), h.secret) != 1 {
+			return fmt.Errorf("token mismatch")
+		}
+		return nil
+	case Gitea:
+		return h.verifyHMAC(header.Get("X-Gitea-Signature"), body)
+	case GitHub:
+		return h.verifyHMAC(strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256="), body)
+	default:
+		return h.verifyHMAC(strings.TrimPrefix(header.Get("X-Hub-Signature"), "sha256="), body)
+	}
+}
+
+// verifyHMAC checks that signature is the hex encoded HMAC-SHA256 of body.
+func (h *Handler) verifyHMAC(signature string, body []byte) error {
+	if signature == "" {
+		return fmt.Errorf("missing signature header")
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// isPushEvent reports whether event announces new commits on a ref.
+func isPushEvent(provider Provider, event string) bool {
+	switch provider {
+	case GitLab:
+		return event == "Push Hook" || event == "Tag Push Hook"
+	case Bitbucket:
+		// Bitbucket Cloud and Bitbucket Server (Data Center) use different keys.
+		return event == "repo:push" || event == "repo:refs_changed"
+	default:
+		return event == "push"
+	}
+}
+
+// pushPayload covers the fields of the push payloads that name the pushed
+// repository and the updated refs.
+type pushPayload struct {
+	Ref        string `json:"ref"` // GitHub, GitLab, Gitea
+	Repository struct {
+		CloneURL   string `json:"clone_url"`    // GitHub, Gitea
+		SSHURL     string `json:"ssh_url"`      // GitHub, Gitea
+		HTMLURL    string `json:"html_url"`     // GitHub, Gitea
+		GitHTTPURL string `json:"git_http_url"` // GitLab
+		GitSSHURL  string `json:"git_ssh_url"`  // GitLab
+		Links      struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"` // Bitbucket Cloud
+			Clone []struct {
+				Href string `json:"href"`
+			} `json:"clone"` // Bitbucket Server
+		} `json:"links"`
+	} `json:"repository"`
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"` // Bitbucket Cloud
+	Changes []struct {
+		Ref struct {
+			ID string `json:"id"`
+		} `json:"ref"`
+	} `json:"changes"` // Bitbucket Server
+}
+
+// repositories returns the URLs of the pushed repository in the payload.
+func (p *pushPayload) repositories() []string {
+	repo := p.Repository
+	urls := []string{repo.CloneURL, repo.SSHURL, repo.HTMLURL, repo.GitHTTPURL, repo.GitSSHURL, repo.Links.HTML.Href}
+	for _, clone := range repo.Links.Clone {
+		urls = append(urls, clone.Href)
+	}
+	return urls
+}
+
+// pushedRefs returns the branches and tags updated by a push payload.
+func pushedRefs(provider Provider, body []byte) ([]Push, error) {
+	var payload pushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+
+	repositories := payload.repositories()
+	var pushes []Push
+	if provider == Bitbucket {
+		for _, change := range payload.Push.Changes {
+			if change.New == nil {
+				continue
+			}
+			switch change.New.Type {
+			case "branch":
+				pushes = append(pushes, Push{Repositories: repositories, Ref: "refs/heads/" + change.New.Name})
+			case "tag":
+				pushes = append(pushes, Push{Repositories: repositories, Ref: "refs/tags/" + change.New.Name})
+			}
+		}
+		for _, change := range payload.Changes {
+			if change.Ref.ID != "" {
+				pushes = append(pushes, Push{Repositories: repositories, Ref: change.Ref.ID})
+			}
+		}
+		return pushes, nil
+	}
+
+	if payload.Ref != "" {
+		pushes = append(pushes, Push{Repositories: repositories, Ref: payload.Ref})
+	}
+	return pushes, nil
+}
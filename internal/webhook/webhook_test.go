@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSecret = "s3cr3t"
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// testRepoURL is the repository watched by the Handler of serve.
+const testRepoURL = "https://git.example.com/acme/deploy.git"
+
+// serve sends body with headers to a Handler watching branch "main" of
+// testRepoURL and returns the response status and whether a poll was triggered.
+func serve(t *testing.T, headers map[string]string, body string) (int, bool) {
+	t.Helper()
+	triggered := false
+	h := NewHandler(testSecret, func(push Push) bool {
+		if push.Ref != "refs/heads/main" || !push.MatchesRepository(testRepoURL) {
+			return false
+		}
+		triggered = true
+		return true
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, triggered
+}
+
+func TestHandler_Providers(t *testing.T) {
+	t.Helper()
+	githubBody := `{"ref":"refs/heads/main","repository":{"clone_url":"https://git.example.com/acme/deploy.git","ssh_url":"git@git.example.com:acme/deploy.git"}}`
+	gitlabBody := `{"ref":"refs/heads/main","repository":{"git_http_url":"https://git.example.com/acme/deploy.git","git_ssh_url":"git@git.example.com:acme/deploy.git"}}`
+	bitbucketCloudBody := `{"push":{"changes":[{"new":{"type":"branch","name":"main"}}]},"repository":{"links":{"html":{"href":"https://git.example.com/acme/deploy"}}}}`
+	bitbucketServerBody := `{"changes":[{"ref":{"id":"refs/heads/main"}}],"repository":{"links":{"clone":[{"href":"ssh://git@git.example.com:7999/acme/deploy.git"}]}}}`
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		body    string
+	}{
+		{"GitHub", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(githubBody)}, githubBody},
+		{"GitLab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": testSecret}, gitlabBody},
+		{"Gitea", map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": sign(githubBody)}, githubBody},
+		{"Bitbucket Cloud", map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": "sha256=" + sign(bitbucketCloudBody)}, bitbucketCloudBody},
+		{"Bitbucket Server", map[string]string{"X-Event-Key": "repo:refs_changed", "X-Hub-Signature": "sha256=" + sign(bitbucketServerBody)}, bitbucketServerBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, triggered := serve(t, tt.headers, tt.body)
+			if code != http.StatusAccepted || !triggered {
+				t.Errorf("expected status %d and a triggered poll, got status %d, triggered %t", http.StatusAccepted, code, triggered)
+			}
+		})
+	}
+}
+
+func TestHandler_RejectsBadSignatures(t *testing.T) {
+	t.Helper()
+	body := `{"ref":"refs/heads/main"}`
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"GitHub wrong secret", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(body+" ")}},
+		{"GitHub missing signature", map[string]string{"X-GitHub-Event": "push"}},
+		{"GitLab wrong token", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "guess"}},
+		{"Gitea malformed signature", map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": "not-hex"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, triggered := serve(t, tt.headers, body)
+			if code != http.StatusUnauthorized || triggered {
+				t.Errorf("expected status %d without a poll, got status %d, triggered %t", http.StatusUnauthorized, code, triggered)
+			}
+		})
+	}
+}
+
+func TestHandler_IgnoresOtherBranchesAndEvents(t *testing.T) {
+	t.Helper()
+	otherBranch := `{"ref":"refs/heads/feature","repository":{"clone_url":"https://git.example.com/acme/deploy.git"}}`
+	code, triggered := serve(t, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(otherBranch)}, otherBranch)
+	if code != http.StatusOK || triggered {
+		t.Errorf("push to another branch: expected status %d without a poll, got status %d, triggered %t", http.StatusOK, code, triggered)
+	}
+
+	otherRepo := `{"ref":"refs/heads/main","repository":{"clone_url":"https://git.example.com/acme/other.git"}}`
+	code, triggered = serve(t, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(otherRepo)}, otherRepo)
+	if code != http.StatusOK || triggered {
+		t.Errorf("push to another repository: expected status %d without a poll, got status %d, triggered %t", http.StatusOK, code, triggered)
+	}
+
+	tag := `{"ref":"refs/tags/main","repository":{"clone_url":"https://git.example.com/acme/deploy.git"}}`
+	code, triggered = serve(t, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(tag)}, tag)
+	if code != http.StatusOK || triggered {
+		t.Errorf("tag push: expected status %d without a poll, got status %d, triggered %t", http.StatusOK, code, triggered)
+	}
+
+	ping := `{"zen":"Keep it logically awesome."}`
+	code, triggered = serve(t, map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": "sha256=" + sign(ping)}, ping)
+	if code != http.StatusOK || triggered {
+		t.Errorf("ping event: expected status %d without a poll, got status %d, triggered %t", http.StatusOK, code, triggered)
+	}
+
+	code, triggered = serve(t, map[string]string{"Content-Type": "application/json"}, `{}`)
+	if code != http.StatusBadRequest || triggered {
+		t.Errorf("unknown provider: expected status %d without a poll, got status %d, triggered %t", http.StatusBadRequest, code, triggered)
+	}
+}
+
+func TestPush_MatchesRepository(t *testing.T) {
+	t.Helper()
+	push := Push{Repositories: []string{"https://git.example.com/Acme/Deploy", "git@git.example.com:acme/deploy.git"}, Ref: "refs/heads/main"}
+	for repoURL, expected := range map[string]bool{
+		"https://git.example.com/acme/deploy.git":        true,
+		"https://token@git.example.com/acme/deploy/":     true,
+		"ssh://git@git.example.com:22/acme/deploy.git":   true,
+		"git@git.example.com:acme/deploy":                true,
+		"https://git.example.com/acme/deploy-config.git": false,
+		"https://mirror.example.com/acme/deploy.git":     false,
+	} {
+		if matched := push.MatchesRepository(repoURL); matched != expected {
+			t.Errorf("MatchesRepository(%q) = %t, expected %t", repoURL, matched, expected)
+		}
+	}
+	if (Push{Ref: "refs/heads/main"}).MatchesRepository("https://git.example.com/acme/deploy.git") {
+		t.Error("expected a push without repository URLs to match no repository")
+	}
+}
@@ -1,6 +1,20 @@
 package utils
 
+import "net/url"
+
 // Placeholder for utility functions
 func Helper() {
 	// To be implemented
 }
+
+// RedactURL returns rawURL without the credentials of its user info, so that
+// a repository URL can be logged or served. Other strings, such as the scp-like
+// git@host:org/repo.git, are returned as is.
+func RedactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil {
+		return rawURL
+	}
+	parsed.User = nil
+	return parsed.String()
+}